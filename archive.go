@@ -0,0 +1,267 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// archiveOutput writes the result of the run into an archive instead of a
+// folder when -dest is a .zip file, or a tar stream to stdout when -dest is
+// -, e.g. to pipe it to tar -x over ssh. The plain copies go into the archive
+// straight from the sources. The files produced on the way (processed
+// samples, links to duplicates, the manifest...) are written to a staging
+// folder standing for the destination, then moved into the archive once
+// their group is done so only one group is staged at a time.
+type archiveOutput struct {
+	// mu serializes the writes, the archive entries are written one at a time
+	mu sync.Mutex
+	// path is the archive file, staging the folder standing for -dest
+	path, staging string
+	// file is the archive being written, nil for stdout
+	file *os.File
+	w    archiveWriter
+}
+
+// archiveWriter writes the entries of an archive in its format.
+type archiveWriter interface {
+	// add writes an entry, r is the content of the file or the target of the
+	// link described by fi
+	add(name string, fi os.FileInfo, r io.Reader) (int64, error)
+	Close() error
+}
+
+// stdoutDest is the -dest writing a tar stream to stdout.
+const stdoutDest = "-"
+
+// destArchive is the archive the run writes to, nil when -dest is a folder.
+var destArchive *archiveOutput
+
+// isArchiveDest reports if the destination is an archive file.
+func isArchiveDest(path string) bool {
+	return path == stdoutDest || strings.EqualFold(filepath.Ext(path), ".zip")
+}
+
+// createArchive creates the archive at path. A zip archive is written next to
+// it under a temporary name and renamed when it's complete.
+func createArchive(path string) (*archiveOutput, error) {
+	staging, err := os.MkdirTemp("", "samplesorter-archive")
+	if err != nil {
+		return nil, err
+	}
+	a := &archiveOutput{path: path, staging: staging}
+	if path == stdoutDest {
+		a.w = tarArchive{tar.NewWriter(os.Stdout)}
+		return a, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	if a.file, err = os.CreateTemp(filepath.Dir(path), ".samplesorter-archive"); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	a.w = zipArchive{zip.NewWriter(a.file)}
+	return a, nil
+}
+
+// name returns the name in the archive of path, a file of the staging folder.
+func (a *archiveOutput) name(path string) string {
+	rel, err := filepath.Rel(a.staging, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// manifest returns the run manifest with the destinations as names in the archive.
+func (a *archiveOutput) manifest(run runManifest) runManifest {
+	run.Files = append([]manifestEntry(nil), run.Files...)
+	for i, entry := range run.Files {
+		run.Files[i].Destination = a.name(entry.Destination)
+		if entry.Link != "" {
+			run.Files[i].Link = a.name(entry.Link)
+		}
+	}
+	run.Failed = append([]failedEntry(nil), run.Failed...)
+	for i, entry := range run.Failed {
+		run.Failed[i].Destination = a.name(entry.Destination)
+	}
+	return run
+}
+
+// archiveWriteError is returned when writing an entry of the archive failed
+// once it was started. The archive can't take the entry back so it isn't
+// retried, which would add it twice.
+type archiveWriteError struct{ err error }
+
+func (e archiveWriteError) Error() string { return e.err.Error() }
+
+func (e archiveWriteError) Unwrap() error { return e.err }
+
+// copyFile adds the file at src to the archive as dst, a path of the staging folder.
+func (a *archiveOutput) copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	n, err := a.add(a.name(dst), fi, in)
+	runMetrics.written.Add(n)
+	if err != nil {
+		return archiveWriteError{err}
+	}
+	return nil
+}
+
+// add writes an entry to the archive. The staged files are counted in the
+// written bytes when they're written to the staging folder.
+func (a *archiveOutput) add(name string, fi os.FileInfo, r io.Reader) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.w.add(name, fi, r)
+}
+
+type zipArchive struct{ *zip.Writer }
+
+func (z zipArchive) add(name string, fi os.FileInfo, r io.Reader) (int64, error) {
+	header, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return 0, err
+	}
+	header.Name = name
+	if fi.Mode().IsRegular() {
+		header.Method = zip.Deflate
+	}
+	w, err := z.CreateHeader(header)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(w, r)
+}
+
+type tarArchive struct{ *tar.Writer }
+
+func (t tarArchive) add(name string, fi os.FileInfo, r io.Reader) (int64, error) {
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := io.ReadAll(r)
+		if err != nil {
+			return 0, err
+		}
+		link = string(target)
+	}
+	header, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return 0, err
+	}
+	header.Name = name
+	if err := t.WriteHeader(header); err != nil {
+		return 0, err
+	}
+	if !fi.Mode().IsRegular() {
+		return 0, nil
+	}
+	// the size is in the header, a file growing while it's copied is cut
+	n, err := io.CopyN(t, r, fi.Size())
+	if err != nil {
+		// pad the entry to its size so the entries after it can be read
+		io.CopyN(t, zeros{}, fi.Size()-n)
+	}
+	return n, err
+}
+
+// zeros reads an endless stream of zeros.
+type zeros struct{}
+
+func (zeros) Read(b []byte) (int, error) {
+	clear(b)
+	return len(b), nil
+}
+
+// addFolder moves the files staged in dir into the archive, the subfolders
+// are left for their own group.
+func (a *archiveOutput) addFolder(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := a.addStaged(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addStaged moves the staged file at path into the archive.
+func (a *archiveOutput) addStaged(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		_, err = a.add(a.name(path), fi, strings.NewReader(filepath.ToSlash(target)))
+		if err != nil {
+			return err
+		}
+		return os.Remove(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	_, err = a.add(a.name(path), fi, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// close moves what's left in the staging folder into the archive, completes
+// it and renames the archive file to its final name.
+func (a *archiveOutput) close() error {
+	defer os.RemoveAll(a.staging)
+	err := filepath.Walk(a.staging, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		return a.addStaged(path)
+	})
+	if cerr := a.w.Close(); err == nil {
+		err = cerr
+	}
+	if a.file == nil {
+		return err
+	}
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(a.file.Name())
+		return err
+	}
+	// the temporary files are only readable by their owner
+	os.Chmod(a.file.Name(), 0644)
+	return os.Rename(a.file.Name(), a.path)
+}
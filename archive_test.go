@@ -0,0 +1,196 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTarArchive(t *testing.T) {
+	var out bytes.Buffer
+	a := &archiveOutput{path: stdoutDest, staging: t.TempDir(), w: tarArchive{tar.NewWriter(&out)}}
+	src := filepath.Join(t.TempDir(), "kick.wav")
+	if err := os.WriteFile(src, []byte("kick"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	group := filepath.Join(a.staging, "drums", "001")
+	if err := os.MkdirAll(group, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.copyFile(src, filepath.Join(group, "kick.wav")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(group, "kick_L.wav"), []byte("left"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("kick.wav", filepath.Join(group, "kick copy.wav")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.addFolder(group); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(group, "kick_L.wav")); !os.IsNotExist(err) {
+		t.Error("the staged file is left in the staging folder once archived")
+	}
+	if err := a.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := map[string]string{}
+	r := tar.NewReader(&out)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(r)
+		if header.Typeflag == tar.TypeSymlink {
+			content = []byte("-> " + header.Linkname)
+		}
+		entries[header.Name] = string(content)
+	}
+	want := map[string]string{
+		"drums/001/kick.wav":      "kick",
+		"drums/001/kick_L.wav":    "left",
+		"drums/001/kick copy.wav": "-> kick.wav",
+	}
+	if len(entries) != len(want) {
+		t.Errorf("archived %v; want %v", entries, want)
+	}
+	for name, content := range want {
+		if entries[name] != content {
+			t.Errorf("%s archived as %q; want %q", name, entries[name], content)
+		}
+	}
+	if _, err := os.Stat(a.staging); !os.IsNotExist(err) {
+		t.Error("the staging folder is left once the archive is closed")
+	}
+}
+
+func TestZipArchive(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "out", "samples.zip")
+	a, err := createArchive(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "kick.wav")
+	if err := os.WriteFile(src, []byte("kick"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	group := filepath.Join(a.staging, "001")
+	if err := os.MkdirAll(group, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.copyFile(src, filepath.Join(group, "kick.wav")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(a.staging, "manifest.json"), []byte("{}"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Error("the archive has its final name before it's complete")
+	}
+	if err := a.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.OpenReader(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	entries := map[string]string{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		entries[f.Name] = string(content)
+	}
+	if len(entries) != 2 || entries["001/kick.wav"] != "kick" || entries["manifest.json"] != "{}" {
+		t.Errorf("archived %v; want 001/kick.wav and manifest.json", entries)
+	}
+	if files, _ := filepath.Glob(filepath.Join(filepath.Dir(dest), ".samplesorter-archive*")); len(files) > 0 {
+		t.Errorf("temporary archives left: %v", files)
+	}
+}
+
+// failingReader returns its content then fails.
+type failingReader struct{ r io.Reader }
+
+func (f failingReader) Read(b []byte) (int, error) {
+	n, err := f.r.Read(b)
+	if err == io.EOF {
+		return n, errors.New("read failed")
+	}
+	return n, err
+}
+
+func TestTarArchiveFailedEntry(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "kick.wav")
+	if err := os.WriteFile(src, []byte("kick"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	w := tarArchive{tar.NewWriter(&out)}
+	if _, err := w.add("broken.wav", fi, failingReader{strings.NewReader("ki")}); err == nil {
+		t.Fatal("no error for a file failing to be read")
+	}
+	if _, err := w.add("kick.wav", fi, strings.NewReader("kick")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the entries after the failed one are still readable
+	r := tar.NewReader(&out)
+	var names []string
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	if strings.Join(names, " ") != "broken.wav kick.wav" {
+		t.Errorf("entries %v; want broken.wav and kick.wav", names)
+	}
+}
+
+func TestSpaceCheckPath(t *testing.T) {
+	defer func(a *archiveOutput) { destArchive = a }(destArchive)
+	dir := filepath.Join("dest", "001")
+	tests := []struct {
+		archive *archiveOutput
+		want    string
+		ok      bool
+	}{
+		{nil, dir, true},
+		{&archiveOutput{path: filepath.Join("out", "samples.zip"), file: os.Stdout}, filepath.Join("out", "samples.zip"), true},
+		{&archiveOutput{path: stdoutDest}, "", false},
+	}
+	for _, tt := range tests {
+		destArchive = tt.archive
+		if got, ok := spaceCheckPath(dir); got != tt.want || ok != tt.ok {
+			t.Errorf("spaceCheckPath(%q) with archive %+v = %q, %v; want %q, %v", dir, tt.archive, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// WAVE format tags we know how to handle.
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+// audioChunk is a chunk as found in a RIFF or IFF container.
+type audioChunk struct {
+	id string
+	// offset is the position of the chunk header in the file
+	offset int64
+	// size is the size of the chunk data as declared in its header
+	size int64
+}
+
+// audioInfo describes the layout and format of a WAV or AIFF file as declared in its header.
+type audioInfo struct {
+	// container is WAVE, AIFF or AIFC
+	container string
+	fileSize  int64
+	// declaredSize is the size found in the RIFF/FORM header
+	declaredSize int64
+	chunks       []audioChunk
+	// truncated is set when a chunk claims more bytes than the file holds
+	truncated bool
+
+	// formatTag is the WAVE format tag, resolved from the sub format for extensible files
+	formatTag uint16
+	// compression is the AIFC compression type
+	compression string
+	channels    int
+	sampleRate  int
+	bitDepth    int
+	blockAlign  int
+	// frames is the frame count declared in the AIFF COMM chunk
+	frames     int64
+	dataOffset int64
+	dataSize   int64
+	bigEndian  bool
+	float      bool
+}
+
+// chunk returns the first chunk with the given id or nil.
+func (info *audioInfo) chunk(id string) *audioChunk {
+	for i := range info.chunks {
+		if info.chunks[i].id == id {
+			return &info.chunks[i]
+		}
+	}
+	return nil
+}
+
+// readAudioInfo parses the header and chunk layout of the WAV or AIFF file at path.
+// Structural problems are recorded in the returned info and are reported by problems,
+// an error is only returned when the file can't be read or isn't a WAV/AIFF file.
+func readAudioInfo(path string) (*audioInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	info := &audioInfo{fileSize: fi.Size(), dataOffset: -1}
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, fmt.Errorf("file too short to be a WAV or AIFF file")
+	}
+	var order binary.ByteOrder
+	switch {
+	case string(header[:4]) == "RIFF" && string(header[8:]) == "WAVE":
+		order = binary.LittleEndian
+	case string(header[:4]) == "FORM" && (string(header[8:]) == "AIFF" || string(header[8:]) == "AIFC"):
+		order = binary.BigEndian
+		info.bigEndian = true
+	default:
+		return nil, fmt.Errorf("not a WAV or AIFF file")
+	}
+	info.container = string(header[8:])
+	info.declaredSize = int64(order.Uint32(header[4:8]))
+
+	offset := int64(12)
+	chunkHeader := make([]byte, 8)
+	for offset+8 <= info.fileSize {
+		if _, err := f.ReadAt(chunkHeader, offset); err != nil {
+			return nil, err
+		}
+		c := audioChunk{id: string(chunkHeader[:4]), offset: offset, size: int64(order.Uint32(chunkHeader[4:]))}
+		info.chunks = append(info.chunks, c)
+		if offset+8+c.size > info.fileSize {
+			info.truncated = true
+		}
+		body := make([]byte, 0)
+		switch c.id {
+		case "fmt ", "COMM":
+			if c.size > 64 || info.truncated {
+				break
+			}
+			body = make([]byte, c.size)
+			if _, err := f.ReadAt(body, offset+8); err != nil {
+				return nil, err
+			}
+		}
+		switch c.id {
+		case "fmt ":
+			info.parseFmt(body)
+		case "COMM":
+			info.parseComm(body)
+		case "data":
+			info.dataOffset = offset + 8
+			info.dataSize = c.size
+		case "SSND":
+			ssnd := make([]byte, 8)
+			if _, err := f.ReadAt(ssnd, offset+8); err == nil {
+				skip := int64(binary.BigEndian.Uint32(ssnd))
+				info.dataOffset = offset + 16 + skip
+				info.dataSize = c.size - 8 - skip
+			}
+		}
+		if info.truncated {
+			break
+		}
+		// chunks are word aligned
+		offset += 8 + c.size + c.size%2
+	}
+	return info, nil
+}
+
+// dataRange returns the offset and size of the complete frames of audio data
+// present in the file.
+func (info *audioInfo) dataRange() (offset, size int64, err error) {
+	if info.dataOffset < 0 {
+		return 0, 0, fmt.Errorf("no audio data found")
+	}
+	if info.blockAlign <= 0 {
+		return 0, 0, fmt.Errorf("invalid block alignment of %d bytes", info.blockAlign)
+	}
+	if info.dataOffset > info.fileSize || info.dataSize < 0 {
+		return 0, 0, fmt.Errorf("audio data offset past the end of its chunk or file")
+	}
+	size = min(info.dataSize, info.fileSize-info.dataOffset)
+	return info.dataOffset, size - size%int64(info.blockAlign), nil
+}
+
+// sampleWidth returns the number of bytes taken by a sample of a channel in
+// the audio data, which can be more than the bit depth needs.
+func (info *audioInfo) sampleWidth() int {
+	if info.channels > 0 && info.blockAlign > 0 && info.blockAlign%info.channels == 0 {
+		return info.blockAlign / info.channels
+	}
+	return (info.bitDepth + 7) / 8
+}
+
+func (info *audioInfo) parseFmt(b []byte) {
+	if len(b) < 16 {
+		return
+	}
+	info.formatTag = binary.LittleEndian.Uint16(b)
+	info.channels = int(binary.LittleEndian.Uint16(b[2:]))
+	info.sampleRate = int(binary.LittleEndian.Uint32(b[4:]))
+	info.blockAlign = int(binary.LittleEndian.Uint16(b[12:]))
+	info.bitDepth = int(binary.LittleEndian.Uint16(b[14:]))
+	if info.formatTag == wavFormatExtensible && len(b) >= 26 {
+		// the first 2 bytes of the sub format GUID are the actual format tag
+		info.formatTag = binary.LittleEndian.Uint16(b[24:])
+	}
+	info.float = info.formatTag == wavFormatFloat
+}
+
+func (info *audioInfo) parseComm(b []byte) {
+	if len(b) < 18 {
+		return
+	}
+	info.channels = int(binary.BigEndian.Uint16(b))
+	info.frames = int64(binary.BigEndian.Uint32(b[2:]))
+	info.bitDepth = int(binary.BigEndian.Uint16(b[6:]))
+	info.sampleRate = int(decodeExtended(b[8:18]))
+	info.blockAlign = info.channels * ((info.bitDepth + 7) / 8)
+	info.compression = "NONE"
+	if info.container == "AIFC" && len(b) >= 22 {
+		info.compression = string(b[18:22])
+	}
+	switch info.compression {
+	case "sowt":
+		info.bigEndian = false
+	case "fl32", "FL32", "fl64", "FL64":
+		info.float = true
+	}
+}
+
+// duration returns the duration of the audio data.
+func (info *audioInfo) duration() (time.Duration, bool) {
+	if info.sampleRate <= 0 {
+		return 0, false
+	}
+	frames := info.frames
+	if info.container == "WAVE" {
+		if info.blockAlign <= 0 || info.dataOffset < 0 {
+			return 0, false
+		}
+		frames = info.dataSize / int64(info.blockAlign)
+	}
+	return time.Duration(float64(frames) / float64(info.sampleRate) * float64(time.Second)), true
+}
+
+// decodeExtended decodes the 80 bit IEEE 754 extended float used by AIFF for the sample rate.
+func decodeExtended(b []byte) float64 {
+	exp := int(binary.BigEndian.Uint16(b) & 0x7FFF)
+	mantissa := binary.BigEndian.Uint64(b[2:])
+	if exp == 0 && mantissa == 0 {
+		return 0
+	}
+	v := math.Ldexp(float64(mantissa), exp-16383-63)
+	if b[0]&0x80 != 0 {
+		v = -v
+	}
+	return v
+}
+
+// supportedCodec reports if the audio data is in a format we know how to read.
+func (info *audioInfo) supportedCodec() bool {
+	if info.container == "WAVE" {
+		switch info.formatTag {
+		case wavFormatPCM:
+			return info.bitDepth >= 8 && info.bitDepth <= 32
+		case wavFormatFloat:
+			return info.bitDepth == 32 || info.bitDepth == 64
+		}
+		return false
+	}
+	switch info.compression {
+	case "NONE", "twos", "sowt":
+		return info.bitDepth >= 8 && info.bitDepth <= 32
+	case "fl32", "FL32":
+		return info.bitDepth == 32
+	case "fl64", "FL64":
+		return info.bitDepth == 64
+	}
+	return false
+}
+
+// codecName returns a human readable name of the audio codec.
+func (info *audioInfo) codecName() string {
+	if info.container == "WAVE" {
+		switch info.formatTag {
+		case wavFormatPCM:
+			return "PCM"
+		case wavFormatFloat:
+			return "IEEE float"
+		}
+		return fmt.Sprintf("format 0x%04X", info.formatTag)
+	}
+	switch info.compression {
+	case "NONE", "twos", "sowt":
+		return "PCM"
+	case "fl32", "FL32", "fl64", "FL64":
+		return "IEEE float"
+	}
+	return fmt.Sprintf("compression %q", info.compression)
+}
+
+// problems returns a description of every inconsistency found in the file layout.
+// An empty list means the file looks sane.
+func (info *audioInfo) problems() []string {
+	var problems []string
+	formatChunk, dataChunk := "fmt", "data"
+	if info.container != "WAVE" {
+		formatChunk, dataChunk = "COMM", "SSND"
+	}
+	if info.declaredSize+8 != info.fileSize {
+		problems = append(problems, fmt.Sprintf("container size says %d bytes but the file is %d bytes", info.declaredSize+8, info.fileSize))
+	}
+	if info.truncated {
+		problems = append(problems, "file is truncated, the last chunk extends past the end of the file")
+	}
+	if info.channels == 0 || info.sampleRate == 0 || info.bitDepth == 0 || info.blockAlign <= 0 {
+		problems = append(problems, fmt.Sprintf("missing or invalid %s chunk", formatChunk))
+	} else if !info.supportedCodec() {
+		problems = append(problems, fmt.Sprintf("unsupported codec %s (%d bit)", info.codecName(), info.bitDepth))
+	}
+	if info.dataOffset < 0 {
+		problems = append(problems, fmt.Sprintf("missing %s chunk", dataChunk))
+		return problems
+	}
+	switch {
+	case info.dataSize < 0:
+		problems = append(problems, fmt.Sprintf("%s chunk data offset points past the end of the chunk", dataChunk))
+	case info.dataSize == 0:
+		problems = append(problems, "zero-length audio data")
+	}
+	if available := info.fileSize - info.dataOffset; available < 0 {
+		problems = append(problems, fmt.Sprintf("%s chunk data starts past the end of the file", dataChunk))
+	} else if info.dataSize > available {
+		problems = append(problems, fmt.Sprintf("%s chunk claims %d bytes but only %d are present", dataChunk, info.dataSize, available))
+	}
+	if info.blockAlign > 0 && info.dataSize%int64(info.blockAlign) != 0 {
+		problems = append(problems, fmt.Sprintf("%s chunk size isn't a multiple of the %d byte frame size", dataChunk, info.blockAlign))
+	}
+	if info.container != "WAVE" && info.blockAlign > 0 && info.dataSize >= 0 && info.frames*int64(info.blockAlign) != info.dataSize {
+		problems = append(problems, fmt.Sprintf("COMM declares %d frames but SSND holds %d", info.frames, info.dataSize/int64(info.blockAlign)))
+	}
+	return problems
+}
+
+// quirks returns the departures from the specs of the audio file which the
+// players tolerate, so they aren't reported as problems, but -repair fixes.
+func (info *audioInfo) quirks() []string {
+	var quirks []string
+	if info.container == "WAVE" && info.formatTag == wavFormatFloat && info.chunk("fact") == nil {
+		quirks = append(quirks, "missing fact chunk required for non-PCM data")
+	}
+	return quirks
+}
+
+// needsRepair reports if -repair would write a corrected copy of the file.
+func (info *audioInfo) needsRepair() bool {
+	return len(info.problems())+len(info.quirks()) > 0 && info.repairable()
+}
+
+// checkAudioFile returns an error describing the problems found in the audio file at path.
+// Files that can be repaired are considered valid when repairing is enabled.
+func checkAudioFile(path string) error {
+	info, err := readAudioInfo(path)
+	if err != nil {
+		return err
+	}
+	if problems := info.problems(); len(problems) > 0 && !(*flagRepair && info.repairable()) {
+		return fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// readChunk returns the data of a chunk of the audio file at path, cut short
+// when the chunk size claims more bytes than the file has left.
+func readChunk(path string, c *audioChunk) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := c.size
+	if left := fi.Size() - c.offset - 8; size > left {
+		size = max(left, 0)
+	}
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, c.offset+8); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
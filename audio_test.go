@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testAiff writes an AIFF file made of a COMM chunk with the given fields and
+// an SSND chunk with the offset field and data to a temporary folder and
+// returns its path.
+func testAiff(t *testing.T, channels, frames, bitDepth, sampleRate int, offset uint32, data []byte) string {
+	t.Helper()
+	be := binary.BigEndian
+	b := []byte("FORM\x00\x00\x00\x00AIFFCOMM\x00\x00\x00\x12")
+	b = be.AppendUint16(b, uint16(channels))
+	b = be.AppendUint32(b, uint32(frames))
+	b = be.AppendUint16(b, uint16(bitDepth))
+	b = append(b, encodeExtended(float64(sampleRate))...)
+	b = append(b, "SSND"...)
+	b = be.AppendUint32(b, uint32(8+len(data)))
+	b = be.AppendUint32(b, offset)
+	b = be.AppendUint32(b, 0)
+	b = append(b, data...)
+	be.PutUint32(b[4:], uint32(len(b)-8))
+	path := filepath.Join(t.TempDir(), "test.aif")
+	if err := os.WriteFile(path, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAudioProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		file func(t *testing.T) string
+		// problem is a part of the expected problem, none when empty
+		problem string
+	}{
+		{"wav", func(t *testing.T) string {
+			return testWav(t, wavFormatPCM, 2, 44100, 4, 16, make([]byte, 16))
+		}, ""},
+		{"float wav without fact", func(t *testing.T) string {
+			return testWav(t, wavFormatFloat, 1, 44100, 4, 32, make([]byte, 16))
+		}, ""},
+		{"aiff", func(t *testing.T) string {
+			return testAiff(t, 1, 4, 16, 44100, 0, make([]byte, 8))
+		}, ""},
+		{"truncated wav", func(t *testing.T) string {
+			path := testWav(t, wavFormatPCM, 1, 44100, 2, 16, make([]byte, 16))
+			if err := os.Truncate(path, 50); err != nil {
+				t.Fatal(err)
+			}
+			return path
+		}, "truncated"},
+		{"zero block alignment", func(t *testing.T) string {
+			return testWav(t, wavFormatPCM, 1, 44100, 0, 16, make([]byte, 16))
+		}, "invalid fmt chunk"},
+		{"partial frame", func(t *testing.T) string {
+			return testWav(t, wavFormatPCM, 2, 44100, 4, 16, make([]byte, 6))
+		}, "multiple of the 4 byte frame size"},
+		{"unsupported codec", func(t *testing.T) string {
+			return testWav(t, 2, 1, 44100, 2, 16, make([]byte, 16))
+		}, "unsupported codec"},
+		{"aiff frame count", func(t *testing.T) string {
+			return testAiff(t, 1, 10, 16, 44100, 0, make([]byte, 8))
+		}, "COMM declares 10 frames"},
+		{"ssnd offset past the chunk", func(t *testing.T) string {
+			return testAiff(t, 1, 4, 16, 44100, 1000, make([]byte, 8))
+		}, "offset points past the end of the chunk"},
+	}
+	for _, tt := range tests {
+		info, err := readAudioInfo(tt.file(t))
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		problems := strings.Join(info.problems(), ", ")
+		if tt.problem == "" && problems != "" || !strings.Contains(problems, tt.problem) {
+			t.Errorf("%s: problems %q; want %q", tt.name, problems, tt.problem)
+		}
+	}
+}
+
+func TestDecodeSSNDOffsetPastFile(t *testing.T) {
+	path := testAiff(t, 1, 4, 16, 44100, 1000, make([]byte, 8))
+	if _, _, err := decodeAudioFile(path); err == nil {
+		t.Error("decoded an AIFF file whose SSND offset points past the end of the file")
+	}
+}
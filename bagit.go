@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bagPayloadFolder is the folder of a BagIt bag holding the files, the bag
+// description is written around it.
+const bagPayloadFolder = "data"
+
+// bagPathEncoder escapes the characters the BagIt manifests can't hold in paths.
+var bagPathEncoder = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+
+// writeBag makes root a BagIt bag (RFC 8493) of the files of its data folder
+// for their long term archival. The payload manifest lists their SHA-256
+// digests, bag-info.txt describes the run and the tag manifest covers the
+// other files of root, like the run manifest.
+func writeBag(root string) error {
+	var lines []string
+	var octets, count int64
+	err := filepath.Walk(filepath.Join(root, bagPayloadFolder), func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		// the links to duplicates are listed with the content of their target
+		digest, size, err := hashFileMode(path, "full")
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, digest+" "+bagPathEncoder.Replace(filepath.ToSlash(rel)))
+		octets += size
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(lines)
+	files := map[string]string{
+		"bagit.txt":           "BagIt-Version: 1.0\nTag-File-Character-Encoding: UTF-8\n",
+		"manifest-sha256.txt": strings.Join(lines, "\n") + "\n",
+		"bag-info.txt":        bagInfo(octets, count),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0666); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	var tags []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, "tagmanifest-") {
+			continue
+		}
+		digest, _, err := hashFileMode(filepath.Join(root, name), "full")
+		if err != nil {
+			return err
+		}
+		tags = append(tags, digest+" "+bagPathEncoder.Replace(name))
+	}
+	return os.WriteFile(filepath.Join(root, "tagmanifest-sha256.txt"), []byte(strings.Join(tags, "\n")+"\n"), 0666)
+}
+
+// redactedFlags are the flags whose values can hold secrets, like the tokens of
+// the webhook URLs or of the commands run by the hooks, and are left out of a
+// bag meant to be shared.
+var redactedFlags = map[string]bool{
+	"webhook":     true,
+	"chatWebhook": true,
+	"preHook":     true,
+	"postHook":    true,
+	"fileHook":    true,
+	"groupHook":   true,
+}
+
+// bagInfo returns the content of bag-info.txt, with the parameters of the run.
+func bagInfo(octets, count int64) string {
+	var b strings.Builder
+	field := func(label, value string) {
+		// the values can't span lines without an indented continuation
+		fmt.Fprintf(&b, "%s: %s\n", label, strings.ReplaceAll(value, "\n", "\n  "))
+	}
+	field("Bagging-Date", time.Now().Format("2006-01-02"))
+	field("Bag-Software-Agent", "sampleSorter "+version)
+	field("Payload-Oxum", strconv.FormatInt(octets, 10)+"."+strconv.FormatInt(count, 10))
+	field("Bag-Size", formatSize(octets))
+	field("External-Description", fmt.Sprintf("Samples matching %q found in %s", currentRun.Keyword, currentRun.Source))
+	field("Source-Path", currentRun.Source)
+	if currentRun.Keyword != "" {
+		field("Keyword", currentRun.Keyword)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		if redactedFlags[f.Name] {
+			value = "<redacted>"
+		}
+		field("Run-Parameter", "-"+f.Name+"="+value)
+	})
+	return b.String()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+)
+
+// benchSamples measures the performance of the main stages of a run on the
+// audio files of sourcePath: walking the folder, hashing and copying the files.
+// The copies are written to a temporary folder in destDir which is removed
+// afterwards. Pass -max to only hash and copy a subset of the files.
+func benchSamples(sourcePath, destDir string) {
+	fmt.Printf("Go %s, %s/%s, %d CPUs\n", runtime.Version(), runtime.GOOS, runtime.GOARCH, runtime.NumCPU())
+
+	start := time.Now()
+	var entries int
+	var samples []string
+	err := filepath.Walk(sourcePath, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		entries++
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".wav", ".aif", ".aiff":
+			if !fi.IsDir() {
+				samples = append(samples, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		errorf("Failed to walk the source folder - %s", err)
+		os.Exit(exitFatal)
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("Walk:  %d entries, %d samples in %s (%.0f entries/s)\n", entries, len(samples), elapsed.Round(time.Millisecond), float64(entries)/elapsed.Seconds())
+	if *flagMax > 0 && len(samples) > *flagMax {
+		samples = samples[:*flagMax]
+	}
+	if len(samples) == 0 {
+		return
+	}
+
+	start = time.Now()
+	var hashed int64
+	for _, path := range samples {
+		_, n, err := hashFile(path)
+		if err != nil {
+			errorf("Failed to hash %s - %s", path, err)
+		}
+		hashed += n
+	}
+	printRate("Hash: ", len(samples), hashed, time.Since(start))
+
+	tmpDir, err := os.MkdirTemp(destDir, ".samplesorter-bench")
+	if err != nil {
+		errorf("Failed to create the benchmark folder - %s", err)
+		os.Exit(exitFatal)
+	}
+	defer os.RemoveAll(tmpDir)
+	start = time.Now()
+	var copied int64
+	for i, path := range samples {
+		dest := filepath.Join(tmpDir, fmt.Sprintf("%d%s", i, filepath.Ext(path)))
+		if err := copyFileContents(path, dest, map[string]bool{}); err != nil {
+			errorf("Failed to copy %s - %s", path, err)
+			continue
+		}
+		if fi, err := os.Stat(dest); err == nil {
+			copied += fi.Size()
+		}
+	}
+	printRate("Copy: ", len(samples), copied, time.Since(start))
+}
+
+// printRate prints the throughput of a benchmark stage.
+func printRate(stage string, files int, size int64, elapsed time.Duration) {
+	fmt.Printf("%s %d files, %s in %s (%.1f files/s, %s/s)\n", stage, files, formatSize(size), elapsed.Round(time.Millisecond),
+		float64(files)/elapsed.Seconds(), formatSize(int64(float64(size)/elapsed.Seconds())))
+}
+
+// startProfiling starts the CPU profiling if enabled and returns a function
+// stopping it and writing the memory profile if enabled.
+func startProfiling() func() {
+	if *flagCPUProfile != "" {
+		f, err := os.Create(*flagCPUProfile)
+		if err != nil {
+			errorf("Failed to create the CPU profile - %s", err)
+			os.Exit(exitFatal)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			errorf("Failed to start the CPU profile - %s", err)
+			os.Exit(exitFatal)
+		}
+	}
+	return func() {
+		if *flagCPUProfile != "" {
+			pprof.StopCPUProfile()
+		}
+		if *flagMemProfile != "" {
+			f, err := os.Create(*flagMemProfile)
+			if err != nil {
+				errorf("Failed to create the memory profile - %s", err)
+				return
+			}
+			defer f.Close()
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				errorf("Failed to write the memory profile - %s", err)
+			}
+		}
+	}
+}
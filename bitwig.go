@@ -0,0 +1,173 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// bitwigExt is the extension of the Bitwig multisample containers.
+const bitwigExt = ".multisample"
+
+// bitwigMultisample is the multisample.xml document of a Bitwig multisample.
+type bitwigMultisample struct {
+	XMLName     xml.Name `xml:"multisample"`
+	Name        string   `xml:"name,attr"`
+	Generator   string   `xml:"generator"`
+	Category    string   `xml:"category"`
+	Creator     string   `xml:"creator"`
+	Description string   `xml:"description"`
+	Keywords    struct{} `xml:"keywords"`
+	Layer       struct {
+		Name    string         `xml:"name,attr"`
+		Samples []bitwigSample `xml:"sample"`
+	} `xml:"layer"`
+}
+
+// bitwigSample is a zone of a Bitwig multisample.
+type bitwigSample struct {
+	File        string `xml:"file,attr"`
+	Gain        string `xml:"gain,attr"`
+	SampleStart string `xml:"sample-start,attr"`
+	SampleStop  string `xml:"sample-stop,attr"`
+	Tune        string `xml:"tune,attr"`
+	// ZoneLogic is round-robin for the variations of a hit, always-play otherwise
+	ZoneLogic string `xml:"zone-logic,attr"`
+	Key       struct {
+		Root  int    `xml:"root,attr"`
+		Track string `xml:"track,attr"`
+		Tune  string `xml:"tune,attr"`
+		Low   int    `xml:"low,attr"`
+		High  int    `xml:"high,attr"`
+	} `xml:"key"`
+	Velocity struct {
+		Low  int `xml:"low,attr"`
+		High int `xml:"high,attr"`
+	} `xml:"velocity"`
+	Loop struct {
+		Mode  string `xml:"mode,attr"`
+		Start int64  `xml:"start,attr"`
+		Stop  int64  `xml:"stop,attr"`
+	} `xml:"loop"`
+}
+
+// bitwigSampleOf returns the Bitwig sample of a zone of a sampler patch.
+func bitwigSampleOf(z samplerZone) bitwigSample {
+	var b bitwigSample
+	b.File = z.File
+	b.Gain, b.Tune, b.ZoneLogic = "0.00", "0.0", "always-play"
+	if z.RoundRobin > 0 {
+		b.ZoneLogic = "round-robin"
+	}
+	b.SampleStart, b.SampleStop = "0.000", fmt.Sprintf("%d.000", z.Frames)
+	b.Key.Root, b.Key.Track, b.Key.Tune, b.Key.Low, b.Key.High = z.Root, "1.0", "0.0", z.KeyLow, z.KeyHigh
+	b.Velocity.Low, b.Velocity.High = z.VelocityLow, z.VelocityHigh
+	b.Loop.Mode, b.Loop.Stop = "off", z.Frames
+	if z.LoopEnd > 0 {
+		b.Loop.Mode, b.Loop.Start, b.Loop.Stop = "sustain", z.LoopStart, z.LoopEnd
+	}
+	return b
+}
+
+// writeBitwigMultisample writes a Bitwig .multisample of the unit to dir when
+// its files make a sample set, the multisample.xml mapping them and their
+// copies zipped together. dests are the copies of the files of the unit, empty
+// for the files that failed to be copied, and names the names used in dir.
+func writeBitwigMultisample(dir string, unit, dests []string, names map[string]bool) error {
+	name, ok := sampleSet(unit)
+	if !ok {
+		return nil
+	}
+	zones, err := sampleSetZones(unit)
+	if err != nil {
+		return err
+	}
+	doc := bitwigMultisample{Name: name, Generator: "sampleSorter"}
+	doc.Layer.Name = "Default"
+	var srcs, entries []string
+	for i, z := range zones {
+		if dests[i] == "" {
+			continue
+		}
+		// the copy may have been trimmed or resampled on the way, the
+		// source values are kept when it can't be read, like a FLAC file
+		z.File = filepath.Base(dests[i])
+		if _, _, err := readZoneAudio(&z, dests[i]); err != nil {
+			debugf("Couldn't read the length and loop of %s - %s", dests[i], err)
+		}
+		doc.Layer.Samples = append(doc.Layer.Samples, bitwigSampleOf(z))
+		srcs, entries = append(srcs, dests[i]), append(entries, z.File)
+	}
+	if len(srcs) == 0 {
+		return nil
+	}
+	if category, ok := classify(unit[0]); ok {
+		doc.Category = categoryName(category)
+	}
+	data, err := xml.MarshalIndent(doc, "", "   ")
+	if err != nil {
+		return err
+	}
+
+	dest := filepath.Join(dir, uniqueDestName(dir, destFilename(name+bitwigExt), names))
+	if err := writeInstrumentZip(dest, "multisample.xml", data, srcs, entries); err != nil {
+		return err
+	}
+	fileLog.Printf("Wrote the Bitwig multisample %s of %d files", dest, len(srcs))
+	return nil
+}
+
+// writeInstrumentZip writes an instrument of the samplers zipping its XML
+// document doc, named docName, with the sample files srcs stored under the
+// entries names. Nothing is left at dest when it fails.
+func writeInstrumentZip(dest, docName string, doc []byte, srcs, entries []string) error {
+	f, err := createDestFile(dest)
+	if err != nil {
+		return err
+	}
+	w := zip.NewWriter(f)
+	entry, err := w.CreateHeader(&zip.FileHeader{Name: docName, Method: zip.Deflate, Modified: time.Now()})
+	if err == nil {
+		_, err = entry.Write(append([]byte(xml.Header), doc...))
+	}
+	for i, src := range srcs {
+		if err != nil {
+			break
+		}
+		err = addZipFile(w, src, entries[i])
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := closeDestFile(f); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(encryptedPath(dest))
+	}
+	return err
+}
+
+// addZipFile adds the file at src to the zip as name, stored since audio
+// barely compresses.
+func addZipFile(w *zip.Writer, src, name string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	entry, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: fi.ModTime()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, in)
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteBitwigMultisample(t *testing.T) {
+	srcDir, dir := t.TempDir(), t.TempDir()
+	var unit, dests []string
+	for _, name := range []string{"Piano_C3.wav", "Piano_E3.wav", "Piano_G3.wav"} {
+		src := filepath.Join(srcDir, name)
+		data, err := os.ReadFile(testWav(t, wavFormatPCM, 1, 44100, 2, 16, make([]byte, 8)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(src, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		unit = append(unit, src)
+		// the copies are trimmed and renamed on the way
+		dest := filepath.Join(dir, "trimmed_"+name)
+		if data, err = os.ReadFile(testWav(t, wavFormatPCM, 1, 44100, 2, 16, make([]byte, 4))); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dest, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		dests = append(dests, dest)
+	}
+	// the last file failed to be copied
+	dests[2] = ""
+	if err := writeBitwigMultisample(dir, unit, dests, map[string]bool{}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.OpenReader(filepath.Join(dir, "Piano"+bitwigExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var doc bitwigMultisample
+	entries := map[string]bool{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		if f.Name == "multisample.xml" {
+			if err := xml.Unmarshal(content, &doc); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if want, _ := os.ReadFile(filepath.Join(dir, f.Name)); string(content) != string(want) {
+			t.Errorf("%s doesn't hold the copy", f.Name)
+		}
+		entries[f.Name] = true
+	}
+	if len(entries) != 2 || !entries["trimmed_Piano_C3.wav"] || !entries["trimmed_Piano_E3.wav"] {
+		t.Errorf("entries %v; want the 2 copies", entries)
+	}
+	if len(doc.Layer.Samples) != 2 {
+		t.Fatalf("%d samples; want 2", len(doc.Layer.Samples))
+	}
+	for _, s := range doc.Layer.Samples {
+		if !entries[s.File] || s.SampleStop != "2.000" {
+			t.Errorf("sample %s of %s frames; want a copy of 2 frames", s.File, s.SampleStop)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sumsFilename is the file listing the digests of a group folder with -checksums sums.
+const sumsFilename = "SHA256SUMS"
+
+// sidecarExt is the extension of the digest files written next to the copies
+// with -checksums sidecar.
+const sidecarExt = ".sha256"
+
+// writeChecksums writes the SHA-256 digests of the files of the group folder
+// dir and its subfolders in the sha256sum format, to a .sha256 sidecar per
+// file or to a single SHA256SUMS file depending on mode, so they can be
+// checked later with sha256sum -c or the verify command. The files covered
+// by a previous run aren't hashed again unless they were written by this one.
+func writeChecksums(dir, mode string) error {
+	sumsPath := filepath.Join(dir, sumsFilename)
+	sums := map[string]string{}
+	if mode == "sums" {
+		var err error
+		if sums, err = readSums(sumsPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// the start of the run rounded down to the 2 seconds precision of the
+	// FAT modification times
+	runStart := currentRun.Started.Truncate(2 * time.Second)
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := entry.Name()
+		// the links to duplicates are covered by the digest of their target
+		if !entry.Type().IsRegular() || name == sumsFilename || strings.HasSuffix(name, sidecarExt) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		fi, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		// the sidecars are next to their file, which they name without its folder
+		sidecar := path + sidecarExt
+		if fi.ModTime().Before(runStart) {
+			if _, ok := sums[rel]; ok {
+				return nil
+			}
+			if _, err := os.Stat(sidecar); mode == "sidecar" && err == nil {
+				return nil
+			}
+		}
+		digest, _, err := hashFileMode(path, "full")
+		if err != nil {
+			return err
+		}
+		sums[rel] = digest
+		if mode == "sidecar" {
+			if err := os.WriteFile(sidecar, []byte(sumLine(digest, name)), 0666); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || mode != "sums" {
+		return err
+	}
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(sumLine(sums[name], name))
+	}
+	return os.WriteFile(sumsPath, []byte(b.String()), 0666)
+}
+
+// sumLine formats a line of the sha256sum format.
+func sumLine(digest, name string) string {
+	return digest + "  " + name + "\n"
+}
+
+// readSums reads a file in the sha256sum format and returns the digests by file name.
+func readSums(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return map[string]string{}, err
+	}
+	defer f.Close()
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		digest, name, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || len(digest) != 64 || len(name) < 2 {
+			continue
+		}
+		// binary mode entries have a * before the name instead of a space
+		sums[name[1:]] = strings.ToLower(digest)
+	}
+	return sums, scanner.Err()
+}
+
+// verifyChecksums walks root and checks the files listed in the SHA256SUMS
+// and .sha256 files found, printing the ones which are missing or don't
+// match. It returns the number of failures.
+func verifyChecksums(root string) int {
+	checked, failed := 0, 0
+	check := func(dir string, sums map[string]string) {
+		names := make([]string, 0, len(sums))
+		for name := range sums {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			checked++
+			digest, _, err := hashFileMode(path, "full")
+			switch {
+			case os.IsNotExist(err):
+				fmt.Println(colorize(colorRed, "MISSING"), path)
+				failed++
+			case err != nil:
+				fmt.Println(colorize(colorRed, "FAILED"), path, "-", err)
+				failed++
+			case digest != sums[name]:
+				fmt.Println(colorize(colorRed, "CHANGED"), path)
+				failed++
+			default:
+				debugf("OK %s", path)
+			}
+		}
+	}
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || fi.Name() != sumsFilename && !strings.HasSuffix(fi.Name(), sidecarExt) {
+			return nil
+		}
+		sums, err := readSums(path)
+		if err != nil {
+			errorf("Failed to read %s - %s", path, err)
+			failed++
+			return nil
+		}
+		check(filepath.Dir(path), sums)
+		return nil
+	})
+	if err != nil {
+		errorf("Something went wrong looking for checksum files - %s", err)
+		failed++
+	}
+	fmt.Printf("%d files checked, %d failed\n", checked, failed)
+	return failed
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteChecksums(t *testing.T) {
+	defer func(started time.Time) { currentRun.Started = started }(currentRun.Started)
+	currentRun.Started = time.Now()
+	stale := strings.Repeat("0", 64)
+	digest := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	for _, mode := range []string{"sums", "sidecar"} {
+		dir := t.TempDir()
+		// old.wav was copied by a previous run and new.wav rewritten by this one
+		for _, name := range []string{"old.wav", "new.wav"} {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		previous := currentRun.Started.Add(-time.Hour)
+		if err := os.Chtimes(filepath.Join(dir, "old.wav"), previous, previous); err != nil {
+			t.Fatal(err)
+		}
+		if mode == "sums" {
+			sums := sumLine(stale, "new.wav") + sumLine(stale, "old.wav")
+			if err := os.WriteFile(filepath.Join(dir, sumsFilename), []byte(sums), 0666); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			for _, name := range []string{"old.wav", "new.wav"} {
+				if err := os.WriteFile(filepath.Join(dir, name+sidecarExt), []byte(sumLine(stale, name)), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := writeChecksums(dir, mode); err != nil {
+			t.Fatal(err)
+		}
+
+		sums := map[string]string{}
+		for _, name := range []string{"old.wav", "new.wav"} {
+			path := filepath.Join(dir, sumsFilename)
+			if mode == "sidecar" {
+				path = filepath.Join(dir, name+sidecarExt)
+			}
+			s, err := readSums(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sums[name] = s[name]
+		}
+		// the digests of the previous run aren't hashed again
+		if sums["new.wav"] != digest("new.wav") || sums["old.wav"] != stale {
+			t.Errorf("%s: digests %v; want new.wav hashed again and old.wav kept", mode, sums)
+		}
+	}
+}
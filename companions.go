@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// companionExts are the extensions of the files DAWs and samplers write next
+// to the samples: Ableton analysis files, REAPER peaks, Kontakt instruments
+// and text notes.
+var companionExts = []string{".asd", ".reapeaks", ".nki", ".txt"}
+
+// isCompanionFile reports if the file name is a companion file of a sample.
+func isCompanionFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, companion := range companionExts {
+		if ext == companion {
+			return true
+		}
+	}
+	return false
+}
+
+// companionFiles returns the companion files of the sample at src, named
+// after its full name like kick.wav.asd or after its stem like kick.asd.
+func companionFiles(src string) []string {
+	var paths []string
+	stem := strings.TrimSuffix(src, filepath.Ext(src))
+	for _, ext := range companionExts {
+		for _, path := range []string{src + ext, stem + ext} {
+			if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// companionDest returns the path of the copy of the companion file at path of
+// the sample at src, named after the copy of the sample at dest. The copies
+// compressed to FLAC get the companion files of the file the decode command
+// restores, which Ableton and the others pair with it, rather than ones like
+// kick.flac.asd.
+func companionDest(src, path, dest string) string {
+	if ext := filepath.Ext(src); strings.EqualFold(filepath.Ext(dest), ".flac") && !strings.EqualFold(ext, ".flac") {
+		dest = strings.TrimSuffix(dest, filepath.Ext(dest)) + ext
+	}
+	if strings.HasPrefix(path, src) {
+		return dest + path[len(src):]
+	}
+	stem := strings.TrimSuffix(src, filepath.Ext(src))
+	return strings.TrimSuffix(dest, filepath.Ext(dest)) + path[len(stem):]
+}
+
+// copyCompanions copies the companion files of the sample at src next to its
+// copy at dest, renamed after it. The companion files already there are left
+// alone.
+func copyCompanions(src, dest string) error {
+	for _, path := range companionFiles(src) {
+		dst := companionDest(src, path, dest)
+		if _, err := os.Stat(encryptedPath(dst)); err == nil {
+			continue
+		}
+		if *flagDryRun {
+			infof("Copying %s to %s", path, dst)
+			continue
+		}
+		if err := copyCompanion(path, dst); err != nil {
+			return err
+		}
+		debugf("Copied the companion file %s to %s", path, dst)
+	}
+	return nil
+}
+
+// copyCompanion copies the companion file at src to dst, encrypted like the
+// samples and removed if the run is interrupted before it's complete.
+func copyCompanion(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := createDestFile(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		cerr := closeDestFile(out)
+		if err == nil {
+			err = cerr
+		}
+	}()
+	_, err = io.Copy(out, in)
+	return err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCopyCompanions(t *testing.T) {
+	srcDir := t.TempDir()
+	for _, name := range []string{"Kick.wav", "Kick.wav.asd", "Kick.txt", "Kick.reapeaks", "Snare.wav"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		dest string
+		want []string
+	}{
+		{"kick_2.wav", []string{"kick_2.reapeaks", "kick_2.txt", "kick_2.wav", "kick_2.wav.asd"}},
+		// the companions of the FLAC copies are the ones of the restored file
+		{"Kick.flac", []string{"Kick.flac", "Kick.reapeaks", "Kick.txt", "Kick.wav.asd"}},
+	}
+	for _, tt := range tests {
+		destDir := t.TempDir()
+		dest := filepath.Join(destDir, tt.dest)
+		if err := os.WriteFile(dest, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := copyCompanions(filepath.Join(srcDir, "Kick.wav"), dest); err != nil {
+			t.Fatal(err)
+		}
+		entries, _ := os.ReadDir(destDir)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		sort.Strings(names)
+		if strings.Join(names, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("companions of %s: %v; want %v", tt.dest, names, tt.want)
+		}
+		if data, _ := os.ReadFile(filepath.Join(destDir, strings.TrimSuffix(tt.dest, filepath.Ext(tt.dest))+".txt")); string(data) != "Kick.txt" {
+			t.Errorf("companion copied as %q; want the content of Kick.txt", data)
+		}
+	}
+
+	// the companions already at the destination are left alone
+	destDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(destDir, "Kick.txt"), []byte("notes"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyCompanions(filepath.Join(srcDir, "Kick.wav"), filepath.Join(destDir, "Kick.wav")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(destDir, "Kick.txt")); string(data) != "notes" {
+		t.Errorf("existing companion overwritten with %q", data)
+	}
+}
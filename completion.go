@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// flagChoices are the values offered by the completions for the flags taking
+// one of a few values. The other flags complete file names.
+func flagChoices() map[string][]string {
+	policies := []string{}
+	for name := range versionPolicies {
+		policies = append(policies, name)
+	}
+	sort.Strings(policies)
+	fields := []string{}
+	for name := range templateFields {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return map[string][]string{
+		"archiveFormat": {"flac"},
+		"checksums":     {"sidecar", "sums"},
+		"clipped":       {"skip", "quarantine"},
+		"color":         {"auto", "always", "never"},
+		"crate":         {"serato", "rekordbox"},
+		"layout":        {layoutFlat, layoutPreserve, layoutHybrid},
+		"hashMode":      {"full", "audio"},
+		"licenses":      {"group", "folder"},
+		"listFormat":    {"text", "json"},
+		"looped":        {"yes", "no"},
+		"noteMap":       {"json", "csv", "midnam"},
+		"samplerPatch":  {"json", "xml"},
+		"dupeLinks":     {"sym", "hard"},
+		"versionPolicy": policies,
+		"groupBy":       fields,
+	}
+}
+
+// isBoolFlag reports if the flag doesn't take a value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// printCompletion writes the completion script of the shell to w.
+func printCompletion(w io.Writer, shell string) error {
+	name := filepath.Base(os.Args[0])
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, name)
+	case "zsh":
+		writeZshCompletion(w, name)
+	case "fish":
+		writeFishCompletion(w, name)
+	default:
+		return fmt.Errorf("unsupported shell %q, use bash, zsh or fish", shell)
+	}
+	return nil
+}
+
+func commandNames() []string {
+	names := []string{}
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	return names
+}
+
+func writeBashCompletion(w io.Writer, name string) {
+	fn := "_" + strings.ReplaceAll(name, "-", "_")
+	var flags []string
+	flag.VisitAll(func(f *flag.Flag) { flags = append(flags, "-"+f.Name) })
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintln(w, `	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+	fmt.Fprintln(w, `	case "$prev" in`)
+	choices := flagChoices()
+	for _, flagName := range sortedKeys(choices) {
+		fmt.Fprintf(w, "\t-%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", flagName, strings.Join(choices[flagName], " "))
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, `	if [[ $cur == -* ]]; then`)
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(flags, " "))
+	fmt.Fprintln(w, `	elif [[ $COMP_CWORD -eq 1 ]]; then`)
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(commandNames(), " "))
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintf(w, "complete -o default -F %s %s\n", fn, name)
+}
+
+func writeZshCompletion(w io.Writer, name string) {
+	escape := strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`).Replace
+	fmt.Fprintf(w, "#compdef %s\n\n_%s() {\n\t_arguments \\\n", name, name)
+	var cmds []string
+	for _, cmd := range commands {
+		cmds = append(cmds, fmt.Sprintf(`%s\:"%s"`, cmd.name, strings.ReplaceAll(escape(cmd.description), `"`, `\"`)))
+	}
+	fmt.Fprintf(w, "\t\t'1::command:((%s))' \\\n", strings.Join(cmds, " "))
+	choices := flagChoices()
+	flag.VisitAll(func(f *flag.Flag) {
+		spec := fmt.Sprintf("-%s[%s]", f.Name, escape(f.Usage))
+		switch {
+		case isBoolFlag(f):
+		case choices[f.Name] != nil:
+			spec += fmt.Sprintf(":%s:(%s)", f.Name, strings.Join(choices[f.Name], " "))
+		default:
+			spec += fmt.Sprintf(":%s:_files", f.Name)
+		}
+		fmt.Fprintf(w, "\t\t'%s' \\\n", spec)
+	})
+	fmt.Fprintf(w, "\t\t'*:file:_files'\n}\n\ncompdef _%s %s\n", name, name)
+}
+
+func writeFishCompletion(w io.Writer, name string) {
+	quote := func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+	}
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "complete -c %s -f -n __fish_use_subcommand -a %s -d %s\n", name, cmd.name, quote(cmd.description))
+	}
+	choices := flagChoices()
+	flag.VisitAll(func(f *flag.Flag) {
+		line := fmt.Sprintf("complete -c %s -o %s", name, f.Name)
+		switch {
+		case isBoolFlag(f):
+		case choices[f.Name] != nil:
+			line += " -x -a " + quote(strings.Join(choices[f.Name], " "))
+		default:
+			line += " -r"
+		}
+		fmt.Fprintln(w, line+" -d "+quote(f.Usage))
+	})
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
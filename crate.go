@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// crateFormats are the DJ software libraries -crate can add the copies to.
+var crateFormats = map[string]bool{"serato": true, "rekordbox": true}
+
+// rekordboxFilename is the name of the rekordbox library written to the
+// destination, imported from the rekordbox xml tree of rekordbox.
+const rekordboxFilename = "rekordbox.xml"
+
+// crateTracks returns the absolute paths of the files written to the
+// destination, in the order they were copied.
+func crateTracks() []string {
+	var tracks []string
+	for _, entry := range recordedFiles() {
+		path, err := filepath.Abs(entry.Destination)
+		if err != nil {
+			path = entry.Destination
+		}
+		tracks = append(tracks, path)
+	}
+	return tracks
+}
+
+// writeCrates writes the crates or playlists of the formats listing the files
+// copied to the destination, named after it. The rekordbox library is written
+// to dir, the Serato crates to the Serato library of the home folder when
+// there's one, to dir otherwise.
+func writeCrates(dir, home, name string, formats []string) error {
+	tracks := crateTracks()
+	if len(tracks) == 0 {
+		return nil
+	}
+	for _, format := range formats {
+		var err error
+		switch format {
+		case "serato":
+			err = writeSeratoCrate(dir, home, name, tracks)
+		case "rekordbox":
+			err = writeRekordboxLibrary(dir, name, tracks)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", format, err)
+		}
+	}
+	return nil
+}
+
+// seratoField returns a field of a Serato crate: its tag, the big endian
+// length of its data and the data, made of other fields for the containers.
+func seratoField(tag string, data []byte) []byte {
+	field := make([]byte, 8, 8+len(data))
+	copy(field, tag)
+	binary.BigEndian.PutUint32(field[4:], uint32(len(data)))
+	return append(field, data...)
+}
+
+// seratoString encodes s in UTF-16 big endian like the text of the Serato crates.
+func seratoString(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	data := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.BigEndian.PutUint16(data[2*i:], u)
+	}
+	return data
+}
+
+// seratoPath returns the path of a track as Serato stores it: relative to the
+// root of its volume, with forward slashes.
+func seratoPath(path string) string {
+	path = strings.TrimPrefix(path, filepath.VolumeName(path))
+	return strings.TrimLeft(filepath.ToSlash(path), "/")
+}
+
+// writeSeratoCrate writes the tracks to the Serato crate name under the
+// sampleSorter crate. Serato only finds the tracks of the volume of its
+// library, the files copied to another drive need the crate to be moved to
+// the _Serato_ folder of that drive.
+func writeSeratoCrate(dir, home, name string, tracks []string) error {
+	header := seratoField("vrsn", seratoString("1.0/Serato ScratchLive Crate"))
+	crate := append([]byte(nil), header...)
+	for _, column := range []string{"song", "length", "bpm", "key"} {
+		crate = append(crate, seratoField("ovct", append(seratoField("tvcn", seratoString(column)), seratoField("tvcw", seratoString("0"))...))...)
+	}
+	for _, track := range tracks {
+		crate = append(crate, seratoField("otrk", seratoField("ptrk", seratoString(seratoPath(track))))...)
+	}
+
+	library := filepath.Join(home, "Music", "_Serato_")
+	subcrates := filepath.Join(library, "Subcrates")
+	if _, err := os.Stat(library); err != nil {
+		warnf("No Serato library in %s, copy the crates from %s to the Subcrates folder of yours", library, dir)
+		subcrates = dir
+	}
+	if err := os.MkdirAll(subcrates, 0777); err != nil {
+		return err
+	}
+	// the parent crate must exist for Serato to list the subcrate under it
+	parent := filepath.Join(subcrates, "sampleSorter.crate")
+	if _, err := os.Stat(parent); os.IsNotExist(err) {
+		if err := os.WriteFile(parent, header, 0666); err != nil {
+			return err
+		}
+	}
+	// %% separates the parent crates in the name of a subcrate
+	crateName := "sampleSorter%%" + strings.ReplaceAll(name, "%%", "%") + ".crate"
+	dest := filepath.Join(subcrates, crateName)
+	if err := os.WriteFile(dest, crate, 0666); err != nil {
+		return err
+	}
+	fileLog.Printf("Wrote the Serato crate %s of %d files", dest, len(tracks))
+	return nil
+}
+
+// rekordboxLibrary is a rekordbox XML library, a collection of tracks and
+// playlists referencing them.
+type rekordboxLibrary struct {
+	XMLName xml.Name `xml:"DJ_PLAYLISTS"`
+	Version string   `xml:"Version,attr"`
+	Product struct {
+		Name    string `xml:"Name,attr"`
+		Version string `xml:"Version,attr"`
+		Company string `xml:"Company,attr"`
+	} `xml:"PRODUCT"`
+	Collection struct {
+		Entries int              `xml:"Entries,attr"`
+		Tracks  []rekordboxTrack `xml:"TRACK"`
+	} `xml:"COLLECTION"`
+	Playlists struct {
+		Root rekordboxNode `xml:"NODE"`
+	} `xml:"PLAYLISTS"`
+}
+
+// rekordboxTrack is a track of the collection of a rekordbox library.
+type rekordboxTrack struct {
+	TrackID  int    `xml:"TrackID,attr"`
+	Name     string `xml:"Name,attr"`
+	Kind     string `xml:"Kind,attr"`
+	Location string `xml:"Location,attr"`
+}
+
+// rekordboxNode is a folder (type 0) or a playlist (type 1) of a rekordbox
+// library, the playlists listing their tracks by ID.
+type rekordboxNode struct {
+	Type    int             `xml:"Type,attr"`
+	Name    string          `xml:"Name,attr"`
+	Count   *int            `xml:"Count,attr"`
+	KeyType *int            `xml:"KeyType,attr"`
+	Entries *int            `xml:"Entries,attr"`
+	Nodes   []rekordboxNode `xml:"NODE"`
+	Tracks  []rekordboxKey  `xml:"TRACK"`
+}
+
+// rekordboxKey is a track of a playlist, the ID of a track of the collection.
+type rekordboxKey struct {
+	Key int `xml:"Key,attr"`
+}
+
+// rekordboxLocation returns the file URL rekordbox locates a track by.
+func rekordboxLocation(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		// Windows paths start with their drive
+		path = "/" + path
+	}
+	return "file://localhost" + (&url.URL{Path: path}).EscapedPath()
+}
+
+// writeRekordboxLibrary writes the rekordbox library of the tracks to dir,
+// with a playlist name listing them in the sampleSorter folder.
+func writeRekordboxLibrary(dir, name string, tracks []string) error {
+	var doc rekordboxLibrary
+	doc.Version = "1.0.0"
+	doc.Product.Name, doc.Product.Version, doc.Product.Company = "sampleSorter", version, "sampleSorter"
+	count, keyType, entries := 1, 0, len(tracks)
+	playlist := rekordboxNode{Type: 1, Name: name, KeyType: &keyType, Entries: &entries}
+	for i, track := range tracks {
+		ext := filepath.Ext(track)
+		doc.Collection.Tracks = append(doc.Collection.Tracks, rekordboxTrack{
+			TrackID:  i + 1,
+			Name:     strings.TrimSuffix(filepath.Base(track), ext),
+			Kind:     strings.ToUpper(strings.TrimPrefix(ext, ".")) + " File",
+			Location: rekordboxLocation(track),
+		})
+		playlist.Tracks = append(playlist.Tracks, rekordboxKey{i + 1})
+	}
+	doc.Collection.Entries = len(tracks)
+	folder := rekordboxNode{Type: 0, Name: "sampleSorter", Count: &count, Nodes: []rekordboxNode{playlist}}
+	doc.Playlists.Root = rekordboxNode{Type: 0, Name: "ROOT", Count: &count, Nodes: []rekordboxNode{folder}}
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	data = append(append([]byte(xml.Header), data...), '\n')
+	return os.WriteFile(filepath.Join(dir, rekordboxFilename), data, 0666)
+}
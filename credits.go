@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// creditsFilename is the file written at the root of the destination with -credits.
+const creditsFilename = "CREDITS.md"
+
+// maxInlineLicenseSize is the size up to which the text license and readme
+// files are quoted in the credits instead of only being listed.
+const maxInlineLicenseSize = 64 * 1024
+
+// licensePrefixes are the lowercase prefixes of the names of the license and
+// readme files shipped with the sample packs.
+var licensePrefixes = []string{"license", "licence", "readme", "copying", "eula", "terms", "credits"}
+
+// licenseExts are the extensions of the license and readme files, the text
+// ones can be quoted.
+var licenseExts = map[string]bool{"": true, ".txt": true, ".md": true, ".rtf": false, ".pdf": false, ".html": false, ".htm": false}
+
+// isLicenseFile reports if the file name is a license or readme file.
+func isLicenseFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if _, ok := licenseExts[ext]; !ok {
+		return false
+	}
+	lower := strings.ToLower(name)
+	for _, prefix := range licensePrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// packName returns the name of the sample pack of the source file at path,
+// the top folder under the source root holding it.
+func packName(srcRoot, path string) string {
+	rel, err := filepath.Rel(srcRoot, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		// listed with -fromList outside of the source
+		return filepath.Base(filepath.Dir(path))
+	}
+	if parts := strings.Split(filepath.ToSlash(rel), "/"); len(parts) > 1 {
+		return parts[0]
+	}
+	return filepath.Base(srcRoot)
+}
+
+// licenseFinder finds the license and readme files of the source folders,
+// listing each folder once.
+type licenseFinder struct {
+	srcRoot string
+	folders map[string][]string
+}
+
+func newLicenseFinder(srcRoot string) *licenseFinder {
+	return &licenseFinder{srcRoot: srcRoot, folders: map[string][]string{}}
+}
+
+// find returns the license and readme files found in the folder of the
+// source file at path and its parents up to the source root.
+func (l *licenseFinder) find(path string) []string {
+	var found []string
+	dir := filepath.Dir(path)
+	for {
+		files, ok := l.folders[dir]
+		if !ok {
+			entries, _ := os.ReadDir(dir)
+			for _, entry := range entries {
+				if entry.Type().IsRegular() && isLicenseFile(entry.Name()) {
+					files = append(files, filepath.Join(dir, entry.Name()))
+				}
+			}
+			l.folders[dir] = files
+		}
+		found = append(found, files...)
+		parent := filepath.Dir(dir)
+		if rel, err := filepath.Rel(l.srcRoot, dir); err != nil || rel == "." || strings.HasPrefix(rel, "..") || parent == dir {
+			return found
+		}
+		dir = parent
+	}
+}
+
+// writeCredits writes a CREDITS.md file to dir listing the sample packs the
+// copied files come from with their file counts, and the license and readme
+// files found alongside them, quoting the small text ones.
+func writeCredits(dir string, finder *licenseFinder) error {
+	srcRoot := finder.srcRoot
+	counts := map[string]int{}
+	licenses := map[string]map[string]bool{}
+	for _, entry := range recordedFiles() {
+		pack := packName(srcRoot, entry.Source)
+		counts[pack]++
+		if licenses[pack] == nil {
+			licenses[pack] = map[string]bool{}
+		}
+		for _, path := range finder.find(entry.Source) {
+			licenses[pack][path] = true
+		}
+	}
+	packs := make([]string, 0, len(counts))
+	for pack := range counts {
+		packs = append(packs, pack)
+	}
+	sort.Strings(packs)
+
+	var b strings.Builder
+	fmt.Fprintln(&b, "# Credits")
+	fmt.Fprintln(&b)
+	what := "Samples"
+	if currentRun.Keyword != "" {
+		what = fmt.Sprintf("Samples matching %q", currentRun.Keyword)
+	}
+	fmt.Fprintf(&b, "%s collected from %s on %s.\n\n", what, currentRun.Source, time.Now().Format("2006-01-02"))
+	fmt.Fprintln(&b, "| Pack | Files |")
+	fmt.Fprintln(&b, "| --- | --- |")
+	for _, pack := range packs {
+		fmt.Fprintf(&b, "| %s | %d |\n", strings.ReplaceAll(pack, "|", `\|`), counts[pack])
+	}
+	for _, pack := range packs {
+		if len(licenses[pack]) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s\n", pack)
+		paths := make([]string, 0, len(licenses[pack]))
+		for path := range licenses[pack] {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			fmt.Fprintf(&b, "\n### %s\n\n", filepath.Base(path))
+			fi, err := os.Stat(path)
+			if err != nil || !licenseExts[strings.ToLower(filepath.Ext(path))] || fi.Size() > maxInlineLicenseSize {
+				fmt.Fprintf(&b, "See `%s`.\n", path)
+				continue
+			}
+			text, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&b, "From `%s`:\n\n", path)
+			for _, line := range strings.Split(strings.TrimRight(string(text), "\r\n"), "\n") {
+				fmt.Fprintln(&b, strings.TrimRight("> "+strings.TrimRight(line, "\r"), " "))
+			}
+		}
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, creditsFilename), []byte(b.String()), 0666)
+}
+
+// licenseFolder is the folder of the destination collecting the license and
+// readme files with -licenses folder.
+const licenseFolder = "licenses"
+
+// licenseSource returns the path of the folder holding the license file at
+// path relative to the source root, starting with the pack name.
+func licenseSource(srcRoot, path string) string {
+	dir := filepath.Dir(path)
+	rel, err := filepath.Rel(srcRoot, dir)
+	switch {
+	case err != nil || strings.HasPrefix(rel, ".."):
+		return filepath.Base(dir)
+	case rel == ".":
+		return filepath.Base(srcRoot)
+	}
+	return rel
+}
+
+// copyLicenses copies the license and readme files found with the sources to
+// dir. In a group folder, they're named after the folder they come from, e.g.
+// License (PackA Drums).txt, otherwise they're copied under the same folders
+// as in the source, e.g. PackA/Drums/License.txt. The files already there are
+// left alone.
+func copyLicenses(finder *licenseFinder, sources []string, dir string, inGroup bool) error {
+	done := map[string]bool{}
+	for _, src := range sources {
+		for _, path := range finder.find(src) {
+			if done[path] {
+				continue
+			}
+			done[path] = true
+			from := licenseSource(finder.srcRoot, path)
+			dst := filepath.Join(dir, from, filepath.Base(path))
+			if inGroup {
+				name := filepath.Base(path)
+				ext := filepath.Ext(name)
+				from = strings.ReplaceAll(filepath.ToSlash(from), "/", " ")
+				dst = filepath.Join(dir, fmt.Sprintf("%s (%s)%s", strings.TrimSuffix(name, ext), from, ext))
+			}
+			if _, err := os.Stat(dst); err == nil {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(dst, data, 0666); err != nil {
+				return err
+			}
+			debugf("Copied the license %s to %s", path, dst)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// decentSamplerPreset is the .dspreset document of a DecentSampler instrument.
+type decentSamplerPreset struct {
+	XMLName    xml.Name `xml:"DecentSampler"`
+	MinVersion string   `xml:"minVersion,attr"`
+	Groups     struct {
+		Group struct {
+			Name    string                `xml:"name,attr"`
+			Samples []decentSamplerSample `xml:"sample"`
+		} `xml:"group"`
+	} `xml:"groups"`
+}
+
+// decentSamplerSample is a zone of a DecentSampler instrument.
+type decentSamplerSample struct {
+	Path     string `xml:"path,attr"`
+	RootNote int    `xml:"rootNote,attr"`
+	LoNote   int    `xml:"loNote,attr"`
+	HiNote   int    `xml:"hiNote,attr"`
+	LoVel    int    `xml:"loVel,attr"`
+	HiVel    int    `xml:"hiVel,attr"`
+	// SeqMode is round_robin for the variations of a hit, played in turn
+	// from SeqPosition 1 to SeqLength
+	SeqMode     string `xml:"seqMode,attr,omitempty"`
+	SeqLength   int    `xml:"seqLength,attr,omitempty"`
+	SeqPosition int    `xml:"seqPosition,attr,omitempty"`
+	// LoopEnabled is set with the first and last frames of the loop
+	LoopEnabled string `xml:"loopEnabled,attr,omitempty"`
+	LoopStart   string `xml:"loopStart,attr,omitempty"`
+	LoopEnd     string `xml:"loopEnd,attr,omitempty"`
+}
+
+// decentSamplerPatch returns the DecentSampler preset of the patch, a sample
+// per zone with the round robin variations alternating in sequence.
+func decentSamplerPatch(patch samplerPatch) ([]byte, error) {
+	doc := decentSamplerPreset{MinVersion: "1.0.0"}
+	doc.Groups.Group.Name = patch.Name
+	lengths := roundRobinLengths(patch.Zones)
+	for i, z := range patch.Zones {
+		s := decentSamplerSample{Path: z.File, RootNote: z.Root, LoNote: z.KeyLow, HiNote: z.KeyHigh, LoVel: z.VelocityLow, HiVel: z.VelocityHigh}
+		if lengths[i] > 0 {
+			s.SeqMode, s.SeqLength, s.SeqPosition = "round_robin", lengths[i], z.RoundRobin
+		}
+		if z.LoopEnd > 0 {
+			s.LoopEnabled, s.LoopStart, s.LoopEnd = "true", fmt.Sprint(z.LoopStart), fmt.Sprint(z.LoopEnd-1)
+		}
+		doc.Groups.Group.Samples = append(doc.Groups.Group.Samples, s)
+	}
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// copyVariantPattern matches the endings added to a filename by file managers
+// and DAWs when a file is copied: "kick (1)", "kick copy", "kick copy 2",
+// "kick-2" or "kick_2".
+var copyVariantPattern = regexp.MustCompile(`(?i)(\s*\(\d+\)|\s*-?\s*copy(\s*\d+)?|[-_ ]\d{1,2})$`)
+
+// canonicalName returns the lowercase filename of path without its copy
+// variant endings, e.g. kick.wav for "Kick copy (2).wav".
+func canonicalName(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	for {
+		trimmed := copyVariantPattern.ReplaceAllString(stem, "")
+		if trimmed == stem || trimmed == "" {
+			break
+		}
+		stem = trimmed
+	}
+	return strings.ToLower(strings.TrimSpace(stem)) + ext
+}
+
+// duplicates maps the duplicates to link to the original file they duplicate.
+var duplicates = struct {
+	sync.Mutex
+	originals map[string]string
+}{originals: map[string]string{}}
+
+func setDuplicateOf(path, original string) {
+	duplicates.Lock()
+	defer duplicates.Unlock()
+	duplicates.originals[path] = original
+}
+
+// duplicateOf returns the original of a duplicate to link.
+func duplicateOf(path string) (string, bool) {
+	duplicates.Lock()
+	defer duplicates.Unlock()
+	original, ok := duplicates.originals[path]
+	return original, ok
+}
+
+// linkDuplicate creates a sym or hard link at dst to target, the copy of the
+// original of a duplicate. Symbolic links are relative so the destination can
+// be moved around.
+func linkDuplicate(target, dst string) error {
+	target, dst = encryptedPath(target), encryptedPath(dst)
+	if *flagDryRun {
+		infof("Linking %s to %s", dst, target)
+		return nil
+	}
+	if *flagDupeLinks == "hard" {
+		return os.Link(target, dst)
+	}
+	rel, err := filepath.Rel(filepath.Dir(dst), target)
+	if err != nil {
+		rel = target
+	}
+	return os.Symlink(rel, dst)
+}
+
+// hashFile returns the hex encoded SHA-256 digest of the file at path in the
+// -hashMode and the number of bytes hashed.
+func hashFile(path string) (string, int64, error) {
+	return hashFileMode(path, *flagHashMode)
+}
+
+// hashFileMode returns the digest of the file at path in the hash mode. In audio
+// hash mode, only the samples of the data or SSND chunk of the WAV and AIFF
+// files are hashed so files only differing by their metadata chunks get the
+// same digest.
+func hashFileMode(path, mode string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if mode == "audio" {
+		// the files that aren't WAV/AIFF files are hashed in full
+		if info, err := readAudioInfo(path); err == nil && info.dataOffset >= 0 {
+			size := info.dataSize
+			if info.dataOffset+size > info.fileSize {
+				size = info.fileSize - info.dataOffset
+			}
+			r = io.NewSectionReader(f, info.dataOffset, size)
+		}
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// dedupeLookahead is the number of units read ahead of the dedupe to hash the
+// files with a name and size clash concurrently.
+const dedupeLookahead = 256
+
+// digestCache hashes the files on a bounded number of workers, each file is
+// hashed at most once.
+type digestCache struct {
+	mu      sync.Mutex
+	digests map[string]*pendingDigest
+	workers chan struct{}
+}
+
+type pendingDigest struct {
+	done   chan struct{}
+	digest string
+}
+
+func newDigestCache(workers int) *digestCache {
+	return &digestCache{digests: map[string]*pendingDigest{}, workers: make(chan struct{}, max(workers, 1))}
+}
+
+// start hashes the file at path in the background unless it's already done.
+func (c *digestCache) start(path string) *pendingDigest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.digests[path]; ok {
+		return p
+	}
+	p := &pendingDigest{done: make(chan struct{})}
+	c.digests[path] = p
+	go func() {
+		c.workers <- struct{}{}
+		defer func() { <-c.workers }()
+		d, _, err := hashFile(path)
+		if err != nil {
+			// a file that can't be hashed is never a duplicate
+			d = "unreadable:" + path
+		}
+		p.digest = d
+		close(p.done)
+	}()
+	return p
+}
+
+// get returns the digest of the file at path, waiting for it to be hashed.
+func (c *digestCache) get(path string) string {
+	p := c.start(path)
+	<-p.done
+	return p.digest
+}
+
+// contentSize returns the size of what hashFile digests for the file at path,
+// files with different content sizes can't have the same digest. It returns
+// -1 when the size is unknown.
+func contentSize(path string) int64 {
+	if *flagHashMode == "audio" {
+		if info, err := readAudioInfo(path); err == nil && info.dataOffset >= 0 {
+			return min(info.dataSize, info.fileSize-info.dataOffset)
+		}
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return -1
+	}
+	return fi.Size()
+}
+
+// dedupeName returns the name under which the duplicates of path are looked for.
+func dedupeName(path string) string {
+	name := canonicalName(path)
+	if *flagFingerprint > 0 {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
+
+// sizedUnit is a unit with the content size of its first file.
+type sizedUnit struct {
+	unit []string
+	size int64
+}
+
+// dedupeStream drops the single file units that have the same content as an
+// earlier match with the same canonical name, the first instance is kept.
+// The content is only hashed for the files with a name and size clash, on
+// -hashWorkers workers while the following units are read. The dropped
+// duplicates are listed in the run summary. Pairs are passed through.
+// With -dupeLinks, the duplicates are kept and linked to their original when copied.
+// With -fingerprint, files whose acoustic fingerprints are similar enough are
+// duplicates too, e.g. an AIFF and a WAV encode of the same recording or a
+// trimmed copy under another name. The files of any name are compared when
+// their durations are within fingerprintDurationTolerance.
+func dedupeStream(in <-chan []string) <-chan []string {
+	digests := newDigestCache(*flagHashWorkers)
+	// start hashing the clashing files ahead of the dedupe
+	ahead := make(chan sizedUnit, dedupeLookahead)
+	go func() {
+		defer close(ahead)
+		first := map[string]string{}
+		for unit := range in {
+			if len(unit) != 1 {
+				ahead <- sizedUnit{unit: unit}
+				continue
+			}
+			size := contentSize(unit[0])
+			key := fmt.Sprintf("%s|%d", dedupeName(unit[0]), size)
+			if path, ok := first[key]; ok {
+				digests.start(path)
+				digests.start(unit[0])
+			} else {
+				first[key] = unit[0]
+			}
+			ahead <- sizedUnit{unit: unit, size: size}
+		}
+	}()
+
+	out := make(chan []string)
+	go func() {
+		defer close(out)
+		// kept lists the kept files by canonical name, and byDuration by
+		// second of duration for -fingerprint
+		kept := map[string][]string{}
+		byDuration := map[int64][]string{}
+		sizes := map[string]int64{}
+		fingerprints := map[string][]uint32{}
+		durations := map[string]time.Duration{}
+		fingerprint := func(path string) []uint32 {
+			if fp, ok := fingerprints[path]; ok {
+				return fp
+			}
+			fp, duration, err := fingerprintFile(path)
+			if err != nil {
+				debugf("Couldn't fingerprint %s - %s", path, err)
+			}
+			fingerprints[path] = fp
+			if _, ok := durations[path]; !ok {
+				durations[path] = duration
+			}
+			return fp
+		}
+		// durationOf returns the duration from the header of the WAV and
+		// AIFF files and from fpcalc for the others, 0 when unknown
+		durationOf := func(path string) time.Duration {
+			if d, ok := durations[path]; ok {
+				return d
+			}
+			if info, err := readAudioInfo(path); err == nil {
+				if d, ok := info.duration(); ok {
+					durations[path] = d
+					return d
+				}
+			}
+			fingerprint(path)
+			return durations[path]
+		}
+		similar := func(a, b string) bool {
+			fpA, fpB := fingerprint(a), fingerprint(b)
+			return fpA != nil && fpB != nil && fingerprintSimilarity(fpA, fpB) >= *flagFingerprint
+		}
+		same := func(a, b string) bool {
+			if sizes[a] == sizes[b] || sizes[a] < 0 || sizes[b] < 0 {
+				if digests.get(a) == digests.get(b) {
+					return true
+				}
+			}
+			return *flagFingerprint > 0 && similar(a, b)
+		}
+		// similarDuration returns the kept files of another name whose
+		// duration is close enough to the duration d for their fingerprints
+		// to align
+		similarDuration := func(name string, d time.Duration) []string {
+			var candidates []string
+			for s := int64((d - fingerprintDurationTolerance) / time.Second); s <= int64((d+fingerprintDurationTolerance)/time.Second); s++ {
+				for _, candidate := range byDuration[s] {
+					diff := durationOf(candidate) - d
+					if dedupeName(candidate) != name && diff <= fingerprintDurationTolerance && diff >= -fingerprintDurationTolerance {
+						candidates = append(candidates, candidate)
+					}
+				}
+			}
+			return candidates
+		}
+		for u := range ahead {
+			unit := u.unit
+			if len(unit) != 1 {
+				out <- unit
+				continue
+			}
+			path := unit[0]
+			sizes[path] = u.size
+			name := dedupeName(path)
+			original, ok := findDuplicate(path, kept[name], same)
+			var duration time.Duration
+			if !ok && *flagFingerprint > 0 {
+				if duration = durationOf(path); duration > 0 {
+					original, ok = findDuplicate(path, similarDuration(name, duration), similar)
+				}
+			}
+			if ok {
+				debugf("%s is a duplicate of %s", path, original)
+				if *flagDupeLinks != "" {
+					setDuplicateOf(path, original)
+					out <- unit
+					continue
+				}
+				recordError(errDuplicate, path, fmt.Errorf("duplicate of %s", original))
+				continue
+			}
+			kept[name] = append(kept[name], path)
+			if duration > 0 {
+				second := int64(duration / time.Second)
+				byDuration[second] = append(byDuration[second], path)
+			}
+			out <- unit
+		}
+	}()
+	return out
+}
+
+// findDuplicate returns the first of the candidates with the same content as path.
+func findDuplicate(path string, candidates []string, same func(a, b string) bool) (string, bool) {
+	for _, candidate := range candidates {
+		if same(candidate, path) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// versionPolicies tell if the file a is a better version than the file b.
+var versionPolicies = map[string]func(a, b os.FileInfo, aPath, bPath string) bool{
+	"first": func(a, b os.FileInfo, aPath, bPath string) bool { return false },
+	"newest": func(a, b os.FileInfo, aPath, bPath string) bool {
+		return a.ModTime().After(b.ModTime())
+	},
+	"largest": func(a, b os.FileInfo, aPath, bPath string) bool {
+		return a.Size() > b.Size()
+	},
+	"quality": func(a, b os.FileInfo, aPath, bPath string) bool {
+		ai, err := readAudioInfo(aPath)
+		if err != nil {
+			return false
+		}
+		bi, err := readAudioInfo(bPath)
+		if err != nil {
+			return true
+		}
+		if ai.bitDepth != bi.bitDepth {
+			return ai.bitDepth > bi.bitDepth
+		}
+		return ai.sampleRate > bi.sampleRate
+	},
+}
+
+// versionStream only keeps the best version of the single file units sharing
+// the same filename according to the policy, the other versions are listed in
+// the run summary. Since all the matches are needed to pick a version, the
+// units are only sent once the walk is over, in their original order.
+func versionStream(in <-chan []string, policy string) <-chan []string {
+	out := make(chan []string)
+	better := versionPolicies[policy]
+	label := policy
+	if policy == "quality" {
+		label = "highest quality"
+	}
+	go func() {
+		defer close(out)
+		units := [][]string{}
+		// best is the index of the best version of each filename
+		best := map[string]int{}
+		infos := map[int]os.FileInfo{}
+		for unit := range in {
+			idx := len(units)
+			units = append(units, unit)
+			if len(unit) != 1 {
+				continue
+			}
+			fi, err := os.Stat(unit[0])
+			if err != nil {
+				continue
+			}
+			infos[idx] = fi
+			name := strings.ToLower(filepath.Base(unit[0]))
+			current, ok := best[name]
+			if !ok || better(fi, infos[current], unit[0], units[current][0]) {
+				best[name] = idx
+			}
+		}
+		for idx, unit := range units {
+			if len(unit) == 1 {
+				if b, ok := best[strings.ToLower(filepath.Base(unit[0]))]; ok && b != idx && infos[idx] != nil {
+					recordError(errVersion, unit[0], fmt.Errorf("kept the %s version %s", label, units[b][0]))
+					continue
+				}
+			}
+			out <- unit
+		}
+	}()
+	return out
+}
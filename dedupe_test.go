@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCanonicalName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Kick.wav", "kick.wav"},
+		{"Kick copy.wav", "kick.wav"},
+		{"Kick copy 2.wav", "kick.wav"},
+		{"Kick copy (2).wav", "kick.wav"},
+		{"Snare (1).WAV", "snare.wav"},
+		{"hat-2.wav", "hat.wav"},
+		{"hat_2.wav", "hat.wav"},
+		{"Clap - Copy.wav", "clap.wav"},
+		{filepath.Join("Pack", "Sub", "Tom 3.aif"), "tom.aif"},
+		{"808.wav", "808.wav"},
+		{"01.wav", "01.wav"},
+		{"Ride_100.wav", "ride_100.wav"},
+	}
+	for _, tt := range tests {
+		if got := canonicalName(tt.in); got != tt.want {
+			t.Errorf("canonicalName(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDedupeFingerprintAcrossNames(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake fpcalc is a shell script")
+	}
+	// the fake fpcalc gives all the files the same fingerprint
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho '{\"duration\": 0, \"fingerprint\": [1, 2, 3, 4]}'\n"
+	if err := os.WriteFile(filepath.Join(bin, fpcalc), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	defer func(v float64) { *flagFingerprint = v }(*flagFingerprint)
+	*flagFingerprint = 0.9
+
+	// 10s, 12s and 30s of audio at 100Hz, each with another content
+	dir := t.TempDir()
+	var paths []string
+	for i, name := range []string{"kick.wav", "boom.wav", "long.wav"} {
+		src := testWav(t, wavFormatPCM, 1, 100, 2, 16, bytes.Repeat([]byte{byte(i)}, []int{2000, 2400, 6000}[i]))
+		path := filepath.Join(dir, name)
+		if err := os.Rename(src, path); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	in := make(chan []string, len(paths))
+	for _, path := range paths {
+		in <- []string{path}
+	}
+	close(in)
+	var got []string
+	for unit := range dedupeStream(in) {
+		got = append(got, unit...)
+	}
+	// the files of a too different duration aren't compared
+	if len(got) != 2 || got[0] != paths[0] || got[1] != paths[2] {
+		t.Errorf("kept %v; want %s and %s", got, paths[0], paths[2])
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// diffSamples lists the matches of the source that are new, identical or
+// changed compared to what's in destPath, without copying anything.
+// The copies are found through the manifest of a previous run when there's
+// one, or by filename.
+func diffSamples(sourcePath, destPath string) {
+	// index the destination files by lowercase filename, without the _2, _3...
+	// suffixes added to make the names unique in a group
+	byName := map[string][]string{}
+	filepath.Walk(destPath, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			name := uniqueSuffixPattern.ReplaceAllString(strings.ToLower(fi.Name()), "$1")
+			byName[name] = append(byName[name], path)
+		}
+		return nil
+	})
+	bySource := map[string]string{}
+	if data, err := os.ReadFile(filepath.Join(destPath, manifestFilename)); err == nil {
+		var previous runManifest
+		if err := json.Unmarshal(data, &previous); err != nil {
+			warnf("Ignoring the invalid manifest of the destination - %s", err)
+		}
+		for _, entry := range previous.Files {
+			bySource[entry.Source] = entry.Destination
+		}
+	}
+
+	matches := make(chan string, 64)
+	walkDone := make(chan error, 1)
+	go func() {
+		walkDone <- findMatchingFiles(context.Background(), sourcePath, matches)
+		close(matches)
+	}()
+	counts := map[string]int{}
+	for src := range matches {
+		candidates := byName[uniqueSuffixPattern.ReplaceAllString(strings.ToLower(destFilename(src)), "$1")]
+		if dest, ok := bySource[src]; ok {
+			candidates = []string{dest}
+		}
+		status, detail := diffStatus(src, candidates)
+		counts[status]++
+		if status == "identical" && !*flagDebug {
+			continue
+		}
+		line := fmt.Sprintf("%-9s %s", status, src)
+		if detail != "" {
+			line += " -> " + detail
+		}
+		switch status {
+		case "new":
+			fmt.Println(colorize(colorGreen, line))
+		case "changed":
+			fmt.Println(colorize(colorYellow, line))
+		default:
+			fmt.Println(line)
+		}
+	}
+	if err := <-walkDone; err != nil {
+		errorf("Something went wrong looking for matching files - %s", err)
+		os.Exit(exitFatal)
+	}
+	fmt.Printf("%d new, %d changed, %d identical\n", counts["new"], counts["changed"], counts["identical"])
+}
+
+// uniqueSuffixPattern matches the suffix added by uniqueFilename, capturing the extension.
+var uniqueSuffixPattern = regexp.MustCompile(`_\d+(\.[^.]*)$`)
+
+// diffStatus compares src to the destination files that could be its copy.
+func diffStatus(src string, candidates []string) (status, detail string) {
+	if len(candidates) == 0 {
+		return "new", ""
+	}
+	digest, _, err := hashFile(src)
+	if err != nil {
+		return "unreadable", err.Error()
+	}
+	existing := ""
+	for _, candidate := range candidates {
+		d, _, err := hashFile(candidate)
+		if err != nil {
+			continue
+		}
+		if d == digest {
+			return "identical", candidate
+		}
+		existing = candidate
+	}
+	if existing == "" {
+		return "new", ""
+	}
+	return "changed", existing
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"math"
+)
+
+const (
+	// analysisWindow is the size in frames of the windows used to analyze the audio
+	analysisWindow = 512
+	// silenceDB is the level under which we consider the audio silent
+	silenceDB = -90.0
+)
+
+// mixdown returns the average of the channels of buf.
+func (buf *audioBuffer) mixdown() []float64 {
+	if buf.channels == 1 {
+		return buf.data
+	}
+	mono := make([]float64, buf.frames())
+	for i := range mono {
+		var sum float64
+		for c := 0; c < buf.channels; c++ {
+			sum += buf.data[i*buf.channels+c]
+		}
+		mono[i] = sum / float64(buf.channels)
+	}
+	return mono
+}
+
+// toDB converts a linear amplitude to dBFS, clamped to silenceDB.
+func toDB(v float64) float64 {
+	if v <= 0 {
+		return silenceDB
+	}
+	return math.Max(20*math.Log10(v), silenceDB)
+}
+
+// hannWindow returns the coefficients of a Hann window of size n.
+func hannWindow(n int) []float64 {
+	w := make([]float64, n)
+	for i := range w {
+		w[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+	}
+	return w
+}
+
+// rmsLevels returns the RMS level in dBFS of the successive windows of size
+// frames, each window starting hop frames after the previous one.
+func rmsLevels(samples []float64, size, hop int) []float64 {
+	var levels []float64
+	for start := 0; start+size <= len(samples); start += hop {
+		var sum float64
+		for _, v := range samples[start : start+size] {
+			sum += v * v
+		}
+		levels = append(levels, toDB(math.Sqrt(sum/float64(size))))
+	}
+	return levels
+}
+
+// detectOnsets returns the frame positions of the transients found in buf, a
+// transient being a level rise of at least riseDB within a few milliseconds.
+func detectOnsets(buf *audioBuffer, riseDB float64) []int {
+	hop := analysisWindow / 4
+	levels := rmsLevels(buf.mixdown(), analysisWindow, hop)
+	// transients closer than 50ms are considered to be the same hit
+	minGap := buf.sampleRate / 20
+	var onsets []int
+	last := -minGap
+	if len(levels) > 0 && levels[0] >= -50 {
+		// the audio starts right away with a hit
+		onsets = append(onsets, 0)
+		last = 0
+	}
+	for i := 1; i < len(levels); i++ {
+		// compare against the quietest of the previous windows so slow rises
+		// spread over a few windows are caught too.
+		floor := levels[i-1]
+		for j := i - 2; j >= 0 && j >= i-4; j-- {
+			floor = math.Min(floor, levels[j])
+		}
+		if levels[i] < -50 || levels[i]-floor < riseDB {
+			continue
+		}
+		pos := i * hop
+		if pos-last < minGap {
+			continue
+		}
+		onsets = append(onsets, pos)
+		last = pos
+	}
+	return onsets
+}
+
+// slice returns a copy of the frames of buf between start and end, without
+// the metadata chunks pointing at positions of buf.
+func (buf *audioBuffer) slice(start, end int) *audioBuffer {
+	s := *buf
+	s.data = append([]float64(nil), buf.data[start*buf.channels:end*buf.channels]...)
+	// the loops and cue points are positions in the whole buffer
+	s.chunks = nil
+	for _, c := range buf.chunks {
+		if !isPositionalChunk(c) {
+			s.chunks = append(s.chunks, c)
+		}
+	}
+	return &s
+}
+
+// isPositionalChunk reports if the chunk holds positions in the audio data:
+// the loops of smpl, the cue points and their labels in a LIST adtl chunk.
+func isPositionalChunk(c rawChunk) bool {
+	return c.id == "smpl" || c.id == "cue " || c.id == "LIST" && bytes.HasPrefix(c.data, []byte("adtl"))
+}
+
+// fadeOut applies a linear fade over the last frames of buf to avoid clicks.
+func (buf *audioBuffer) fadeOut(frames int) {
+	total := buf.frames()
+	if frames > total {
+		frames = total
+	}
+	for i := 0; i < frames; i++ {
+		gain := float64(i) / float64(frames)
+		frame := total - 1 - i
+		for c := 0; c < buf.channels; c++ {
+			buf.data[frame*buf.channels+c] *= gain
+		}
+	}
+}
+
+// nonSilentSegments returns the start and end frames of the parts of buf
+// separated by at least minSilence frames under the thresholdDB level.
+func nonSilentSegments(buf *audioBuffer, thresholdDB float64, minSilence int) [][2]int {
+	levels := rmsLevels(buf.mixdown(), analysisWindow, analysisWindow)
+	// keep a few milliseconds of padding around the segments to not cut the attacks and tails
+	padding := buf.sampleRate / 100
+	var segments [][2]int
+	start, silentSince := -1, -1
+	for i, level := range levels {
+		pos := i * analysisWindow
+		if level < thresholdDB {
+			if silentSince < 0 {
+				silentSince = pos
+			}
+			if start >= 0 && pos+analysisWindow-silentSince >= minSilence {
+				segments = append(segments, [2]int{start, silentSince})
+				start = -1
+			}
+			continue
+		}
+		silentSince = -1
+		if start < 0 {
+			start = pos
+		}
+	}
+	if start >= 0 {
+		end := buf.frames()
+		if silentSince >= 0 {
+			end = silentSince
+		}
+		segments = append(segments, [2]int{start, end})
+	}
+	for i := range segments {
+		segments[i][0] = max(segments[i][0]-padding, 0)
+		segments[i][1] = min(segments[i][1]+padding, buf.frames())
+	}
+	return segments
+}
+
+// timeStretch changes the length of buf by ratio without changing its pitch
+// using WSOLA: overlapping windows are taken from the source at the stretched
+// rate, each shifted within a tolerance to best line up with the previous one.
+func timeStretch(buf *audioBuffer, ratio float64) *audioBuffer {
+	size := buf.sampleRate / 25
+	synthesisHop := size / 2
+	analysisHop := float64(synthesisHop) / ratio
+	tolerance := size / 4
+	mono := buf.mixdown()
+	inFrames := buf.frames()
+	outFrames := int(float64(inFrames) * ratio)
+
+	window := make([]float64, size)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size))
+	}
+	out := make([]float64, (outFrames+size)*buf.channels)
+	norm := make([]float64, outFrames+size)
+
+	prev := 0
+	for k := 0; k*synthesisHop < outFrames; k++ {
+		pos := int(float64(k) * analysisHop)
+		if k > 0 {
+			// find the offset lining up best with the natural continuation of the previous window
+			natural := prev + synthesisHop
+			best, bestScore := 0, math.Inf(-1)
+			for delta := -tolerance; delta <= tolerance; delta += 2 {
+				candidate := pos + delta
+				if candidate < 0 || candidate+size > inFrames || natural+size > inFrames {
+					continue
+				}
+				var score float64
+				for n := 0; n < size; n += 4 {
+					score += mono[candidate+n] * mono[natural+n]
+				}
+				if score > bestScore {
+					best, bestScore = delta, score
+				}
+			}
+			pos += best
+		}
+		pos = max(min(pos, inFrames-1), 0)
+		prev = pos
+		for n := 0; n < size && pos+n < inFrames; n++ {
+			o := k*synthesisHop + n
+			for c := 0; c < buf.channels; c++ {
+				out[o*buf.channels+c] += window[n] * buf.data[(pos+n)*buf.channels+c]
+			}
+			norm[o] += window[n]
+		}
+	}
+
+	stretched := *buf
+	stretched.data = out[:outFrames*buf.channels]
+	for i := 0; i < outFrames; i++ {
+		if norm[i] > 1e-3 {
+			for c := 0; c < buf.channels; c++ {
+				stretched.data[i*buf.channels+c] /= norm[i]
+			}
+		}
+	}
+	return &stretched
+}
+
+// resample reads buf ratio times faster using cubic interpolation, raising its
+// pitch by ratio and shortening it accordingly, like a sampler does.
+func resample(buf *audioBuffer, ratio float64) *audioBuffer {
+	inFrames := buf.frames()
+	outFrames := int(float64(inFrames) / ratio)
+	resampled := *buf
+	resampled.data = make([]float64, outFrames*buf.channels)
+	sample := func(frame, c int) float64 {
+		frame = max(min(frame, inFrames-1), 0)
+		return buf.data[frame*buf.channels+c]
+	}
+	for i := 0; i < outFrames; i++ {
+		pos := float64(i) * ratio
+		frame := int(pos)
+		t := pos - float64(frame)
+		for c := 0; c < buf.channels; c++ {
+			// Catmull-Rom spline through the 4 surrounding samples
+			p0, p1, p2, p3 := sample(frame-1, c), sample(frame, c), sample(frame+1, c), sample(frame+2, c)
+			resampled.data[i*buf.channels+c] = p1 + 0.5*t*(p2-p0+t*(2*p0-5*p1+4*p2-p3+t*(3*(p1-p2)+p3-p0)))
+		}
+	}
+	return &resampled
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// encryption describes how the copies are encrypted with -encryptTo, it's
+// recorded in the manifest.
+type encryption struct {
+	// Tool is age or gpg
+	Tool      string `json:"tool"`
+	Recipient string `json:"recipient"`
+}
+
+// destEncryption is set when the copies are encrypted.
+var destEncryption *encryption
+
+// newEncryption returns the encryption to the recipient, an age recipient or
+// SSH public key are encrypted with age, anything else is a GPG key.
+func newEncryption(recipient string) (*encryption, error) {
+	e := &encryption{Tool: "gpg", Recipient: recipient}
+	if strings.HasPrefix(recipient, "age1") || strings.HasPrefix(recipient, "ssh-") {
+		e.Tool = "age"
+	}
+	if _, err := exec.LookPath(e.Tool); err != nil {
+		return nil, fmt.Errorf("encrypting to %s needs %s in the PATH - %s", recipient, e.Tool, err)
+	}
+	return e, nil
+}
+
+// suffix is the extension added to the encrypted files.
+func (e *encryption) suffix() string {
+	return "." + e.Tool
+}
+
+// command returns the command encrypting its stdin to its stdout.
+func (e *encryption) command() *exec.Cmd {
+	if e.Tool == "age" {
+		return exec.Command("age", "--encrypt", "--recipient", e.Recipient)
+	}
+	return exec.Command("gpg", "--batch", "--yes", "--trust-model", "always", "--encrypt", "--recipient", e.Recipient, "--output", "-")
+}
+
+// encryptedPath returns the path of the file written for path, with the
+// suffix of the encryption tool when encrypting.
+func encryptedPath(path string) string {
+	if destEncryption == nil || path == "" {
+		return path
+	}
+	return path + destEncryption.suffix()
+}
+
+// encryptFile encrypts the file at src to the encrypted path of dst.
+func encryptFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	path := encryptedPath(dst)
+	out, err := createTrackedFile(path)
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd := destEncryption.command()
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = in, out, &stderr
+	err = cmd.Run()
+	if err != nil && stderr.Len() > 0 {
+		err = fmt.Errorf("%s failed - %s", destEncryption.Tool, strings.TrimSpace(stderr.String()))
+	}
+	inFlight.Lock()
+	delete(inFlight.files, out)
+	inFlight.Unlock()
+	if err == nil {
+		err = out.Sync()
+	}
+	if fi, serr := out.Stat(); err == nil && serr == nil {
+		runMetrics.written.Add(fi.Size())
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Exit codes, so the scripts wrapping the tool can tell how a run went.
+// Flag parsing errors exit with 2.
+const (
+	exitOK = 0
+	// exitFatal is used when the run can't start or the source can't be searched
+	exitFatal = 1
+	// exitNoMatches is used when no samples matched
+	exitNoMatches = 3
+	// exitPartialFailure is used when some files couldn't be copied or
+	// mirrored, a hook failed or the run was stopped before copying all the matches
+	exitPartialFailure = 4
+	// exitInterrupted is used when the run was interrupted, as shells do for Ctrl-C
+	exitInterrupted = 130
+)
+
+// errorCategory groups the per-file errors in the summary printed at the end of a run.
+type errorCategory string
+
+const (
+	errUnreadable errorCategory = "unreadable source paths"
+	errCorrupt    errorCategory = "corrupt files skipped"
+	errCopy       errorCategory = "failed copies"
+	errMerge      errorCategory = "pairs that couldn't be merged"
+	errHook       errorCategory = "failed hooks"
+	errMirror     errorCategory = "failed mirror copies"
+	// errDuplicate isn't an error as such, the duplicates are listed for review
+	errDuplicate errorCategory = "duplicates skipped"
+	errVersion   errorCategory = "other versions skipped"
+	errClipped   errorCategory = "clipped samples skipped"
+	errSilent    errorCategory = "silent files skipped"
+)
+
+// errorCategories is the order of the categories in the summary.
+var errorCategories = []errorCategory{errUnreadable, errCorrupt, errCopy, errMerge, errHook, errMirror, errDuplicate, errVersion, errClipped, errSilent}
+
+// runErrors collects the per-file errors of the run, they can be recorded
+// by the walk, the copy and the copies that timed out.
+var runErrors = struct {
+	sync.Mutex
+	byCategory map[errorCategory][]string
+}{byCategory: map[errorCategory][]string{}}
+
+// recordError adds an error about path to the run summary.
+func recordError(category errorCategory, path string, err error) {
+	runErrors.Lock()
+	defer runErrors.Unlock()
+	runErrors.byCategory[category] = append(runErrors.byCategory[category], fmt.Sprintf("%s - %s", path, err))
+}
+
+// errorCount returns the number of errors recorded in the categories.
+func errorCount(categories ...errorCategory) int {
+	runErrors.Lock()
+	defer runErrors.Unlock()
+	count := 0
+	for _, category := range categories {
+		count += len(runErrors.byCategory[category])
+	}
+	return count
+}
+
+// printErrorSummary prints the errors recorded during the run by category,
+// they're also written to the log file.
+func printErrorSummary() {
+	runErrors.Lock()
+	defer runErrors.Unlock()
+	var b strings.Builder
+	for _, category := range errorCategories {
+		errs := runErrors.byCategory[category]
+		if len(errs) == 0 {
+			continue
+		}
+		header := fmt.Sprintf("%d %s:", len(errs), category)
+		fmt.Fprintln(messageOutput, colorize(colorRed, header))
+		fmt.Fprintln(&b, header)
+		for _, msg := range errs {
+			fmt.Fprintln(messageOutput, "\t"+msg)
+			fmt.Fprintln(&b, "\t"+msg)
+		}
+	}
+	if b.Len() > 0 {
+		fileLog.Print("Errors:\n" + b.String())
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// probeSize is the amount of data copied to measure the copy throughput.
+const probeSize = 32 << 20
+
+// printEstimate prints what a run copying the groups to destPath would do.
+func printEstimate(groups <-chan *unitGroup, destPath string) {
+	var files, groupCount int
+	var totalSize int64
+	// the first files are used to measure the throughput
+	var probeFiles []string
+	for group := range groups {
+		groupCount++
+		for _, unit := range group.units {
+			size := unitSize(unit)
+			if totalSize < probeSize {
+				probeFiles = append(probeFiles, unit...)
+			}
+			files += len(unit)
+			totalSize += size
+		}
+	}
+	fmt.Printf("Files:       %d\n", files)
+	fmt.Printf("Groups:      %d\n", groupCount)
+	fmt.Printf("Total size:  %s\n", formatSize(totalSize))
+	dir := existingParent(destPath)
+	if available, err := freeSpace(dir); err == nil {
+		fmt.Printf("Free space:  %s\n", formatSize(available))
+	}
+	if totalSize == 0 {
+		return
+	}
+	throughput, err := probeThroughput(probeFiles, dir)
+	if err != nil {
+		fmt.Println("Couldn't measure the copy throughput -", err)
+		return
+	}
+	duration := time.Duration(float64(totalSize) / throughput * float64(time.Second))
+	fmt.Printf("Throughput:  %s/s\n", formatSize(int64(throughput)))
+	fmt.Printf("Duration:    ~%s", duration.Round(time.Second))
+	if len(enabledTransforms()) > 0 || *flagMergePairs {
+		fmt.Print(" (not counting the audio processing)")
+	}
+	fmt.Println()
+}
+
+// probeThroughput copies up to probeSize bytes of the files to a temporary
+// file in dir and returns the measured throughput in bytes/s.
+func probeThroughput(files []string, dir string) (float64, error) {
+	tmp, err := os.CreateTemp(dir, ".samplesorter-probe")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	start := time.Now()
+	var copied int64
+	for _, path := range files {
+		if copied >= probeSize {
+			break
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		n, err := io.CopyN(tmp, in, probeSize-copied)
+		in.Close()
+		copied += n
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start).Seconds()
+	if copied == 0 || elapsed == 0 {
+		return 0, fmt.Errorf("nothing could be copied")
+	}
+	return float64(copied) / elapsed, nil
+}
+
+// existingParent returns path or its closest parent that exists.
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
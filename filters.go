@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// matchesFilters reports if the keyword match at path passes the filter flags
+// and the enabled matchers.
+func matchesFilters(path string, fi os.FileInfo) bool {
+	if !matchesPlugins(path, fi) {
+		return false
+	}
+	if *flagBPM != "" {
+		bpm, ok := filenameBPM(path)
+		if !ok || !inRange(bpm, *flagBPM) {
+			return false
+		}
+	}
+	if *flagHitsPerBar != "" {
+		density, ok := onsetDensity(path)
+		if !ok || !inRange(density, *flagHitsPerBar) {
+			return false
+		}
+	}
+	if *flagClassify {
+		if _, ok := classify(path); !ok {
+			return false
+		}
+	}
+	if kitSlots != nil {
+		if _, ok := kitSlotOf(path); !ok {
+			return false
+		}
+	}
+	if *flagLooped != "" {
+		looped, ok := hasLoopPoints(path)
+		if !ok || looped != (*flagLooped == "yes") {
+			return false
+		}
+	}
+	if (*flagMinPeak != 0 || *flagMinRMS != 0 || *flagMaxNoiseFloor != 0) && !loudEnough(path) {
+		return false
+	}
+	if *flagSkipSilent && silent(path) {
+		recordError(errSilent, path, fmt.Errorf("peaking below %g dBFS", *flagSilenceThreshold))
+		return false
+	}
+	if *flagClipped == "skip" && clipped(path) {
+		recordError(errClipped, path, fmt.Errorf("%d or more consecutive full scale samples", *flagClipRun))
+		return false
+	}
+	if *flagKey != "" {
+		key, ok := filenameKey(path)
+		if !ok || !keyInList(key, *flagKey) {
+			return false
+		}
+	}
+	return true
+}
+
+// keyInList reports if key is one of the keys of the comma separated list.
+func keyInList(key musicalKey, list string) bool {
+	for _, s := range strings.Split(list, ",") {
+		if k, ok := parseKey(s); ok && k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange parses a range like 120-130 or a single value like 120.
+func parseRange(r string) (low, high float64, err error) {
+	parts := strings.SplitN(r, "-", 2)
+	if low, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64); err != nil {
+		return 0, 0, fmt.Errorf("%q isn't a number or a range", r)
+	}
+	high = low
+	if len(parts) == 2 {
+		if high, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64); err != nil {
+			return 0, 0, fmt.Errorf("%q isn't a number or a range", r)
+		}
+	}
+	if high < low {
+		return 0, 0, fmt.Errorf("%q ends before it starts", r)
+	}
+	return low, high, nil
+}
+
+// inRange reports if v is within the range r, which was validated at startup.
+func inRange(v float64, r string) bool {
+	low, high, _ := parseRange(r)
+	return v >= low && v <= high
+}
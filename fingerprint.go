@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"os/exec"
+	"time"
+)
+
+// fpcalc is the Chromaprint command line tool computing the acoustic fingerprints.
+const fpcalc = "fpcalc"
+
+// maxFingerprintShift is the largest offset, in fingerprint items of about
+// 0.12s, tried when aligning two fingerprints so trimmed files still match.
+const maxFingerprintShift = 40
+
+// fingerprintDurationTolerance is the largest difference in duration of the
+// files whose fingerprints are compared, about maxFingerprintShift items.
+const fingerprintDurationTolerance = 5 * time.Second
+
+// fingerprintFile returns the raw Chromaprint fingerprint of the audio file at
+// path and its duration.
+func fingerprintFile(path string) ([]uint32, time.Duration, error) {
+	out, err := exec.Command(fpcalc, "-raw", "-json", path).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return nil, 0, fmt.Errorf("%s failed - %s", fpcalc, exitErr.Stderr)
+		}
+		return nil, 0, err
+	}
+	var result struct {
+		Duration    float64  `json:"duration"`
+		Fingerprint []uint32 `json:"fingerprint"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, 0, fmt.Errorf("unexpected %s output - %s", fpcalc, err)
+	}
+	if len(result.Fingerprint) == 0 {
+		return nil, 0, fmt.Errorf("the file is too short to be fingerprinted")
+	}
+	return result.Fingerprint, time.Duration(result.Duration * float64(time.Second)), nil
+}
+
+// fingerprintSimilarity returns the share of identical bits, between 0 and 1,
+// of the best alignment of the two fingerprints. Unrelated recordings score
+// around 0.5.
+func fingerprintSimilarity(a, b []uint32) float64 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	// the shorter fingerprint needs to overlap the other by half its length
+	minOverlap := (len(a) + 1) / 2
+	best := 0.0
+	for shift := -maxFingerprintShift; shift <= maxFingerprintShift; shift++ {
+		diff, overlap := 0, 0
+		for i := range a {
+			j := i + shift
+			if j < 0 || j >= len(b) {
+				continue
+			}
+			diff += bits.OnesCount32(a[i] ^ b[j])
+			overlap++
+		}
+		if overlap < minOverlap {
+			continue
+		}
+		if similarity := 1 - float64(diff)/float64(32*overlap); similarity > best {
+			best = similarity
+		}
+	}
+	return best
+}
@@ -0,0 +1,919 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// flacBlockSize is the number of frames encoded in each FLAC frame.
+const flacBlockSize = 4096
+
+// flacApplicationID identifies the FLAC metadata block holding what isn't
+// samples in the original file, its header and the chunks after the audio
+// data, so it can be restored bit for bit by the decode command.
+const flacApplicationID = "SSrt"
+
+// flacSourceFlags are the flags of the application block describing how the
+// samples were stored in the original file.
+const (
+	flacBigEndian = 1 << iota
+	flacUnsigned8
+)
+
+// flacEncodable reports if src is copied as a FLAC file with -archiveFormat
+// flac. Only the integer PCM files up to 24 bit which aren't processed or
+// repaired on the way are, the others are copied as is.
+func flacEncodable(src string) bool {
+	if *flagArchiveFormat != "flac" {
+		return false
+	}
+	info, err := readAudioInfo(src)
+	if err != nil || !info.supportedCodec() || info.float {
+		return false
+	}
+	if _, _, err := info.dataRange(); err != nil {
+		return false
+	}
+	width := (info.bitDepth + 7) / 8
+	if width > 3 || info.channels < 1 || info.channels > 8 || info.blockAlign != width*info.channels ||
+		info.sampleRate <= 0 || info.sampleRate >= 1<<20 {
+		return false
+	}
+	if transformsApply(enabledTransforms(), src, info) || *flagRepair && info.needsRepair() {
+		return false
+	}
+	return true
+}
+
+// flacName returns the name of the FLAC file for the file name.
+func flacName(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".flac"
+}
+
+// encodeFlacFile losslessly compresses the WAV or AIFF file at src to a FLAC
+// file at dst. The samples are encoded with the fixed predictors of the
+// format, the rest of the file is kept in an application block.
+func encodeFlacFile(src, dst string) (err error) {
+	info, err := readAudioInfo(src)
+	if err != nil {
+		return err
+	}
+	width := (info.bitDepth + 7) / 8
+	if !info.supportedCodec() || info.float || width > 3 || info.channels < 1 || info.channels > 8 {
+		return fmt.Errorf("can't encode %s (%d bit, %d channels) as FLAC", info.codecName(), info.bitDepth, info.channels)
+	}
+	if info.blockAlign != width*info.channels {
+		return fmt.Errorf("invalid block alignment of %d bytes", info.blockAlign)
+	}
+	start, size, err := info.dataRange()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	// the file may have changed since its header was read
+	if start+size > int64(len(data)) {
+		return fmt.Errorf("%s changed while being encoded", src)
+	}
+
+	var flags byte
+	if info.bigEndian {
+		flags |= flacBigEndian
+	}
+	if width == 1 && info.container == "WAVE" {
+		flags |= flacUnsigned8
+	}
+	samples := decodeIntSamples(data[start:start+size], width, flags)
+
+	out, err := createDestFile(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		cerr := closeDestFile(out)
+		if err == nil {
+			err = cerr
+		}
+	}()
+	w := bufio.NewWriter(out)
+	w.WriteString("fLaC")
+
+	bps := width * 8
+	frames := len(samples) / info.channels
+	h := md5.New()
+	for _, s := range samples {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], uint32(s))
+		h.Write(b[:width])
+	}
+	streamInfo := &bitWriter{}
+	streamInfo.write(flacBlockSize, 16)
+	streamInfo.write(flacBlockSize, 16)
+	// the frame sizes are unknown
+	streamInfo.write(0, 24)
+	streamInfo.write(0, 24)
+	streamInfo.write(uint64(info.sampleRate), 20)
+	streamInfo.write(uint64(info.channels-1), 3)
+	streamInfo.write(uint64(bps-1), 5)
+	streamInfo.write(uint64(frames), 36)
+	writeFlacBlock(w, 0, false, append(streamInfo.bytes(), h.Sum(nil)...))
+
+	var app bytes.Buffer
+	app.WriteString(flacApplicationID)
+	app.WriteByte(flags)
+	ext := filepath.Ext(src)
+	app.WriteByte(byte(len(ext)))
+	app.WriteString(ext)
+	binary.Write(&app, binary.BigEndian, uint32(start))
+	app.Write(data[:start])
+	app.Write(data[start+size:])
+	writeFlacBlock(w, 2, true, app.Bytes())
+
+	channel := make([]int64, flacBlockSize)
+	for num, first := 0, 0; first < frames; num, first = num+1, first+flacBlockSize {
+		n := frames - first
+		if n > flacBlockSize {
+			n = flacBlockSize
+		}
+		fw := &bitWriter{}
+		fw.write(0xFFF8, 16)
+		// the block size follows the header on 16 bits, the sample rate and
+		// size are the ones of the stream info
+		fw.write(0x7, 4)
+		fw.write(0, 4)
+		fw.write(uint64(info.channels-1), 4)
+		fw.write(0, 4)
+		fw.utf8(uint64(num))
+		fw.write(uint64(n-1), 16)
+		fw.write(uint64(flacCRC8(fw.bytes())), 8)
+		for c := 0; c < info.channels; c++ {
+			for i := 0; i < n; i++ {
+				channel[i] = int64(samples[(first+i)*info.channels+c])
+			}
+			writeFlacSubframe(fw, channel[:n], uint(bps))
+		}
+		fw.align()
+		frame := fw.bytes()
+		crc := flacCRC16(frame)
+		w.Write(frame)
+		w.Write([]byte{byte(crc >> 8), byte(crc)})
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+// decodeIntSamples converts raw sample data of width bytes to signed integers.
+func decodeIntSamples(raw []byte, width int, flags byte) []int32 {
+	samples := make([]int32, len(raw)/width)
+	for i := range samples {
+		s := raw[i*width : (i+1)*width]
+		var v int32
+		if flags&flacBigEndian != 0 {
+			for _, b := range s {
+				v = v<<8 | int32(b)
+			}
+		} else {
+			for j := width - 1; j >= 0; j-- {
+				v = v<<8 | int32(s[j])
+			}
+		}
+		if flags&flacUnsigned8 != 0 {
+			v -= 128
+		} else {
+			// sign extension
+			shift := uint(32 - width*8)
+			v = v << shift >> shift
+		}
+		samples[i] = v
+	}
+	return samples
+}
+
+// encodeIntSamples is the reverse of decodeIntSamples.
+func encodeIntSamples(samples []int32, width int, flags byte) []byte {
+	raw := make([]byte, len(samples)*width)
+	for i, v := range samples {
+		if flags&flacUnsigned8 != 0 {
+			v += 128
+		}
+		s := raw[i*width : (i+1)*width]
+		for j := 0; j < width; j++ {
+			b := byte(v >> uint(8*j))
+			if flags&flacBigEndian != 0 {
+				s[width-1-j] = b
+			} else {
+				s[j] = b
+			}
+		}
+	}
+	return raw
+}
+
+func writeFlacBlock(w *bufio.Writer, blockType byte, last bool, data []byte) {
+	if last {
+		blockType |= 0x80
+	}
+	w.Write([]byte{blockType, byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))})
+	w.Write(data)
+}
+
+// writeFlacSubframe encodes the samples of a channel as a constant subframe
+// when they're all the same, or with the fixed predictor leaving the smallest
+// residual, or verbatim when that doesn't make it any smaller.
+func writeFlacSubframe(w *bitWriter, x []int64, bps uint) {
+	constant := true
+	for _, v := range x[1:] {
+		if v != x[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		w.write(0, 8)
+		w.signed(x[0], bps)
+		return
+	}
+	bestOrder, bestSum := -1, uint64(0)
+	residuals := make([][]int64, 5)
+	for order := 0; order <= 4 && order < len(x); order++ {
+		residuals[order] = fixedResidual(x, order)
+		var sum uint64
+		for _, r := range residuals[order] {
+			sum += zigzag(r)
+		}
+		if bestOrder < 0 || sum < bestSum {
+			bestOrder, bestSum = order, sum
+		}
+	}
+	coding := planRice(residuals[bestOrder], len(x), bestOrder)
+	if uint64(bestOrder)*uint64(bps)+coding.bits >= uint64(len(x))*uint64(bps) {
+		w.write(1<<1, 8)
+		for _, v := range x {
+			w.signed(v, bps)
+		}
+		return
+	}
+	w.write(uint64(8|bestOrder)<<1, 8)
+	for _, v := range x[:bestOrder] {
+		w.signed(v, bps)
+	}
+	coding.write(w, residuals[bestOrder])
+}
+
+// fixedResidual returns the residual of the fixed predictor of the order.
+func fixedResidual(x []int64, order int) []int64 {
+	r := make([]int64, len(x)-order)
+	for i := order; i < len(x); i++ {
+		switch order {
+		case 0:
+			r[i] = x[i]
+		case 1:
+			r[i-1] = x[i] - x[i-1]
+		case 2:
+			r[i-2] = x[i] - 2*x[i-1] + x[i-2]
+		case 3:
+			r[i-3] = x[i] - 3*x[i-1] + 3*x[i-2] - x[i-3]
+		case 4:
+			r[i-4] = x[i] - 4*x[i-1] + 6*x[i-2] - 4*x[i-3] + x[i-4]
+		}
+	}
+	return r
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1 ^ v>>63)
+}
+
+// riceCoding is how a residual is split in partitions and the Rice parameter
+// of each partition.
+type riceCoding struct {
+	order  uint
+	params []uint
+	// blockSize and warmup are the numbers of samples of the block and of
+	// warm-up samples preceding the residual
+	blockSize, warmup int
+	// bits is the size of the coded residual
+	bits uint64
+}
+
+// planRice picks the partition order and parameters coding the residual of
+// a block of n samples predicted with the order in the fewest bits.
+func planRice(r []int64, n, order int) riceCoding {
+	var best riceCoding
+	for p := uint(0); p <= 8; p++ {
+		parts := 1 << p
+		if n%parts != 0 || n/parts <= order {
+			break
+		}
+		c := riceCoding{order: p, blockSize: n, warmup: order, bits: 6}
+		start := 0
+		for i := 0; i < parts; i++ {
+			count := n / parts
+			if i == 0 {
+				count -= order
+			}
+			k, size := riceParam(r[start : start+count])
+			c.params = append(c.params, k)
+			c.bits += 5 + size
+			start += count
+		}
+		if best.params == nil || c.bits < best.bits {
+			best = c
+		}
+	}
+	return best
+}
+
+// riceParam returns the Rice parameter coding the partition in the fewest
+// bits, trying the ones around the log2 of the mean, and the coded size.
+func riceParam(part []int64) (uint, uint64) {
+	var sum uint64
+	for _, v := range part {
+		sum += zigzag(v)
+	}
+	guess := 0
+	if len(part) > 0 && sum > uint64(len(part)) {
+		guess = bits.Len64(sum/uint64(len(part))) - 1
+	}
+	bestK, bestSize := uint(0), uint64(0)
+	for k := guess - 1; k <= guess+1; k++ {
+		if k < 0 || k > 30 {
+			continue
+		}
+		size := uint64(len(part)) * uint64(k+1)
+		for _, v := range part {
+			size += zigzag(v) >> uint(k)
+		}
+		if bestSize == 0 || size < bestSize {
+			bestK, bestSize = uint(k), size
+		}
+	}
+	return bestK, bestSize
+}
+
+// write codes the residual, with 5 bit parameters when one doesn't fit on 4.
+func (c riceCoding) write(w *bitWriter, r []int64) {
+	paramBits := uint(4)
+	for _, k := range c.params {
+		if k > 14 {
+			paramBits = 5
+		}
+	}
+	w.write(uint64(paramBits-4), 2)
+	w.write(uint64(c.order), 4)
+	start := 0
+	for i, k := range c.params {
+		count := c.blockSize / len(c.params)
+		if i == 0 {
+			// the first partition is short of the warm-up samples
+			count -= c.warmup
+		}
+		w.write(uint64(k), paramBits)
+		for _, v := range r[start : start+count] {
+			u := zigzag(v)
+			w.unary(u >> k)
+			w.write(u&(1<<k-1), k)
+		}
+		start += count
+	}
+}
+
+// bitWriter writes values of any number of bits, most significant bit first.
+type bitWriter struct {
+	buf []byte
+	cur byte
+	n   uint
+}
+
+func (w *bitWriter) write(v uint64, n uint) {
+	for n > 0 {
+		take := 8 - w.n
+		if take > n {
+			take = n
+		}
+		chunk := byte(v>>(n-take)) & byte(1<<take-1)
+		w.cur = w.cur<<take | chunk
+		w.n += take
+		n -= take
+		if w.n == 8 {
+			w.buf = append(w.buf, w.cur)
+			w.cur, w.n = 0, 0
+		}
+	}
+}
+
+func (w *bitWriter) signed(v int64, n uint) {
+	w.write(uint64(v), n)
+}
+
+// unary writes q zeros followed by a one.
+func (w *bitWriter) unary(q uint64) {
+	for ; q >= 32; q -= 32 {
+		w.write(0, 32)
+	}
+	w.write(1, uint(q)+1)
+}
+
+// utf8 writes v with the UTF-8 like coding of the FLAC frame numbers.
+func (w *bitWriter) utf8(v uint64) {
+	if v < 0x80 {
+		w.write(v, 8)
+		return
+	}
+	n := uint(2)
+	for v >= 1<<(5*n+1) {
+		n++
+	}
+	w.write((0xFF00>>n)&0xFF|v>>(6*(n-1)), 8)
+	for i := n - 1; i > 0; i-- {
+		w.write(0x80|(v>>(6*(i-1)))&0x3F, 8)
+	}
+}
+
+func (w *bitWriter) align() {
+	if w.n > 0 {
+		w.write(0, 8-w.n)
+	}
+}
+
+// bytes returns the complete bytes written so far.
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
+
+func flacCRC8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func flacCRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// bitReader reads values of any number of bits, most significant bit first.
+type bitReader struct {
+	data []byte
+	// pos is the position in bits
+	pos uint64
+}
+
+var errFlacTruncated = errors.New("truncated FLAC stream")
+
+func (r *bitReader) read(n uint) (uint64, error) {
+	if r.pos+uint64(n) > uint64(len(r.data))*8 {
+		return 0, errFlacTruncated
+	}
+	var v uint64
+	for i := uint(0); i < n; i++ {
+		bit := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+		v = v<<1 | uint64(bit)
+		r.pos++
+	}
+	return v, nil
+}
+
+func (r *bitReader) signed(n uint) (int64, error) {
+	v, err := r.read(n)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	return int64(v<<(64-n)) >> (64 - n), nil
+}
+
+// unary returns the number of zeros before the next one.
+func (r *bitReader) unary() (uint64, error) {
+	var q uint64
+	for {
+		bit, err := r.read(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			return q, nil
+		}
+		q++
+	}
+}
+
+func (r *bitReader) align() {
+	r.pos = (r.pos + 7) / 8 * 8
+}
+
+// flacStream is a decoded FLAC file.
+type flacStream struct {
+	sampleRate, channels, bps int
+	frames                    uint64
+	md5                       []byte
+	// app is the content of the application block written by encodeFlacFile
+	app     []byte
+	samples []int32
+}
+
+// decodeFlac decodes a FLAC file, the frame CRCs aren't checked but the
+// digest of the samples is.
+func decodeFlac(data []byte) (*flacStream, error) {
+	if !bytes.HasPrefix(data, []byte("fLaC")) {
+		return nil, fmt.Errorf("not a FLAC file")
+	}
+	s := &flacStream{}
+	r := &bitReader{data: data, pos: 32}
+	for last := false; !last; {
+		header, err := r.read(32)
+		if err != nil {
+			return nil, err
+		}
+		last = header>>31 == 1
+		blockType, size := header>>24&0x7F, header&0xFFFFFF
+		start := r.pos / 8
+		if start+size > uint64(len(data)) {
+			return nil, errFlacTruncated
+		}
+		block := data[start : start+size]
+		switch {
+		case blockType == 0 && size >= 34:
+			b := &bitReader{data: block, pos: 80}
+			rate, _ := b.read(20)
+			channels, _ := b.read(3)
+			bps, _ := b.read(5)
+			frames, _ := b.read(36)
+			s.sampleRate, s.channels, s.bps, s.frames = int(rate), int(channels)+1, int(bps)+1, frames
+			s.md5 = block[18:34]
+		case blockType == 2 && bytes.HasPrefix(block, []byte(flacApplicationID)):
+			s.app = block[4:]
+		}
+		r.pos += size * 8
+	}
+	if s.channels == 0 {
+		return nil, fmt.Errorf("no stream info")
+	}
+	for uint64(len(s.samples)) < s.frames*uint64(s.channels) {
+		if err := s.decodeFrame(r); err != nil {
+			return nil, err
+		}
+	}
+	if s.md5 != nil && !bytes.Equal(s.md5, make([]byte, 16)) {
+		h := md5.New()
+		width := (s.bps + 7) / 8
+		for _, v := range s.samples {
+			var b [4]byte
+			binary.LittleEndian.PutUint32(b[:], uint32(v))
+			h.Write(b[:width])
+		}
+		if !bytes.Equal(h.Sum(nil), s.md5) {
+			return nil, fmt.Errorf("the decoded samples don't match the digest of the stream")
+		}
+	}
+	return s, nil
+}
+
+func (s *flacStream) decodeFrame(r *bitReader) error {
+	r.align()
+	sync, err := r.read(15)
+	if err != nil {
+		return err
+	}
+	if sync != 0x7FFC {
+		return fmt.Errorf("lost the FLAC frame sync")
+	}
+	r.read(1)
+	header, err := r.read(16)
+	if err != nil {
+		return err
+	}
+	sizeCode, rateCode, assignment, depthCode := header>>12, header>>8&0xF, int(header>>4&0xF), header>>1&0x7
+	// the frame number
+	first, err := r.read(8)
+	if err != nil {
+		return err
+	}
+	for i := 1; i < bits.LeadingZeros8(^byte(first)); i++ {
+		r.read(8)
+	}
+	var n int
+	switch {
+	case sizeCode == 1:
+		n = 192
+	case sizeCode <= 5:
+		n = 576 << (sizeCode - 2)
+	case sizeCode == 6:
+		v, err := r.read(8)
+		if err != nil {
+			return err
+		}
+		n = int(v) + 1
+	case sizeCode == 7:
+		v, err := r.read(16)
+		if err != nil {
+			return err
+		}
+		n = int(v) + 1
+	default:
+		n = 256 << (sizeCode - 8)
+	}
+	switch rateCode {
+	case 12:
+		r.read(8)
+	case 13, 14:
+		r.read(16)
+	}
+	// the header CRC
+	r.read(8)
+	bps := s.bps
+	if depth := []int{0, 8, 12, 0, 16, 20, 24, 32}[depthCode]; depth != 0 {
+		bps = depth
+	}
+	channels := s.channels
+	if assignment >= 8 {
+		channels = 2
+	}
+	decoded := make([][]int64, channels)
+	for c := range decoded {
+		sbps := uint(bps)
+		// the side channel has an extra bit
+		if assignment == 8 && c == 1 || assignment == 9 && c == 0 || assignment == 10 && c == 1 {
+			sbps++
+		}
+		if decoded[c], err = decodeFlacSubframe(r, n, sbps); err != nil {
+			return err
+		}
+	}
+	if assignment >= 8 {
+		a, b := decoded[0], decoded[1]
+		for i := 0; i < n; i++ {
+			switch assignment {
+			case 8:
+				b[i] = a[i] - b[i]
+			case 9:
+				a[i] += b[i]
+			case 10:
+				mid := a[i]<<1 | b[i]&1
+				a[i], b[i] = (mid+b[i])>>1, (mid-b[i])>>1
+			}
+		}
+	}
+	for i := 0; i < n; i++ {
+		for c := range decoded {
+			s.samples = append(s.samples, int32(decoded[c][i]))
+		}
+	}
+	r.align()
+	// the frame CRC
+	_, err = r.read(16)
+	return err
+}
+
+func decodeFlacSubframe(r *bitReader, n int, bps uint) ([]int64, error) {
+	header, err := r.read(8)
+	if err != nil {
+		return nil, err
+	}
+	kind := int(header >> 1 & 0x3F)
+	wasted := uint(0)
+	if header&1 == 1 {
+		q, err := r.unary()
+		if err != nil {
+			return nil, err
+		}
+		wasted = uint(q) + 1
+		bps -= wasted
+	}
+	x := make([]int64, n)
+	switch {
+	case kind == 0:
+		v, err := r.signed(bps)
+		if err != nil {
+			return nil, err
+		}
+		for i := range x {
+			x[i] = v
+		}
+	case kind == 1:
+		for i := range x {
+			if x[i], err = r.signed(bps); err != nil {
+				return nil, err
+			}
+		}
+	case kind >= 8 && kind <= 12:
+		order := kind - 8
+		for i := 0; i < order; i++ {
+			if x[i], err = r.signed(bps); err != nil {
+				return nil, err
+			}
+		}
+		if err := decodeResidual(r, x, order); err != nil {
+			return nil, err
+		}
+		coefs := [][]int64{{}, {1}, {2, -1}, {3, -3, 1}, {4, -6, 4, -1}}[order]
+		predict(x, coefs, 0)
+	case kind >= 32:
+		order := kind - 31
+		for i := 0; i < order; i++ {
+			if x[i], err = r.signed(bps); err != nil {
+				return nil, err
+			}
+		}
+		precision, err := r.read(4)
+		if err != nil {
+			return nil, err
+		}
+		shift, err := r.signed(5)
+		if err != nil {
+			return nil, err
+		}
+		if shift < 0 {
+			return nil, fmt.Errorf("invalid FLAC predictor shift %d", shift)
+		}
+		coefs := make([]int64, order)
+		for i := range coefs {
+			if coefs[i], err = r.signed(uint(precision) + 1); err != nil {
+				return nil, err
+			}
+		}
+		if err := decodeResidual(r, x, order); err != nil {
+			return nil, err
+		}
+		predict(x, coefs, uint(shift))
+	default:
+		return nil, fmt.Errorf("invalid FLAC subframe type %d", kind)
+	}
+	if wasted > 0 {
+		for i := range x {
+			x[i] <<= wasted
+		}
+	}
+	return x, nil
+}
+
+// predict adds the prediction of the coefficients to the residual stored
+// after the warm-up samples of x.
+func predict(x, coefs []int64, shift uint) {
+	for i := len(coefs); i < len(x); i++ {
+		var sum int64
+		for j, c := range coefs {
+			sum += c * x[i-1-j]
+		}
+		x[i] += sum >> shift
+	}
+}
+
+// decodeResidual reads the residual of the block x after the warm-up samples.
+func decodeResidual(r *bitReader, x []int64, order int) error {
+	method, err := r.read(2)
+	if err != nil {
+		return err
+	}
+	if method > 1 {
+		return fmt.Errorf("invalid FLAC residual coding %d", method)
+	}
+	paramBits := uint(4 + method)
+	partitionOrder, err := r.read(4)
+	if err != nil {
+		return err
+	}
+	parts := 1 << partitionOrder
+	i := order
+	for p := 0; p < parts; p++ {
+		count := len(x) / parts
+		if p == 0 {
+			count -= order
+		}
+		k, err := r.read(paramBits)
+		if err != nil {
+			return err
+		}
+		if k == 1<<paramBits-1 {
+			// escaped partition of raw values
+			size, err := r.read(5)
+			if err != nil {
+				return err
+			}
+			for end := i + count; i < end; i++ {
+				if x[i], err = r.signed(uint(size)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for end := i + count; i < end; i++ {
+			q, err := r.unary()
+			if err != nil {
+				return err
+			}
+			low, err := r.read(uint(k))
+			if err != nil {
+				return err
+			}
+			u := q<<k | low
+			x[i] = int64(u>>1) ^ -int64(u&1)
+		}
+	}
+	return nil
+}
+
+// restoreFlacFile restores the original file of the FLAC file at path
+// written with -archiveFormat flac and returns its content and extension.
+func restoreFlacFile(path string) ([]byte, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	s, err := decodeFlac(data)
+	if err != nil {
+		return nil, "", err
+	}
+	app := s.app
+	if len(app) < 2 || len(app) < 2+int(app[1])+4 {
+		return nil, "", fmt.Errorf("not written by -archiveFormat flac")
+	}
+	flags, ext := app[0], string(app[2:2+app[1]])
+	app = app[2+app[1]:]
+	headerSize := int(binary.BigEndian.Uint32(app))
+	if len(app) < 4+headerSize {
+		return nil, "", fmt.Errorf("truncated original header")
+	}
+	header, trailer := app[4:4+headerSize], app[4+headerSize:]
+	var out bytes.Buffer
+	out.Write(header)
+	out.Write(encodeIntSamples(s.samples, (s.bps+7)/8, flags))
+	out.Write(trailer)
+	return out.Bytes(), ext, nil
+}
+
+// decodeFlacFolder restores the original files of the FLAC files written
+// with -archiveFormat flac found in src, next to them or in the same folders
+// of destDir when it's set. It returns the number of files which couldn't be.
+func decodeFlacFolder(src, destDir string) int {
+	restored, failed := 0, 0
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || !strings.EqualFold(filepath.Ext(path), ".flac") {
+			return nil
+		}
+		data, ext, err := restoreFlacFile(path)
+		if err != nil {
+			errorf("Failed to decode %s - %s", path, err)
+			failed++
+			return nil
+		}
+		dst := strings.TrimSuffix(path, filepath.Ext(path)) + ext
+		if destDir != "" {
+			rel, err := filepath.Rel(src, dst)
+			if err != nil {
+				return err
+			}
+			dst = filepath.Join(destDir, rel)
+			if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+				return err
+			}
+		}
+		if err := os.WriteFile(dst, data, 0666); err != nil {
+			errorf("Failed to write %s - %s", dst, err)
+			failed++
+			return nil
+		}
+		debugf("Restored %s to %s", path, dst)
+		restored++
+		return nil
+	})
+	if err != nil {
+		errorf("Something went wrong looking for FLAC files - %s", err)
+		failed++
+	}
+	successf("%d files restored", restored)
+	return failed
+}
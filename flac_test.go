@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testSignal returns frames of interleaved samples of width bytes, a mix of
+// a sine, noise, silence and full scale peaks to go through all the subframe
+// kinds of the encoder.
+func testSignal(frames, channels, width int) []int64 {
+	peak := int64(1)<<(width*8-1) - 1
+	seed := uint32(1)
+	samples := make([]int64, frames*channels)
+	for i := 0; i < frames; i++ {
+		for c := 0; c < channels; c++ {
+			seed = seed*1664525 + 1013904223
+			noise := float64(int32(seed)) / math.MaxInt32
+			var v float64
+			switch {
+			case i < flacBlockSize/2:
+				// silence
+			case i < flacBlockSize*2:
+				v = 0.6*math.Sin(float64(i*(c+1))/20) + 0.05*noise
+			case i < flacBlockSize*2+64:
+				v = float64(1 - 2*(i%2))
+			default:
+				v = noise
+			}
+			samples[i*channels+c] = min(peak, int64(v*float64(peak+1)))
+		}
+	}
+	return samples
+}
+
+// testSampleData encodes samples like the data of a WAV or AIFF file.
+func testSampleData(samples []int64, width int, bigEndian bool) []byte {
+	var data []byte
+	for _, s := range samples {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(s))
+		if width == 1 && !bigEndian {
+			// 8 bit WAV data is unsigned
+			b[0] += 128
+		}
+		sample := b[:width]
+		if bigEndian {
+			for i, j := 0, width-1; i < j; i, j = i+1, j-1 {
+				sample[i], sample[j] = sample[j], sample[i]
+			}
+		}
+		data = append(data, sample...)
+	}
+	return data
+}
+
+func TestFlacRoundTrip(t *testing.T) {
+	frames := flacBlockSize*3 + 123
+	tests := []struct {
+		name string
+		file func(t *testing.T) string
+	}{
+		{"16 bit stereo wav", func(t *testing.T) string {
+			return testWav(t, wavFormatPCM, 2, 44100, 4, 16, testSampleData(testSignal(frames, 2, 2), 2, false))
+		}},
+		{"8 bit mono wav", func(t *testing.T) string {
+			return testWav(t, wavFormatPCM, 1, 22050, 1, 8, testSampleData(testSignal(frames, 1, 1), 1, false))
+		}},
+		{"24 bit wav", func(t *testing.T) string {
+			return testWav(t, wavFormatPCM, 2, 96000, 6, 24, testSampleData(testSignal(frames, 2, 3), 3, false))
+		}},
+		{"wav with trailing chunks", func(t *testing.T) string {
+			path := testWav(t, wavFormatPCM, 1, 44100, 2, 16, testSampleData(testSignal(frames, 1, 2), 2, false))
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			f.Write([]byte("LIST\x05\x00\x00\x00INFOx\x00"))
+			return path
+		}},
+		{"wav with a partial frame", func(t *testing.T) string {
+			return testWav(t, wavFormatPCM, 2, 44100, 4, 16, append(testSampleData(testSignal(frames, 2, 2), 2, false), 1, 2))
+		}},
+		{"16 bit aiff", func(t *testing.T) string {
+			return testAiff(t, 2, frames, 16, 44100, 0, testSampleData(testSignal(frames, 2, 2), 2, true))
+		}},
+		{"aiff with a data offset", func(t *testing.T) string {
+			data := append(make([]byte, 4), testSampleData(testSignal(frames, 1, 3), 3, true)...)
+			return testAiff(t, 1, frames, 24, 48000, 4, data)
+		}},
+	}
+	for _, tt := range tests {
+		src := tt.file(t)
+		dst := filepath.Join(t.TempDir(), "test.flac")
+		if err := encodeFlacFile(src, dst); err != nil {
+			t.Errorf("%s: encoding: %s", tt.name, err)
+			continue
+		}
+		restored, ext, err := restoreFlacFile(dst)
+		if err != nil {
+			t.Errorf("%s: decoding: %s", tt.name, err)
+			continue
+		}
+		original, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ext != filepath.Ext(src) {
+			t.Errorf("%s: restored as %s; want %s", tt.name, ext, filepath.Ext(src))
+		}
+		if !bytes.Equal(restored, original) {
+			t.Errorf("%s: the restored file differs from the original", tt.name)
+		}
+	}
+}
+
+func TestFlacMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		file func(t *testing.T) string
+	}{
+		{"zero block alignment", func(t *testing.T) string {
+			return testWav(t, wavFormatPCM, 1, 44100, 0, 16, make([]byte, 16))
+		}},
+		{"block alignment of another width", func(t *testing.T) string {
+			return testWav(t, wavFormatPCM, 1, 44100, 4, 16, make([]byte, 16))
+		}},
+		{"ssnd offset past the file", func(t *testing.T) string {
+			return testAiff(t, 1, 4, 16, 44100, 1000, make([]byte, 8))
+		}},
+		{"float wav", func(t *testing.T) string {
+			return testWav(t, wavFormatFloat, 1, 44100, 4, 32, make([]byte, 16))
+		}},
+		{"no data chunk", func(t *testing.T) string {
+			path := testWav(t, wavFormatPCM, 1, 44100, 2, 16, nil)
+			if err := os.Truncate(path, 36); err != nil {
+				t.Fatal(err)
+			}
+			return path
+		}},
+	}
+	defer func(v string) { *flagArchiveFormat = v }(*flagArchiveFormat)
+	*flagArchiveFormat = "flac"
+	for _, tt := range tests {
+		src := tt.file(t)
+		if flacEncodable(src) {
+			t.Errorf("%s: encodable as FLAC", tt.name)
+		}
+		if err := encodeFlacFile(src, filepath.Join(t.TempDir(), "test.flac")); err == nil {
+			t.Errorf("%s: encoded as FLAC", tt.name)
+		}
+	}
+	if _, _, err := restoreFlacFile(testWav(t, wavFormatPCM, 1, 44100, 2, 16, make([]byte, 16))); err == nil {
+		t.Error("restored a WAV file as a FLAC file")
+	}
+}
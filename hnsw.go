@@ -0,0 +1,376 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// hnswM is the number of neighbors linked to each file on the levels of
+	// the graph above the first one, which links twice as many
+	hnswM = 16
+	// hnswEfConstruction is the number of candidates considered when linking
+	// a new file to the graph
+	hnswEfConstruction = 100
+	// hnswEfSearch is the minimum number of candidates considered by a query
+	hnswEfSearch = 64
+)
+
+// similarityIndex is a Hierarchical Navigable Small World graph over the MFCC
+// features of the samples of a library, built by the index command and used by
+// the similar command with -index to find the closest samples without
+// decoding the whole library. The features are standardized so every
+// dimension weighs the same in the distances.
+type similarityIndex struct {
+	Files []indexedFile `json:"files"`
+	// Mean and Scale standardize the features
+	Mean  []float64 `json:"mean"`
+	Scale []float64 `json:"scale"`
+	// Entry is the file the searches start from, on the top level MaxLevel
+	Entry    int `json:"entry"`
+	MaxLevel int `json:"maxLevel"`
+}
+
+// indexedFile is a sample of the similarity index.
+type indexedFile struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ModTime  int64     `json:"modTime"`
+	Features []float64 `json:"features"`
+	// Links are the neighbors of the file on each of its levels of the graph
+	Links [][]int32 `json:"links"`
+
+	// vector is the standardized features
+	vector []float64
+}
+
+// loadSimilarityIndex loads the index file at path.
+func loadSimilarityIndex(path string) (*similarityIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	idx := &similarityIndex{}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, err
+	}
+	for i := range idx.Files {
+		idx.Files[i].vector = idx.standardize(idx.Files[i].Features)
+	}
+	return idx, nil
+}
+
+// save writes the index file at path, through a temporary file so a failed
+// write doesn't lose the previous index.
+func (idx *similarityIndex) save(path string) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".samplesorter-index")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// standardize returns the features scaled by the index statistics.
+func (idx *similarityIndex) standardize(features []float64) []float64 {
+	v := make([]float64, len(features))
+	for i, f := range features {
+		if i < len(idx.Mean) {
+			v[i] = (f - idx.Mean[i]) / idx.Scale[i]
+		}
+	}
+	return v
+}
+
+// distance returns the squared euclidean distance between two vectors.
+func distance(a, b []float64) float64 {
+	var d float64
+	for i := range a {
+		d += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	return d
+}
+
+// candidate is a file of the index at some distance of a query.
+type candidate struct {
+	node int
+	dist float64
+}
+
+// candidateHeap is a heap of candidates, the closest first or the farthest
+// first when farthest is set.
+type candidateHeap struct {
+	items    []candidate
+	farthest bool
+}
+
+func (h candidateHeap) Len() int { return len(h.items) }
+func (h candidateHeap) Less(i, j int) bool {
+	if h.farthest {
+		return h.items[i].dist > h.items[j].dist
+	}
+	return h.items[i].dist < h.items[j].dist
+}
+func (h candidateHeap) Swap(i, j int)       { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *candidateHeap) Push(x interface{}) { h.items = append(h.items, x.(candidate)) }
+func (h *candidateHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// searchLevel returns the ef files of the level closest to the query found by
+// a best first search from the entry points, sorted by distance.
+func (idx *similarityIndex) searchLevel(query []float64, entries []candidate, ef, level int) []candidate {
+	visited := map[int]bool{}
+	candidates := &candidateHeap{}
+	results := &candidateHeap{farthest: true}
+	for _, e := range entries {
+		visited[e.node] = true
+		heap.Push(candidates, e)
+		heap.Push(results, e)
+	}
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(candidate)
+		if results.Len() >= ef && c.dist > results.items[0].dist {
+			break
+		}
+		links := idx.Files[c.node].Links
+		if level >= len(links) {
+			continue
+		}
+		for _, n := range links[level] {
+			node := int(n)
+			if visited[node] {
+				continue
+			}
+			visited[node] = true
+			d := distance(query, idx.Files[node].vector)
+			if results.Len() < ef || d < results.items[0].dist {
+				heap.Push(candidates, candidate{node, d})
+				heap.Push(results, candidate{node, d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+	sorted := make([]candidate, results.Len())
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = heap.Pop(results).(candidate)
+	}
+	return sorted
+}
+
+// search returns the k files closest to the standardized query vector.
+func (idx *similarityIndex) search(query []float64, k int) []candidate {
+	if len(idx.Files) == 0 {
+		return nil
+	}
+	entries := []candidate{{idx.Entry, distance(query, idx.Files[idx.Entry].vector)}}
+	for level := idx.MaxLevel; level > 0; level-- {
+		entries = idx.searchLevel(query, entries, 1, level)
+	}
+	found := idx.searchLevel(query, entries, max(k, hnswEfSearch), 0)
+	if len(found) > k {
+		found = found[:k]
+	}
+	return found
+}
+
+// link adds the file node to the graph, linking it to its closest neighbors
+// on each of its levels.
+func (idx *similarityIndex) link(node, level int) {
+	f := &idx.Files[node]
+	f.Links = make([][]int32, level+1)
+	if node == 0 {
+		idx.Entry, idx.MaxLevel = 0, level
+		return
+	}
+	entries := []candidate{{idx.Entry, distance(f.vector, idx.Files[idx.Entry].vector)}}
+	for l := idx.MaxLevel; l > level; l-- {
+		entries = idx.searchLevel(f.vector, entries, 1, l)
+	}
+	for l := min(level, idx.MaxLevel); l >= 0; l-- {
+		entries = idx.searchLevel(f.vector, entries, hnswEfConstruction, l)
+		maxLinks := hnswM
+		if l == 0 {
+			maxLinks = 2 * hnswM
+		}
+		for i, c := range entries {
+			if i == hnswM {
+				break
+			}
+			f.Links[l] = append(f.Links[l], int32(c.node))
+			neighbor := &idx.Files[c.node]
+			neighbor.Links[l] = append(neighbor.Links[l], int32(node))
+			if len(neighbor.Links[l]) > maxLinks {
+				idx.prune(c.node, l, maxLinks)
+			}
+		}
+	}
+	if level > idx.MaxLevel {
+		idx.Entry, idx.MaxLevel = node, level
+	}
+}
+
+// prune keeps the maxLinks closest neighbors of the file node on the level.
+func (idx *similarityIndex) prune(node, level, maxLinks int) {
+	f := &idx.Files[node]
+	links := make([]candidate, len(f.Links[level]))
+	for i, n := range f.Links[level] {
+		links[i] = candidate{int(n), distance(f.vector, idx.Files[n].vector)}
+	}
+	h := &candidateHeap{items: links}
+	heap.Init(h)
+	f.Links[level] = f.Links[level][:0]
+	for len(f.Links[level]) < maxLinks && h.Len() > 0 {
+		f.Links[level] = append(f.Links[level], int32(heap.Pop(h).(candidate).node))
+	}
+}
+
+// buildGraph computes the standardization of the features of the files and
+// links them all in a new graph.
+func (idx *similarityIndex) buildGraph() {
+	idx.Mean, idx.Scale = nil, nil
+	if len(idx.Files) > 0 {
+		dims := len(idx.Files[0].Features)
+		idx.Mean, idx.Scale = make([]float64, dims), make([]float64, dims)
+		for _, f := range idx.Files {
+			for i, v := range f.Features {
+				idx.Mean[i] += v / float64(len(idx.Files))
+			}
+		}
+		for _, f := range idx.Files {
+			for i, v := range f.Features {
+				idx.Scale[i] += (v - idx.Mean[i]) * (v - idx.Mean[i]) / float64(len(idx.Files))
+			}
+		}
+		for i := range idx.Scale {
+			idx.Scale[i] = math.Max(math.Sqrt(idx.Scale[i]), 1e-6)
+		}
+	}
+	// the levels are drawn from a fixed seed so the same library gives the same index
+	random := rand.New(rand.NewSource(1))
+	levelFactor := 1 / math.Log(hnswM)
+	idx.Entry, idx.MaxLevel = 0, 0
+	for i := range idx.Files {
+		idx.Files[i].vector = idx.standardize(idx.Files[i].Features)
+		idx.link(i, int(-math.Log(1-random.Float64())*levelFactor))
+	}
+}
+
+// indexSamples computes the features of the matches found in the source folder
+// and writes the similarity index of the ones that could be analyzed to
+// indexPath. The features of the files which didn't change since they were
+// indexed are taken from the previous index.
+func indexSamples(sourcePath, indexPath string) error {
+	previous := map[string]indexedFile{}
+	if idx, err := loadSimilarityIndex(indexPath); err == nil {
+		for _, f := range idx.Files {
+			previous[f.Path] = f
+		}
+	} else if !os.IsNotExist(err) {
+		warnf("Rebuilding the unreadable index %s - %s", indexPath, err)
+	}
+	matches := make(chan string, 64)
+	walkDone := make(chan error, 1)
+	go func() {
+		walkDone <- findMatchingFiles(context.Background(), sourcePath, matches)
+		close(matches)
+	}()
+
+	start := time.Now()
+	var mu sync.Mutex
+	var files []indexedFile
+	analyzed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < max(*flagHashWorkers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range matches {
+				fi, err := os.Stat(path)
+				if err != nil {
+					warnf("Skipping %s - %s", path, err)
+					continue
+				}
+				f := indexedFile{Path: path, Size: fi.Size(), ModTime: fi.ModTime().UnixNano()}
+				if p, ok := previous[path]; ok && p.Size == f.Size && p.ModTime == f.ModTime {
+					f.Features = p.Features
+				} else {
+					if f.Features, err = mfccStats(path); err != nil {
+						warnf("Skipping %s - %s", path, err)
+						continue
+					}
+					mu.Lock()
+					analyzed++
+					mu.Unlock()
+				}
+				mu.Lock()
+				files = append(files, f)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if err := <-walkDone; err != nil {
+		return err
+	}
+	// keep the order of the files stable between runs
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	idx := &similarityIndex{Files: files}
+	idx.buildGraph()
+	if err := idx.save(indexPath); err != nil {
+		return err
+	}
+	successf("Indexed %d samples (%d analyzed) to %s in %s", len(files), analyzed, indexPath, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// similarFromIndex returns the paths of the count samples of the index closest
+// to the reference sample at refPath, the reference itself left out.
+func similarFromIndex(idx *similarityIndex, refPath string, count int) ([]string, error) {
+	features, err := mfccStats(refPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(features) != len(idx.Mean) {
+		return nil, fmt.Errorf("the index doesn't hold MFCC features")
+	}
+	refPath, _ = filepath.Abs(refPath)
+	start := time.Now()
+	found := idx.search(idx.standardize(features), count+1)
+	debugf("Searched %d indexed samples in %s", len(idx.Files), time.Since(start))
+	var paths []string
+	for _, c := range found {
+		path := idx.Files[c.node].Path
+		if abs, _ := filepath.Abs(path); abs == refPath || len(paths) == count {
+			continue
+		}
+		infof("%3.0f%% similar: %s", 100/(1+math.Sqrt(c.dist/float64(len(features)))), path)
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
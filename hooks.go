@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// runHook runs a hook command through the shell with the run description and
+// the given variables added to its environment.
+func runHook(command string, vars map[string]string) error {
+	if command == "" || *flagDryRun || *flagEstimate || *flagList {
+		return nil
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdout, cmd.Stderr = messageOutput, os.Stderr
+	cmd.Env = append(os.Environ(),
+		"SAMPLESORTER_SRC="+currentRun.Source,
+		"SAMPLESORTER_DEST="+currentRun.Destination,
+		"SAMPLESORTER_KEYWORD="+currentRun.Keyword,
+	)
+	for k, v := range vars {
+		cmd.Env = append(cmd.Env, "SAMPLESORTER_"+k+"="+v)
+	}
+	return cmd.Run()
+}
+
+// groupHookVars returns the hook variables describing a group folder.
+func groupHookVars(dir string, idx, files int) map[string]string {
+	return map[string]string{
+		"GROUP_DIR":   dir,
+		"GROUP_INDEX": strconv.Itoa(idx),
+		"GROUP_FILES": strconv.Itoa(files),
+	}
+}
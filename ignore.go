@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultIgnoreFile is the default -ignoreFile, it's fine for it not to exist.
+const defaultIgnoreFile = "~/.samplesorter-ignore"
+
+// ignoreList holds the files that must never be matched, loaded from the
+// ignore file. Each line of the file is either:
+//
+//	sha256:<digest>   a file with this SHA-256 content digest, as printed by sha256sum
+//	/path/to/*.wav    a path, glob patterns allowed
+//	*loop*.wav        a filename, glob patterns allowed
+//
+// Empty lines and lines starting with # are skipped.
+var ignoreList struct {
+	digests   map[string]bool
+	paths     []string
+	filenames []string
+}
+
+// loadIgnoreList loads the ignore file at path, expanding the paths it lists.
+func loadIgnoreList(path, home string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ignoreList.digests = map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "sha256:"):
+			ignoreList.digests[strings.ToLower(strings.TrimPrefix(line, "sha256:"))] = true
+		case strings.ContainsAny(line, `/\`) || strings.HasPrefix(line, "~"):
+			ignoreList.paths = append(ignoreList.paths, expandPath(line, home))
+		default:
+			ignoreList.filenames = append(ignoreList.filenames, strings.ToLower(line))
+		}
+	}
+	return scanner.Err()
+}
+
+// ignored reports if the file at path is in the ignore list. The files are
+// only hashed when the list has digests.
+func ignored(path string) bool {
+	filename := strings.ToLower(filepath.Base(path))
+	for _, pattern := range ignoreList.filenames {
+		if ok, _ := filepath.Match(pattern, filename); ok {
+			return true
+		}
+	}
+	for _, pattern := range ignoreList.paths {
+		if ok, _ := filepath.Match(pattern, path); ok || strings.HasPrefix(path, pattern+string(filepath.Separator)) {
+			return true
+		}
+	}
+	if len(ignoreList.digests) > 0 {
+		if digest, _, err := hashFileMode(path, "full"); err == nil && ignoreList.digests[digest] {
+			return true
+		}
+	}
+	return false
+}
+
+// projectFolders are the lowercase names of the folders DAWs keep the audio of
+// their projects in: recordings, bounces and renders rather than samples.
+var projectFolders = map[string]bool{
+	"ableton project info": true,
+	"bounces":              true,
+	"freeze files":         true,
+}
+
+// projectSubfolders are the lowercase names of the project folders only
+// recognized under a given parent, like Ableton's Samples/Recorded.
+var projectSubfolders = map[string]string{
+	"recorded":  "samples",
+	"processed": "samples",
+}
+
+// isProjectFolder reports if the folder at path holds the audio of a DAW
+// project: Ableton's Samples/Recorded and Samples/Processed folders and
+// Project Info, Logic's bounces and the insides of its project packages.
+func isProjectFolder(path string) bool {
+	name := strings.ToLower(filepath.Base(path))
+	if projectFolders[name] || strings.HasSuffix(name, ".logicx") || strings.HasSuffix(name, ".logic") {
+		return true
+	}
+	parent, ok := projectSubfolders[name]
+	return ok && strings.ToLower(filepath.Base(filepath.Dir(path))) == parent
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readMatchList sends the files listed in the file at path to matches instead
+// of searching the source. The list is either a manifest written by a previous
+// run, whose sources are copied again, or the output of -list: a text file with
+// a path per line, optionally followed by tab separated columns, or a JSON
+// object per line, the lines can also end with NUL characters as printed with
+// -print0. Empty lines and lines starting with # are skipped. The
+// listed files don't go through the keyword and filters.
+func readMatchList(ctx context.Context, path string, matches chan<- string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("couldn't read the list of files - %s", err)
+	}
+	var paths []string
+	var manifest runManifest
+	if err := json.Unmarshal(data, &manifest); err == nil && manifest.Destination != "" {
+		for _, entry := range manifest.Files {
+			paths = append(paths, entry.Source)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		if bytes.IndexByte(data, 0) >= 0 {
+			scanner.Split(scanNulls)
+		}
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			switch {
+			case line == "" || strings.HasPrefix(line, "#"):
+			case strings.HasPrefix(line, "{"):
+				var entry listEntry
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					return fmt.Errorf("couldn't parse %s - %s", line, err)
+				}
+				paths = append(paths, entry.Path)
+			default:
+				paths = append(paths, strings.SplitN(line, "\t", 2)[0])
+			}
+		}
+	}
+	return sendMatches(ctx, paths, matches)
+}
+
+// sendMatches sends the paths to matches, skipping the duplicates, the files
+// which don't exist anymore and the ones of the ignore list.
+func sendMatches(ctx context.Context, paths []string, matches chan<- string) error {
+	seen := map[string]bool{}
+	for _, p := range paths {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		fi, err := os.Stat(p)
+		if err == nil && fi.IsDir() {
+			err = fmt.Errorf("is a folder")
+		}
+		if err != nil {
+			warnf("Skipping %s - %s", p, err)
+			recordError(errUnreadable, p, err)
+			continue
+		}
+		if ignored(p) {
+			debugf("Ignoring %s", p)
+			continue
+		}
+		matchCount++
+		runMetrics.matched.Add(1)
+		select {
+		case matches <- p:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// scanNulls is a bufio.SplitFunc splitting NUL terminated records.
+func scanNulls(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// integrationLabel is the name of the file manager context menu entry.
+const integrationLabel = "Sort samples from here…"
+
+// integrationPathFlags are the flags naming files or folders, made absolute
+// by integrationArgs. The mirror flag is a comma separated list of them.
+var integrationPathFlags = map[string]bool{
+	"dest": true, "mirror": true, "ref": true, "index": true, "taxonomy": true, "ignoreFile": true,
+	"walkCache": true, "logFile": true, "metricsFile": true, "cpuProfile": true, "memProfile": true,
+}
+
+// integrationArgs returns the arguments the context menu entry runs the tool
+// with before the source folder: the flags passed to install-integration, the
+// paths made absolute since the file managers don't start it from the current
+// folder.
+func integrationArgs(home string) ([]string, error) {
+	var args []string
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch {
+		case f.Name == "src" || f.Name == "fromList":
+			return
+		case integrationPathFlags[f.Name] && value != "" && value != stdoutDest:
+			paths := strings.Split(value, ",")
+			for i, path := range paths {
+				if paths[i], err = filepath.Abs(expandPath(strings.TrimSpace(path), home)); err != nil {
+					return
+				}
+			}
+			value = strings.Join(paths, ",")
+		}
+		args = append(args, "-"+f.Name+"="+value)
+	})
+	return args, err
+}
+
+// installIntegration registers the "Sort samples from here…" entry of the
+// context menu of the folders in Finder, Explorer, Nautilus and Dolphin,
+// running the tool with the flags passed to install-integration and the
+// selected folder as the source. The entry is removed when remove is set.
+func installIntegration(home string, remove bool) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("couldn't find the running binary - %s", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("couldn't find the running binary - %s", err)
+	}
+	args, err := integrationArgs(home)
+	if err != nil {
+		return err
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return installFinderAction(home, exe, args, remove)
+	case "windows":
+		return installExplorerEntry(exe, args, remove)
+	}
+	return installLinuxEntries(home, exe, args, remove)
+}
+
+// shellCommand returns the sh command running exe with args, the selected
+// folder passed as $dir.
+func shellCommand(exe string, args []string) string {
+	quoted := []string{shellQuote(exe)}
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ") + ` -src="$dir"`
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// installFinderAction writes a Finder Quick Action running the tool on the
+// selected folders to ~/Library/Services. It runs in the background so
+// -notify is the way to know when it's done.
+func installFinderAction(home, exe string, args []string, remove bool) error {
+	dir := filepath.Join(home, "Library", "Services", strings.TrimSuffix(integrationLabel, "…")+".workflow")
+	if remove {
+		return removeIntegration(dir)
+	}
+	contents := filepath.Join(dir, "Contents")
+	if err := os.MkdirAll(contents, 0755); err != nil {
+		return err
+	}
+	script := "for dir in \"$@\"; do\n\t" + shellCommand(exe, args) + "\ndone"
+	if err := os.WriteFile(filepath.Join(contents, "Info.plist"), []byte(fmt.Sprintf(finderInfoPlist, xmlEscape(integrationLabel))), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(contents, "document.wflow"), []byte(fmt.Sprintf(finderWorkflow, xmlEscape(script))), 0644); err != nil {
+		return err
+	}
+	// refresh the Services menu, not critical if it fails
+	exec.Command("/System/Library/CoreServices/pbs", "-update").Run()
+	successf("Added %q to the Quick Actions of the Finder folders: %s", integrationLabel, dir)
+	return nil
+}
+
+// finderInfoPlist declares the Quick Action as a Finder service on folders.
+const finderInfoPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>NSServices</key>
+	<array>
+		<dict>
+			<key>NSMenuItem</key>
+			<dict>
+				<key>default</key>
+				<string>%s</string>
+			</dict>
+			<key>NSMessage</key>
+			<string>runWorkflowAsService</string>
+			<key>NSRequiredContext</key>
+			<dict>
+				<key>NSApplicationIdentifier</key>
+				<string>com.apple.finder</string>
+			</dict>
+			<key>NSSendFileTypes</key>
+			<array>
+				<string>public.folder</string>
+			</array>
+		</dict>
+	</array>
+</dict>
+</plist>
+`
+
+// finderWorkflow is an Automator workflow with a single Run Shell Script
+// action getting the selected folders as arguments.
+const finderWorkflow = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>AMApplicationBuild</key>
+	<string>523</string>
+	<key>AMApplicationVersion</key>
+	<string>2.10</string>
+	<key>AMDocumentVersion</key>
+	<string>2</string>
+	<key>actions</key>
+	<array>
+		<dict>
+			<key>action</key>
+			<dict>
+				<key>AMAccepts</key>
+				<dict>
+					<key>Container</key>
+					<string>List</string>
+					<key>Optional</key>
+					<true/>
+					<key>Types</key>
+					<array>
+						<string>com.apple.cocoa.string</string>
+					</array>
+				</dict>
+				<key>AMActionVersion</key>
+				<string>2.0.3</string>
+				<key>AMParameterProperties</key>
+				<dict>
+					<key>COMMAND_STRING</key>
+					<dict/>
+					<key>CheckedForUserDefaultShell</key>
+					<dict/>
+					<key>inputMethod</key>
+					<dict/>
+					<key>shell</key>
+					<dict/>
+					<key>source</key>
+					<dict/>
+				</dict>
+				<key>AMProvides</key>
+				<dict>
+					<key>Container</key>
+					<string>List</string>
+					<key>Types</key>
+					<array>
+						<string>com.apple.cocoa.string</string>
+					</array>
+				</dict>
+				<key>ActionBundlePath</key>
+				<string>/System/Library/Automator/Run Shell Script.action</string>
+				<key>ActionName</key>
+				<string>Run Shell Script</string>
+				<key>ActionParameters</key>
+				<dict>
+					<key>COMMAND_STRING</key>
+					<string>%s</string>
+					<key>CheckedForUserDefaultShell</key>
+					<true/>
+					<key>inputMethod</key>
+					<integer>1</integer>
+					<key>shell</key>
+					<string>/bin/sh</string>
+					<key>source</key>
+					<string></string>
+				</dict>
+				<key>BundleIdentifier</key>
+				<string>com.apple.RunShellScript</string>
+				<key>CFBundleVersion</key>
+				<string>2.0.3</string>
+				<key>CanShowSelectedItemsWhenRun</key>
+				<false/>
+				<key>CanShowWhenRun</key>
+				<true/>
+				<key>Class Name</key>
+				<string>RunShellScriptAction</string>
+				<key>InputUUID</key>
+				<string>6F7A1C1E-2B0C-4C55-9C0F-5A8B3D1E9A01</string>
+				<key>OutputUUID</key>
+				<string>6F7A1C1E-2B0C-4C55-9C0F-5A8B3D1E9A02</string>
+				<key>UUID</key>
+				<string>6F7A1C1E-2B0C-4C55-9C0F-5A8B3D1E9A03</string>
+				<key>isViewVisible</key>
+				<true/>
+			</dict>
+		</dict>
+	</array>
+	<key>connectors</key>
+	<dict/>
+	<key>workflowMetaData</key>
+	<dict>
+		<key>serviceApplicationBundleID</key>
+		<string>com.apple.finder</string>
+		<key>serviceInputTypeIdentifier</key>
+		<string>com.apple.Automator.fileSystemObject.folder</string>
+		<key>serviceOutputTypeIdentifier</key>
+		<string>com.apple.Automator.nothing</string>
+		<key>workflowTypeIdentifier</key>
+		<string>com.apple.Automator.servicesMenu</string>
+	</dict>
+</dict>
+</plist>
+`
+
+func xmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// explorerKeys are the registry keys of the context menus of the folders and
+// of the background of an open folder.
+var explorerKeys = []string{
+	`HKCU\Software\Classes\Directory\shell\sampleSorter`,
+	`HKCU\Software\Classes\Directory\Background\shell\sampleSorter`,
+}
+
+// installExplorerEntry registers the context menu entry of the folders in
+// the registry of the current user, the tool running in a console.
+func installExplorerEntry(exe string, args []string, remove bool) error {
+	for _, key := range explorerKeys {
+		if remove {
+			if out, err := exec.Command("reg", "delete", key, "/f").CombinedOutput(); err != nil {
+				warnf("Couldn't delete %s - %s", key, strings.TrimSpace(string(out)))
+			}
+			continue
+		}
+		command := []string{windowsQuote(exe)}
+		for _, arg := range args {
+			command = append(command, windowsQuote(arg))
+		}
+		// %V is the folder, ending with a backslash for the drive roots like
+		// D:\ which would escape the closing quote: \. keeps it quoted, the
+		// source path being cleaned. It stays in quotes cmd sees for the
+		// metacharacters of the folder names.
+		src := `"-src=%V\."`
+		// keep the console open on the summary once the run is over
+		commandLine := `cmd.exe /S /K "` + cmdEscape(strings.Join(command, " ")) + " " + src + `"`
+		for _, value := range []struct{ key, data string }{
+			{key, integrationLabel},
+			{key + `\command`, commandLine},
+		} {
+			if out, err := exec.Command("reg", "add", value.key, "/ve", "/d", value.data, "/f").CombinedOutput(); err != nil {
+				return fmt.Errorf("couldn't write %s - %s", value.key, strings.TrimSpace(string(out)))
+			}
+		}
+	}
+	if remove {
+		successf("Removed %q from the Explorer context menus", integrationLabel)
+		return nil
+	}
+	successf("Added %q to the Explorer context menus of the folders", integrationLabel)
+	return nil
+}
+
+// windowsQuote quotes an argument of a Windows command line, doubling the
+// backslashes before a quote so they aren't taken for escapes.
+func windowsQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	backslashes := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			backslashes++
+		case '"':
+			b.WriteString(strings.Repeat(`\`, backslashes+1))
+			backslashes = 0
+		default:
+			backslashes = 0
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteString(strings.Repeat(`\`, backslashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// cmdEscape escapes the metacharacters of a cmd command line with carets,
+// the quotes too so cmd never takes the rest of the line as quoted and
+// removes all the carets. A caret in a %VAR% makes it the name of an
+// undefined variable, which cmd leaves as is on a command line.
+func cmdEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`()%!^"<>&|`, c) {
+			b.WriteByte('^')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// installLinuxEntries writes a Nautilus script and a Dolphin service menu
+// running the tool on the selected folders in a terminal.
+func installLinuxEntries(home, exe string, args []string, remove bool) error {
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		data = filepath.Join(home, ".local", "share")
+	}
+	script := filepath.Join(data, "nautilus", "scripts", strings.TrimSuffix(integrationLabel, "…"))
+	serviceMenu := filepath.Join(data, "kio", "servicemenus", "samplesorter.desktop")
+	if remove {
+		if err := removeIntegration(script); err != nil {
+			return err
+		}
+		return removeIntegration(serviceMenu)
+	}
+	// Nautilus passes the selected folders as arguments and doesn't show the
+	// output of the scripts, keep it in a terminal when there's one
+	body := "#!/bin/sh\n" +
+		"if [ -z \"$SAMPLESORTER_TERMINAL\" ] && command -v x-terminal-emulator >/dev/null; then\n" +
+		"\texec env SAMPLESORTER_TERMINAL=1 x-terminal-emulator -e sh -c '\"$0\" \"$@\"; echo; echo Press Enter to close; read line' \"$0\" \"$@\"\n" +
+		"fi\n" +
+		"for dir in \"$@\"; do\n\t" + shellCommand(exe, args) + "\ndone\n"
+	if err := os.MkdirAll(filepath.Dir(script), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(serviceMenu), 0755); err != nil {
+		return err
+	}
+	desktop := fmt.Sprintf("[Desktop Entry]\nType=Service\nX-KDE-ServiceTypes=KonqPopupMenu/Plugin\nMimeType=inode/directory;\nActions=sort;\n\n"+
+		"[Desktop Action sort]\nName=%s\nIcon=folder-sound\nExec=%s %%F\n", integrationLabel, desktopExecQuote(script))
+	if err := os.WriteFile(serviceMenu, []byte(desktop), 0755); err != nil {
+		return err
+	}
+	successf("Added %q to the folder context menus of Nautilus (Scripts) and Dolphin", integrationLabel)
+	return nil
+}
+
+// desktopExecQuote quotes a path for the Exec key of a desktop entry.
+func desktopExecQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\\\`, `"`, `\"`, "`", "\\`", "$", `\$`).Replace(s) + `"`
+}
+
+// removeIntegration removes an installed context menu file or folder.
+func removeIntegration(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	successf("Removed %s", path)
+	return nil
+}
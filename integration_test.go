@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestWindowsQuote(t *testing.T) {
+	tests := []struct{ arg, want string }{
+		{`-dest=C:\Samples`, `-dest=C:\Samples`},
+		{`-dest=C:\My Samples`, `"-dest=C:\My Samples"`},
+		{`-dest=D:\`, `-dest=D:\`},
+		{`-prefix=a b\`, `"-prefix=a b\\"`},
+		{`-prefix="a"`, `"-prefix=\"a\""`},
+		{`-prefix=a\"b`, `"-prefix=a\\\"b"`},
+		{``, `""`},
+	}
+	for _, tt := range tests {
+		if got := windowsQuote(tt.arg); got != tt.want {
+			t.Errorf("windowsQuote(%s) = %s; want %s", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestCmdEscape(t *testing.T) {
+	tests := []struct{ line, want string }{
+		{`sampleSorter.exe -keyword=kick`, `sampleSorter.exe -keyword=kick`},
+		{`"C:\Program Files\s.exe" -dest=R&B`, `^"C:\Program Files\s.exe^" -dest=R^&B`},
+		{`-prefix=%USERNAME% -suffix=(a|b)^!<>`, `-prefix=^%USERNAME^% -suffix=^(a^|b^)^^^!^<^>`},
+	}
+	for _, tt := range tests {
+		if got := cmdEscape(tt.line); got != tt.want {
+			t.Errorf("cmdEscape(%s) = %s; want %s", tt.line, got, tt.want)
+		}
+	}
+}
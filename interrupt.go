@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// inFlight tracks the destination files being written so they can be removed
+// when the run is aborted before they're complete.
+var inFlight = struct {
+	sync.Mutex
+	files map[*os.File]string
+	// encrypted are the destinations of the temporary files encrypted when
+	// they're closed, see -encryptTo
+	encrypted map[*os.File]string
+}{files: map[*os.File]string{}, encrypted: map[*os.File]string{}}
+
+// handleInterrupts stops the run cleanly on the first Ctrl-C or SIGTERM by
+// cancelling its context: the walk is stopped and the file being written is
+// completed, then the run ends as usual so the manifest is still written.
+// A second signal aborts the run right away, removing the incomplete
+// destination files.
+func handleInterrupts(cancel context.CancelFunc) {
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		warnf("Interrupted, finishing the current file. Interrupt again to abort right away.")
+		cancel()
+		<-c
+		removeInFlightFiles()
+		errorf("Aborted")
+		os.Exit(exitInterrupted)
+	}()
+}
+
+// removeInFlightFiles removes the destination files that are still being
+// written, which happens when the run is aborted or a copy timed out.
+func removeInFlightFiles() {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+	for f, path := range inFlight.files {
+		f.Close()
+		delete(inFlight.files, f)
+		if err := os.Remove(path); err == nil {
+			warnf("Removed the incomplete %s", path)
+		}
+	}
+}
+
+// createDestFile creates a destination file, tracking it until closeDestFile
+// is called. With -encryptTo, a temporary file is created instead, encrypted
+// to the destination when it's closed.
+func createDestFile(path string) (*os.File, error) {
+	if destEncryption == nil {
+		return createTrackedFile(path)
+	}
+	f, err := os.CreateTemp("", "samplesorter-encrypt")
+	if err != nil {
+		return nil, err
+	}
+	inFlight.Lock()
+	inFlight.files[f] = f.Name()
+	inFlight.encrypted[f] = path
+	inFlight.Unlock()
+	return f, nil
+}
+
+// createTrackedFile creates the file at path, tracking it until closeDestFile is called.
+func createTrackedFile(path string) (*os.File, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	inFlight.Lock()
+	inFlight.files[f] = path
+	inFlight.Unlock()
+	return f, nil
+}
+
+// closeDestFile closes a file created with createDestFile.
+func closeDestFile(f *os.File) error {
+	inFlight.Lock()
+	delete(inFlight.files, f)
+	dest, encrypt := inFlight.encrypted[f]
+	delete(inFlight.encrypted, f)
+	inFlight.Unlock()
+	if !encrypt {
+		if fi, err := f.Stat(); err == nil {
+			runMetrics.written.Add(fi.Size())
+		}
+		return f.Close()
+	}
+	err := f.Close()
+	defer os.Remove(f.Name())
+	if err != nil {
+		return err
+	}
+	return encryptFile(f.Name(), dest)
+}
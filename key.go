@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// majorKeyNames and minorKeyNames are the canonical spellings of the keys,
+	// indexed by the pitch class of their root.
+	majorKeyNames = []string{"C", "Db", "D", "Eb", "E", "F", "F#", "G", "Ab", "A", "Bb", "B"}
+	minorKeyNames = []string{"Cm", "C#m", "Dm", "Ebm", "Em", "Fm", "F#m", "Gm", "G#m", "Am", "Bbm", "Bm"}
+
+	// filenameKeyPattern matches keys like Cmin, F#m, A maj or Eb minor surrounded by separators.
+	filenameKeyPattern = regexp.MustCompile(`(?:^|[\s_\-.(\[])([A-G])\s?(#|♯|b|♭|(?i:sharp|flat))?[\s_-]?((?i:minor|min|m|major|maj))(?:$|[\s_\-.)\]])`)
+	// keyPattern matches the keys passed as flags, a bare note being a major key.
+	keyPattern = regexp.MustCompile(`^([A-Ga-g])\s?(#|♯|b|♭|(?i:sharp|flat))?[\s_-]?((?i:minor|min|m|major|maj))?$`)
+)
+
+// musicalKey is a key made of the pitch class of its root and its quality.
+type musicalKey struct {
+	root  int
+	minor bool
+}
+
+// String returns the canonical spelling of the key, e.g. F#m or Eb.
+func (k musicalKey) String() string {
+	if k.minor {
+		return minorKeyNames[k.root]
+	}
+	return majorKeyNames[k.root]
+}
+
+// newKey normalizes the spelling of a key: its root letter, accidental and quality.
+func newKey(letter, accidental, quality string) (musicalKey, bool) {
+	switch strings.ToLower(accidental) {
+	case "sharp", "♯":
+		accidental = "#"
+	case "flat", "♭":
+		accidental = "b"
+	}
+	note, ok := parseNote(strings.ToUpper(letter) + accidental)
+	if !ok {
+		return musicalKey{}, false
+	}
+	quality = strings.ToLower(quality)
+	return musicalKey{root: ((note % 12) + 12) % 12, minor: quality != "" && !strings.HasPrefix(quality, "maj")}, true
+}
+
+// parseKey parses a key passed by the user, like Am, F# minor or Eb.
+func parseKey(s string) (musicalKey, bool) {
+	m := keyPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return musicalKey{}, false
+	}
+	return newKey(m[1], m[2], m[3])
+}
+
+// filenameKey returns the key found in the filename of path.
+func filenameKey(path string) (musicalKey, bool) {
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	m := filenameKeyPattern.FindStringSubmatch(stem)
+	if m == nil {
+		return musicalKey{}, false
+	}
+	return newKey(m[1], m[2], m[3])
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// kitFolder is the destination folder of the kit command when there's no keyword.
+const kitFolder = "kits"
+
+// kitSlot is a part of a drum kit: the category of its samples, like Kick, and
+// how many of them a kit gets.
+type kitSlot struct {
+	category string
+	count    int
+}
+
+// kitSlots are the parts of the kits built by the kit command, nil otherwise.
+var kitSlots []kitSlot
+
+// parseKitRecipe parses the comma separated parts of a kit, the category names
+// of the taxonomy with an optional count like hat:2.
+func parseKitRecipe(recipe string) ([]kitSlot, error) {
+	var slots []kitSlot
+	seen := map[string]bool{}
+	for _, part := range listFlag(recipe) {
+		name, count, hasCount := strings.Cut(part, ":")
+		slot := kitSlot{category: strings.ToLower(strings.TrimSpace(name)), count: 1}
+		if hasCount {
+			n, err := strconv.Atoi(strings.TrimSpace(count))
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid count in %s", part)
+			}
+			slot.count = n
+		}
+		if seen[slot.category] {
+			return nil, fmt.Errorf("%s is listed twice", slot.category)
+		}
+		seen[slot.category] = true
+		slots = append(slots, slot)
+	}
+	if len(slots) == 0 {
+		return nil, fmt.Errorf("no parts")
+	}
+	return slots, nil
+}
+
+// kitSlotOf returns the index of the kit slot of the file at path, matched by
+// the name of its category so hat fills in for Drums/Hat.
+func kitSlotOf(path string) (int, bool) {
+	category, ok := classify(path)
+	if !ok {
+		return 0, false
+	}
+	for i, slot := range kitSlots {
+		if strings.EqualFold(categoryName(category), slot.category) || strings.EqualFold(category, slot.category) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// kitStream sorts the units in the kit slots of their first file and once the
+// walk is over sends complete kits, each as a single unit so it gets its own
+// group folder. The slots are filled in the order the units came in, the
+// units left over once a slot runs out aren't sent.
+func kitStream(in <-chan []string) <-chan []string {
+	out := make(chan []string)
+	go func() {
+		defer close(out)
+		slots := make([][][]string, len(kitSlots))
+		for unit := range in {
+			if i, ok := kitSlotOf(unit[0]); ok {
+				slots[i] = append(slots[i], unit)
+			}
+		}
+		kits := -1
+		for i, slot := range kitSlots {
+			if n := len(slots[i]) / slot.count; kits < 0 || n < kits {
+				kits = n
+			}
+		}
+		if kits == 0 {
+			warnf("Not enough matches to build a complete kit")
+		}
+		for k := 0; k < kits; k++ {
+			var kit []string
+			for i, slot := range kitSlots {
+				for _, unit := range slots[i][k*slot.count : (k+1)*slot.count] {
+					kit = append(kit, unit...)
+				}
+			}
+			out <- kit
+		}
+		for i, slot := range kitSlots {
+			if left := len(slots[i]) - kits*slot.count; left > 0 {
+				debugf("%d %s samples left out of the kits", left, slot.category)
+			}
+		}
+	}()
+	return out
+}
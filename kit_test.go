@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKitRecipe(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []kitSlot
+		ok   bool
+	}{
+		{"kick", []kitSlot{{"kick", 1}}, true},
+		{"kick,snare,hat:2", []kitSlot{{"kick", 1}, {"snare", 1}, {"hat", 2}}, true},
+		{" Kick : 3 , Snare", []kitSlot{{"kick", 3}, {"snare", 1}}, true},
+		{"kick,,snare", []kitSlot{{"kick", 1}, {"snare", 1}}, true},
+		{"", nil, false},
+		{" , ", nil, false},
+		{"kick,Kick:2", nil, false},
+		{"hat:0", nil, false},
+		{"hat:-1", nil, false},
+		{"hat:two", nil, false},
+		{"hat:", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseKitRecipe(tt.in)
+		if (err == nil) != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseKitRecipe(%q) = %v, %v; want %v, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
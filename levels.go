@@ -0,0 +1,197 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// audioLevels are the peak, RMS and noise floor levels of a file in dBFS.
+type audioLevels struct {
+	Peak float64 `json:"peak"`
+	RMS  float64 `json:"rms"`
+	// NoiseFloor is the level of the quietest windows of the audio, see noiseFloor
+	NoiseFloor float64 `json:"noiseFloor"`
+	// fullScaleRun is the longest run of consecutive full scale samples of a
+	// channel, a sign of clipping
+	fullScaleRun int
+	// channelDiff is the peak level in dBFS of the difference between the
+	// channels of a stereo file
+	channelDiff float64
+	stereo      bool
+}
+
+// levelCache holds the levels measured so far by path, the filters, the
+// sorting and the manifest all need them.
+var levelCache = struct {
+	sync.Mutex
+	levels map[string]audioLevels
+}{levels: map[string]audioLevels{}}
+
+// levelsEnabled reports if the levels of the matches are measured.
+func levelsEnabled() bool {
+	return *flagLevels || *flagMinPeak != 0 || *flagMinRMS != 0 || *flagLoudestFirst || *flagClipped != "" || *flagSkipSilent || *flagMaxNoiseFloor != 0
+}
+
+// measureLevels returns the peak, RMS and noise floor levels of all the
+// channels of the WAV or AIFF file at path, its longest run of full scale
+// samples and how much its channels differ.
+func measureLevels(path string) (audioLevels, error) {
+	levelCache.Lock()
+	levels, ok := levelCache.levels[path]
+	levelCache.Unlock()
+	if ok {
+		return levels, nil
+	}
+	buf, _, err := decodeAudioFile(path)
+	if err != nil {
+		return levels, err
+	}
+	var peak, sum float64
+	fullScale := 1.0
+	if !buf.float && buf.bitDepth > 1 {
+		// the largest positive value of the integer samples
+		fullScale -= 1 / float64(int64(1)<<(buf.bitDepth-1))
+	}
+	runs := make([]int, buf.channels)
+	longest := 0
+	for i, v := range buf.data {
+		peak = math.Max(peak, math.Abs(v))
+		sum += v * v
+		c := i % buf.channels
+		if math.Abs(v) >= fullScale-1e-9 {
+			runs[c]++
+			if runs[c] > longest {
+				longest = runs[c]
+			}
+		} else {
+			runs[c] = 0
+		}
+	}
+	channelDiff := silenceDB
+	if buf.channels == 2 {
+		var diff float64
+		for i := 0; i+1 < len(buf.data); i += 2 {
+			diff = math.Max(diff, math.Abs(buf.data[i]-buf.data[i+1]))
+		}
+		channelDiff = toDB(diff)
+	}
+	rms := 0.0
+	if len(buf.data) > 0 {
+		rms = math.Sqrt(sum / float64(len(buf.data)))
+	}
+	levels = audioLevels{
+		Peak:         roundDB(toDB(peak)),
+		RMS:          roundDB(toDB(rms)),
+		NoiseFloor:   roundDB(noiseFloor(buf.mixdown())),
+		fullScaleRun: longest,
+		channelDiff:  channelDiff,
+		stereo:       buf.channels == 2,
+	}
+	levelCache.Lock()
+	levelCache.levels[path] = levels
+	levelCache.Unlock()
+	return levels, nil
+}
+
+// noiseFloorPercentile is the share of the quietest windows of the audio
+// giving its noise floor.
+const noiseFloorPercentile = 0.1
+
+// noiseFloor approximates the noise floor of the samples in dBFS: the RMS
+// level of the windows at the 10th percentile, the digitally silent ones like
+// the padding at the end of a file not counting. A clean one-shot decays to
+// near silence while a vinyl rip stays at the level of its hiss.
+func noiseFloor(samples []float64) float64 {
+	var levels []float64
+	for _, level := range rmsLevels(samples, analysisWindow, analysisWindow) {
+		if level > silenceDB {
+			levels = append(levels, level)
+		}
+	}
+	if len(levels) == 0 {
+		return silenceDB
+	}
+	sort.Float64s(levels)
+	return levels[int(float64(len(levels)-1)*noiseFloorPercentile)]
+}
+
+// roundDB rounds a level to a hundredth of dB.
+func roundDB(db float64) float64 {
+	return math.Round(db*100) / 100
+}
+
+// loudEnough reports if the file at path passes -minPeak and -minRMS, and
+// -maxNoiseFloor.
+func loudEnough(path string) bool {
+	levels, err := measureLevels(path)
+	if err != nil {
+		debugf("Couldn't measure the levels of %s - %s", path, err)
+		return false
+	}
+	if *flagMinPeak != 0 && levels.Peak < *flagMinPeak {
+		debugf("Skipping %s peaking at %.1f dBFS", path, levels.Peak)
+		return false
+	}
+	if *flagMinRMS != 0 && levels.RMS < *flagMinRMS {
+		debugf("Skipping %s with an RMS level of %.1f dBFS", path, levels.RMS)
+		return false
+	}
+	if *flagMaxNoiseFloor != 0 && levels.NoiseFloor > *flagMaxNoiseFloor {
+		debugf("Skipping %s with a noise floor of %.1f dBFS", path, levels.NoiseFloor)
+		return false
+	}
+	return true
+}
+
+// silent reports if the file at path peaks below -silenceThreshold, like the
+// blank placeholder tracks of the sample CDs.
+func silent(path string) bool {
+	levels, err := measureLevels(path)
+	if err != nil {
+		debugf("Couldn't check if %s is silent - %s", path, err)
+		return false
+	}
+	return levels.Peak < *flagSilenceThreshold
+}
+
+// fakeStereo reports if the file at path is a stereo file with the same audio
+// on both channels, their difference peaking at or below -fakeStereoThreshold.
+func fakeStereo(path string) bool {
+	levels, err := measureLevels(path)
+	if err != nil {
+		debugf("Couldn't compare the channels of %s - %s", path, err)
+		return false
+	}
+	return levels.stereo && levels.channelDiff <= *flagFakeStereoThreshold
+}
+
+// quarantineFolder is the folder of the destination the clipped samples are
+// copied to with -clipped quarantine, away from the groups.
+const quarantineFolder = "clipped"
+
+// clipped reports if the file at path has at least -clipRun consecutive full
+// scale samples on a channel.
+func clipped(path string) bool {
+	levels, err := measureLevels(path)
+	if err != nil {
+		debugf("Couldn't check %s for clipping - %s", path, err)
+		return false
+	}
+	return levels.fullScaleRun >= *flagClipRun
+}
+
+// loudestFirstStream sends the units by decreasing peak level, the loudest
+// file of a unit giving its level, so the first group folders get the loudest
+// samples.
+func loudestFirstStream(in <-chan []string) <-chan []string {
+	return sortedStream(in, func(unit []string) float64 {
+		peak := silenceDB
+		for _, path := range unit {
+			if levels, err := measureLevels(path); err == nil {
+				peak = math.Max(peak, levels.Peak)
+			}
+		}
+		return -peak
+	})
+}
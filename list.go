@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listEntry describes a match printed by -list.
+type listEntry struct {
+	Path       string  `json:"path"`
+	Size       int64   `json:"size"`
+	Duration   float64 `json:"durationSeconds,omitempty"`
+	SampleRate int     `json:"sampleRate,omitempty"`
+}
+
+// listColumns are the columns -listColumns can add after the paths.
+var listColumns = map[string]func(e *listEntry) string{
+	"size": func(e *listEntry) string { return strconv.FormatInt(e.Size, 10) },
+	"duration": func(e *listEntry) string {
+		return strconv.FormatFloat(e.Duration, 'f', 3, 64)
+	},
+	"samplerate": func(e *listEntry) string { return strconv.Itoa(e.SampleRate) },
+}
+
+// checkListColumns returns an error if the comma separated columns aren't all known.
+func checkListColumns(columns string) error {
+	for _, column := range listFlag(columns) {
+		if listColumns[column] == nil {
+			return fmt.Errorf("unknown column %s, available: %s", column, registeredNames(listColumns))
+		}
+	}
+	return nil
+}
+
+// newListEntry describes the match at path, the audio fields are left empty
+// when its header can't be read.
+func newListEntry(path string) *listEntry {
+	e := &listEntry{Path: path}
+	if fi, err := os.Stat(path); err == nil {
+		e.Size = fi.Size()
+	}
+	if info, err := readAudioInfo(path); err == nil {
+		if d, ok := info.duration(); ok {
+			e.Duration = d.Seconds()
+		}
+		e.SampleRate = info.sampleRate
+	}
+	return e
+}
+
+// printMatchList prints the matches to stdout as they're found, one per line
+// followed by the -listColumns separated by tabs, or as JSON objects, one per
+// line, with -listFormat json. With -print0, the lines end with a NUL
+// character instead of a newline, for xargs -0.
+func printMatchList(units <-chan []string) {
+	columns := listFlag(*flagListColumns)
+	end := "\n"
+	if *flagPrint0 {
+		end = "\x00"
+	}
+	for unit := range units {
+		for _, path := range unit {
+			if *flagListFormat == "json" {
+				data, _ := json.Marshal(newListEntry(path))
+				fmt.Print(string(data) + end)
+				continue
+			}
+			fields := []string{path}
+			if len(columns) > 0 {
+				e := newListEntry(path)
+				for _, column := range columns {
+					fields = append(fields, listColumns[column](e))
+				}
+			}
+			fmt.Print(strings.Join(fields, "\t") + end)
+		}
+	}
+}
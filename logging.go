@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// fileLog writes the run history to the -logFile and syslog, it discards
+// everything when neither is enabled. The warnings and errors are written to
+// it too.
+var fileLog = log.New(io.Discard, "", log.LstdFlags)
+
+// setupLogging sends the logs to the log file and syslog when enabled. The log
+// file is rotated once it's bigger than maxSize, keeping the given number of
+// backups. With syslog, the warnings and errors aren't written to stderr.
+func setupLogging(logFile string, maxSize int64, backups int, useSyslog bool) error {
+	history := []io.Writer{}
+	if logFile != "" {
+		w := &rotatingWriter{path: logFile, maxSize: maxSize, backups: backups}
+		if err := w.open(); err != nil {
+			return err
+		}
+		history = append(history, w)
+	}
+	if useSyslog {
+		w, err := newSyslogWriter()
+		if err != nil {
+			return fmt.Errorf("couldn't connect to syslog - %s", err)
+		}
+		history = append(history, w)
+		logToStderr = false
+	}
+	if len(history) == 0 {
+		return nil
+	}
+	fileLog.SetOutput(io.MultiWriter(history...))
+	fileLog.Printf("Run started: %s", strings.Join(os.Args, " "))
+	return nil
+}
+
+// rotatingWriter appends to a file, renaming it to path.1, path.2... when it
+// reaches maxSize.
+type rotatingWriter struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	f       *os.File
+	size    int64
+}
+
+func (w *rotatingWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size = f, fi.Size()
+	return nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups, dropping the oldest one, and starts a new file.
+func (w *rotatingWriter) rotate() error {
+	w.f.Close()
+	if w.backups > 0 {
+		for i := w.backups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+		}
+		if err := os.Rename(w.path, w.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(w.path); err != nil {
+		return err
+	}
+	return w.open()
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 )
 
 /*
@@ -18,172 +22,1180 @@ destination but also group them by a maximum of 128 files per subfolder folder.
 */
 
 var (
-	flagSource      = flag.String("src", "", "Path to look for samples")
-	flagKeyword     = flag.String("keyword", "", "Keyword to look for in samples")
-	flagDestination = flag.String("dest", "", "Destination of where to put the filtered samples (defaults to your user folder)")
-	flagGroupSize   = flag.Int("perFolder", 128, "Maximum of samples per destination sub folder")
-	flagDryRun      = flag.Bool("dry", false, "Enable a dry run where files aren't really copied")
-	flagDebug       = flag.Bool("debug", false, "Enable debugging logs")
-	flagMax         = flag.Int("max", 0, "Max samples to be moved")
-
-	matchingPaths = []string{}
+	flagSource              = flag.String("src", "", "Path to look for samples")
+	flagKeyword             = flag.String("keyword", "", "Keyword to look for in samples, several comma separated keywords are each copied to their own folder")
+	flagDestination         = flag.String("dest", "", "Destination of where to put the filtered samples (defaults to your user folder), a .zip file writes them to a zip archive and - a tar stream to stdout")
+	flagGroupSize           = flag.Int("perFolder", 128, "Maximum of samples per destination sub folder")
+	flagPerFolderSize       = flag.String("perFolderSize", "", "Maximum size of the samples per destination sub folder, e.g. 256MB, a new folder is started when either limit is reached")
+	flagDryRun              = flag.Bool("dry", false, "Enable a dry run where files aren't really copied")
+	flagDebug               = flag.Bool("debug", false, "Enable debugging logs")
+	flagRef                 = flag.String("ref", "", "Reference sample of the similar command")
+	flagIndex               = flag.String("index", "", "Similarity index file written by the index command, searched by the similar command instead of analyzing the matches")
+	flagMax                 = flag.Int("max", 0, "Max samples to be moved")
+	flagManifest            = flag.Bool("manifest", false, "Write a manifest.json describing the run to the destination")
+	flagPrefix              = flag.String("prefix", "", "Prefix to add to the destination filenames")
+	flagSuffix              = flag.String("suffix", "", "Suffix to add to the destination filenames (before the extension)")
+	flagParentPrefix        = flag.Bool("parentPrefix", false, "Prepend the name of the folder of the matches, usually their pack, to the destination filenames, e.g. Vengeance__kick_01.wav")
+	flagSlug                = flag.Bool("slug", false, "Convert destination filenames to lowercase ASCII with underscores")
+	flagSkipCorrupt         = flag.Bool("skipCorrupt", false, "Validate the WAV/AIFF headers of the matches and skip corrupt or truncated files")
+	flagRepair              = flag.Bool("repair", false, "Write corrected copies of files with wrong chunk sizes or a missing fact chunk")
+	flagFloatToPCM          = flag.Bool("floatToPCM", false, "Convert IEEE float WAV files to 24 bit PCM when copying")
+	flagFoldFakeStereo      = flag.Bool("foldFakeStereo", false, "Fold the stereo files with the same audio on both channels to mono when copying")
+	flagFakeStereoThreshold = flag.Float64("fakeStereoThreshold", -60, "Level in dBFS at or below which the difference between the channels of a stereo file is ignored by -foldFakeStereo")
+	flagSplitStereo         = flag.Bool("splitStereo", false, "Split stereo files into _L and _R mono files when copying")
+	flagProjectAudio        = flag.Bool("projectAudio", false, "Also search the folders of DAW projects holding recordings, bounces and renders, like Samples/Recorded, Ableton Project Info, Bounces and the insides of Logic packages")
+	flagCompanions          = flag.Bool("companions", false, "Copy the companion files of the samples along with them: .asd, .reapeaks, .nki and .txt files with the same name")
+	flagNestLayers          = flag.Bool("nestLayers", false, "Copy the velocity layers of an instrument, like Snare_soft.wav and Snare_hard.wav, to a subfolder named after it in the group folder")
+	flagMergePairs          = flag.Bool("mergePairs", false, "Merge _L/_R dual mono pairs into a single stereo file when copying")
+	flagRepitchTo           = flag.String("repitchTo", "", "Repitch the samples with a known root note to this note (e.g. C or F#) when copying")
+	flagStretchTo           = flag.Float64("stretchTo", 0, "Time stretch the loops with a known tempo to this BPM when copying")
+	flagSplitSilence        = flag.Bool("splitSilence", false, "Split long recordings at their silent gaps, each segment becoming its own file")
+	flagSilenceThreshold    = flag.Float64("silenceThreshold", -60, "Level in dBFS under which the audio is considered silent")
+	flagMinSilence          = flag.Duration("minSilence", 500*time.Millisecond, "Minimum length of a silent gap to split at")
+	flagMinSegment          = flag.Duration("minSegment", time.Second, "Minimum length of the segments split at silences, shorter ones are dropped")
+	flagChop                = flag.Bool("chop", false, "Chop the matches at their transients into individual one-shot files")
+	flagChopThreshold       = flag.Float64("chopThreshold", 12, "Level rise in dB detected as a transient when chopping")
+	flagBPM                 = flag.String("bpm", "", "Only match files with a tempo in their filename within this range, e.g. 120 or 120-130")
+	flagLooped              = flag.String("looped", "", "Only match the samples with loop points in their smpl or INST chunk (yes) or without (no)")
+	flagMinPeak             = flag.Float64("minPeak", 0, "Only match the samples peaking at or above this level in dBFS, e.g. -30")
+	flagMinRMS              = flag.Float64("minRMS", 0, "Only match the samples with an RMS level at or above this level in dBFS, e.g. -40")
+	flagMaxNoiseFloor       = flag.Float64("maxNoiseFloor", 0, "Only match the samples with an approximate noise floor at or below this level in dBFS, e.g. -70 to leave out hissy vinyl rips")
+	flagLoudestFirst        = flag.Bool("loudestFirst", false, "Sort the matches by decreasing peak level so the first group folders get the loudest samples")
+	flagFeatures            = flag.Bool("features", false, "Include in the manifest a feature vector per sample for external tools: the means and standard deviations of its 13 MFCCs")
+	flagLevels              = flag.Bool("levels", false, "Measure the peak, RMS and noise floor levels of the samples and include them in the manifest, implied by the level filters, -loudestFirst, -clipped and -skipSilent")
+	flagSkipSilent          = flag.Bool("skipSilent", false, "Skip the files peaking below -silenceThreshold, like the blank tracks of sample CDs")
+	flagClipped             = flag.String("clipped", "", "Skip the clipped samples (skip) or copy them to a clipped folder at the destination instead of the groups (quarantine)")
+	flagClipRun             = flag.Int("clipRun", 4, "Number of consecutive full scale samples on a channel for -clipped to consider a sample clipped")
+	flagHitsPerBar          = flag.String("hitsPerBar", "", "Only match the loops with this number of hits per bar or within this range, e.g. 2-6 for sparse percussion loops")
+	flagSubfolders          = flag.String("subfolders", "", "Template of the subfolders to sort the matches into before grouping them, e.g. {bpm}bpm")
+	flagKey                 = flag.String("key", "", "Only match files with one of these comma separated keys in their filename, e.g. Am,C")
+	flagTaxonomy            = flag.String("taxonomy", "", "Path of a JSON taxonomy file replacing the built-in one")
+	flagGroupBy             = flag.String("groupBy", "", "Sort the matches into subfolders by these comma separated criteria before grouping them: alpha (A-D, E-H...), duration (0-1s, 1-5s, 5s+), brightness (0-1500Hz, 1500-4000Hz, 4000Hz+), density of the loops (0-4, 4-8, 8+ hits per bar), samplerate, channels (mono, stereo...), year or month (2024-03) of modification, loop (looped or oneshot), category (Kick, Snare...) or family (Drums...) as classified, bpm, key or keyword")
+	flagBrightnessBuckets   = flag.String("brightnessBuckets", "1500,4000", "Comma separated limits in Hz of the spectral centroid folders of -groupBy brightness, from dark to bright")
+	flagDarkFirst           = flag.Bool("darkFirst", false, "Sort the matches by increasing brightness, their spectral centroid, so the first group folders get the darkest samples")
+	flagDensityBuckets      = flag.String("densityBuckets", "4,8", "Comma separated limits in hits per bar of the onset density folders of -groupBy density")
+	flagDurationBuckets     = flag.String("durationBuckets", "1s,5s", "Comma separated limits of the duration folders of -groupBy duration")
+	flagList                = flag.Bool("list", false, "Print the paths of the matches instead of copying them")
+	flagListColumns         = flag.String("listColumns", "", "Comma separated columns to print after the paths with -list: size, duration and samplerate")
+	flagListFormat          = flag.String("listFormat", "text", "Format of -list: text, or json for a JSON object per line")
+	flagPrint0              = flag.Bool("print0", false, "End the lines of -list with a NUL character instead of a newline, for xargs -0")
+	flagFromList            = flag.String("fromList", "", "Copy the files listed in this file, one path per line, or in a manifest.json instead of searching the source for matches")
+	flagLicenses            = flag.String("licenses", "", "Copy the license and readme files found with the matches to their group folders (group) or to a licenses folder at the destination (folder)")
+	flagRightsReport        = flag.Bool("rightsReport", false, "Write a RIGHTS.CSV to the destination listing the artists and copyrights found in the INFO, bext and ID3 metadata of the samples of each group")
+	flagCredits             = flag.Bool("credits", false, "Write a CREDITS.md to the destination listing the packs the samples come from and quoting the license and readme files found with them")
+	flagBagIt               = flag.Bool("bagit", false, "Package the destination as a BagIt bag, the files in its data folder with their checksums and the run parameters in bag-info.txt")
+	flagBitwig              = flag.Bool("bitwig", false, "Write a Bitwig .multisample next to the multisample sets, velocity layers and round robin variations found among the matches, mapped from the root notes of their smpl chunks or filenames")
+	flagRenoise             = flag.Bool("renoise", false, "Write a Renoise .xrni instrument per group folder mapping its samples like -samplerPatch, its round robin variations played in turn")
+	flagNoteMap             = flag.String("noteMap", "", "Write a file per group folder assigning its samples in order to consecutive MIDI notes for the drum samplers: json, csv or midnam (MIDI Name Document)")
+	flagNoteMapStart        = flag.Int("noteMapStart", 36, "MIDI note the first sample of a group is assigned to by -noteMap, 36 is the General MIDI kick")
+	flagSamplerPatch        = flag.String("samplerPatch", "", "Write a sampler patch per group folder mapping its samples on the keyboard, a sample set spread over the keys and other samples a note each from -noteMapStart, the round robin variations alternating: json, xml, sfz or dspreset (DecentSampler)")
+	flagCrate               = flag.String("crate", "", "Comma separated DJ software to list the copies in for auditioning: serato for a crate in the Serato library of your Music folder, rekordbox for a rekordbox.xml playlist at the destination")
+	flagChecksums           = flag.String("checksums", "", "Write the SHA-256 digests of the copies to a .sha256 sidecar per file (sidecar) or to a SHA256SUMS file per group (sums), checked by the verify command")
+	flagArchiveFormat       = flag.String("archiveFormat", "", "Set to flac to losslessly compress the copied WAV and AIFF files, the decode command restores them")
+	flagEncryptTo           = flag.String("encryptTo", "", "Encrypt the copies to this age recipient or SSH public key with age, or to this GPG key with gpg, e.g. for a cloud synced destination")
+	flagMirror              = flag.String("mirror", "", "Comma separated extra destinations, e.g. a backup drive, receiving a verified copy of everything written to -dest")
+	flagWalkCache           = flag.String("walkCache", "", "File caching the listings of the source folders between runs, the unchanged folders aren't read again")
+	flagIgnoreFile          = flag.String("ignoreFile", defaultIgnoreFile, "File listing the paths, filenames or sha256:<digest> of files never to match, one per line")
+	flagClassify            = flag.Bool("classify", false, "Match every sample the taxonomy can classify, sorted in category subfolders, instead of requiring a keyword")
+	flagMatchers            = flag.String("matchers", "", "Comma separated list of registered matchers to apply on top of the keyword")
+	flagKitRecipe           = flag.String("kitRecipe", "kick,snare,clap,hat:2,percussion", "Comma separated taxonomy categories making a kit of the kit command, with the number of samples of the category when more than one, e.g. hat:2")
+	flagClassifier          = flag.String("classifier", "taxonomy", "Name of the registered classifier used to categorize the samples")
+	flagProcessors          = flag.String("processors", "", "Comma separated list of registered processors to apply to the matches when copying")
+	flagPreHook             = flag.String("preHook", "", "Shell command to run before copying, the run is aborted if it fails")
+	flagPostHook            = flag.String("postHook", "", "Shell command to run once the run is over")
+	flagGroupHook           = flag.String("groupHook", "", "Shell command to run after each group folder is written along its note map, patch, checksums and mirror, or added to the archive")
+	flagFileHook            = flag.String("fileHook", "", "Shell command to run after each file is copied")
+	flagFatSafe             = flag.Bool("fatSafe", false, "Make the destination compatible with FAT32/exFAT cards: safe names and no files over 4GB")
+	flagDOS83               = flag.Bool("dos83", false, "Use unique 8.3 destination names for vintage hardware and write a NAMES.CSV mapping them to the originals")
+	flagMaxTotalSize        = flag.String("maxTotalSize", "", "Max total size of the samples to be moved, e.g. 8GB, the run stops before the file that would go over")
+	flagCheckSpaceFirst     = flag.Bool("checkSpaceFirst", false, "Check the destination has room for all the matches before copying anything, which waits for the end of the search and keeps the list of matches in memory")
+	flagEstimate            = flag.Bool("estimate", false, "Print the projected file count, group count, size and duration of the run without copying anything")
+	flagCPUProfile          = flag.String("cpuProfile", "", "Write a pprof CPU profile of the bench command to this file")
+	flagMemProfile          = flag.String("memProfile", "", "Write a pprof memory profile of the bench command to this file")
+	flagTimeout             = flag.Duration("timeout", 0, "Stop the run after this duration, the file being copied is completed")
+	flagFileTimeout         = flag.Duration("fileTimeout", 0, "Give up on a file taking longer than this to copy, e.g. on a hanging network mount")
+	flagRetries             = flag.Int("retries", 0, "Number of times to retry a failed copy, e.g. on a flaky USB or network drive")
+	flagRetryDelay          = flag.Duration("retryDelay", time.Second, "Delay before the first retry of a failed copy, doubled after each attempt")
+	flagLogFile             = flag.String("logFile", "", "Append a timestamped log of the run to this file")
+	flagLogMaxSize          = flag.String("logMaxSize", "10MB", "Size at which the log file is rotated")
+	flagLogBackups          = flag.Int("logBackups", 3, "Number of rotated log files to keep")
+	flagSyslog              = flag.Bool("syslog", false, "Log to syslog/journald instead of stderr")
+	flagMetricsAddr         = flag.String("metricsAddr", "", "Serve Prometheus metrics of the run at /metrics on this address, e.g. :9090")
+	flagMetricsFile         = flag.String("metricsFile", "", "Write Prometheus metrics of the run to this file at the end, for the node exporter textfile collector")
+	flagNotify              = flag.Bool("notify", false, "Show a desktop notification when the run is over")
+	flagWebhook             = flag.String("webhook", "", "URL to POST a JSON summary of the run to when it's over")
+	flagChatWebhook         = flag.String("chatWebhook", "", "Slack or Discord incoming webhook URL to post a summary of the run to")
+	flagColor               = flag.String("color", "auto", "Colorize the output: auto, always or never. Auto disables the colors when the output isn't a terminal or NO_COLOR is set")
+	flagDedupe              = flag.Bool("dedupe", false, "Only copy one instance of the files with variant names like kick (1).wav or kick copy.wav and the same content")
+	flagVersionPolicy       = flag.String("versionPolicy", "", "When files with the same name but different content are found in several places, only copy the first, newest, largest or quality (highest bit depth and sample rate) one")
+	flagDupeLinks           = flag.String("dupeLinks", "", "With -dedupe, create sym or hard links to the first copy for the duplicates instead of skipping them")
+	flagHashMode            = flag.String("hashMode", "full", "How files are compared by -dedupe and diff: full hashes the whole file, audio only hashes the samples of WAV/AIFF files to ignore metadata edits")
+	flagFingerprint         = flag.Float64("fingerprint", 0, "With -dedupe, also treat as duplicates the files of any name and a duration within 5s whose Chromaprint fingerprints are at least this similar (0 to 1, e.g. 0.9), needs fpcalc")
+	flagPreserveTree        = flag.Bool("preserveTree", false, "Shorthand for -layout preserve")
+	flagLayout              = flag.String("layout", layoutFlat, "Destination layout: flat numbered groups, preserve to recreate the source folders of the matches or hybrid for numbered groups in a folder per pack (top source folder)")
+	flagHashWorkers         = flag.Int("hashWorkers", runtime.NumCPU(), "Number of files hashed concurrently by -dedupe")
+
+	// copiedFiles maps the copied sources to their destination
+	copiedFiles = map[string]string{}
+	// matchCount is the number of matches found by the walk
+	matchCount int
+	// keywords are the keywords of -keyword
+	keywords []string
+	// sourceWalkCache lists the source folders when -walkCache is set
+	sourceWalkCache *walkCache
 )
 
+// commands are the optional subcommands that can be passed before the flags.
+// Without a command, the matching samples are copied to the destination.
+var commands = []struct {
+	name        string
+	description string
+}{
+	{"validate", "Report the malformed audio files found in the source folder"},
+	{"taxonomy", "Print the taxonomy used to classify the samples as JSON"},
+	{"bench", "Measure the walk, hash and copy throughput on the source folder"},
+	{"decode", "Restore the WAV and AIFF files compressed with -archiveFormat flac in the source folder, to -dest when set"},
+	{"verify", "Check the files of the source folder against the SHA256SUMS and .sha256 files written with -checksums"},
+	{"similar", "Copy the matches sounding the most like the -ref sample, the 20 closest or -max"},
+	{"index", "Build the similarity index of the samples of the source folder at -index, for similar -index"},
+	{"kit", "Build drum kits of the -kitRecipe parts from the classified matches, a group folder per kit, at most -max kits"},
+	{"diff", "List the matches that are new, identical or changed compared to the destination"},
+	{"install-integration", "Add \"Sort samples from here…\" to the folder context menu of the file manager, running with the flags passed and the folder as -src, remove it with install-integration remove"},
+	{"completion", "Print the bash, zsh or fish completion script, e.g. completion bash"},
+	{"update", "Replace the binary by the latest release, only check for one with -dry"},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	width := 0
+	for _, cmd := range commands {
+		width = max(width, len(cmd.name))
+	}
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-*s %s\n", width, cmd.name, cmd.description)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nExit codes:\n  %-3d success\n  %-3d fatal error\n  %-3d invalid flags\n  %-3d no matching samples\n  %-3d some files failed or the run was stopped early\n  %-3d interrupted\n",
+		exitOK, exitFatal, 2, exitNoMatches, exitPartialFailure, exitInterrupted)
+}
+
 func main() {
+	command := ""
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	flag.Usage = usage
 	flag.Parse()
-	if *flagSource == "" {
-		log.Println("You need to pass a source path to search: -src=<path where to search>")
-		flag.Usage()
-		os.Exit(1)
+	*flagKeyword = strings.ToLower(*flagKeyword)
+	keywords = listFlag(*flagKeyword)
+
+	if *flagDestination == stdoutDest || *flagList {
+		// keep stdout for the tar stream or the list of the matches
+		messageOutput = os.Stderr
 	}
-	if *flagKeyword == "" {
-		log.Println("You need to pass a keyword to search for: -keyword=<path where to search>")
-		flag.Usage()
-		os.Exit(1)
+	if err := setupColor(*flagColor); err != nil {
+		errorf("Invalid color mode - %s", err)
+		os.Exit(exitFatal)
 	}
-	*flagKeyword = strings.ToLower(*flagKeyword)
 
 	usr, err := user.Current()
 	if err != nil {
-		log.Println("Failed to get the user home directory")
-		os.Exit(1)
+		errorf("Failed to get the user home directory")
+		os.Exit(exitFatal)
 	}
 
-	if *flagDestination == "" {
-		*flagDestination = usr.HomeDir
+	logMaxSize, err := parseSize(*flagLogMaxSize)
+	if err != nil {
+		errorf("Invalid log file max size - %s", err)
+		os.Exit(exitFatal)
+	}
+	logFile := ""
+	if *flagLogFile != "" {
+		logFile = expandPath(*flagLogFile, usr.HomeDir)
+	}
+	if err := setupLogging(logFile, logMaxSize, *flagLogBackups, *flagSyslog); err != nil {
+		errorf("Failed to set up the logs - %s", err)
+		os.Exit(exitFatal)
+	}
+	if err := checkPlugins(); err != nil {
+		errorf("%s", err)
+		os.Exit(exitFatal)
+	}
+	if *flagTaxonomy != "" {
+		if err := loadTaxonomy(expandPath(*flagTaxonomy, usr.HomeDir)); err != nil {
+			errorf("Failed to load the taxonomy - %s", err)
+			os.Exit(exitFatal)
+		}
+	}
+	if err := loadIgnoreList(expandPath(*flagIgnoreFile, usr.HomeDir), usr.HomeDir); err != nil && (*flagIgnoreFile != defaultIgnoreFile || !os.IsNotExist(err)) {
+		errorf("Failed to load the ignore list - %s", err)
+		os.Exit(exitFatal)
+	}
+	// commands that don't need a source
+	switch command {
+	case "taxonomy":
+		printTaxonomy()
+		return
+	case "update":
+		if err := selfUpdate(); err != nil {
+			errorf("Update failed - %s", err)
+			os.Exit(exitFatal)
+		}
+		return
+	case "completion":
+		if err := printCompletion(os.Stdout, flag.Arg(0)); err != nil {
+			errorf("%s", err)
+			os.Exit(exitFatal)
+		}
+		return
+	case "install-integration":
+		remove := flag.Arg(0) == "remove"
+		if flag.NArg() > 0 && !remove {
+			errorf("Unknown argument %s, install-integration only takes remove", flag.Arg(0))
+			os.Exit(exitFatal)
+		}
+		if err := installIntegration(usr.HomeDir, remove); err != nil {
+			errorf("Failed to install the file manager integration - %s", err)
+			os.Exit(exitFatal)
+		}
+		return
 	}
 
+	if *flagSource == "" && *flagFromList == "" && (command != "similar" || *flagIndex == "") {
+		errorf("You need to pass a source path to search: -src=<path where to search>")
+		flag.Usage()
+		os.Exit(exitFatal)
+	}
 	// expand the paths
-	sourcePath := *flagSource
-	if sourcePath[:2] == "~/" {
-		sourcePath = strings.Replace(sourcePath, "~", usr.HomeDir, 1)
+	sourcePath := expandPath(*flagSource, usr.HomeDir)
+
+	var similarRef *audioFeatures
+	// similarPaths are the closest samples found in the index by the similar command
+	var similarPaths []string
+	switch command {
+	case "", "diff":
+	case "similar":
+		if *flagRef == "" {
+			errorf("You need to pass the sample to look for similar ones to: -ref=<path of the sample>")
+			os.Exit(exitFatal)
+		}
+		if *flagIndex != "" {
+			idx, err := loadSimilarityIndex(expandPath(*flagIndex, usr.HomeDir))
+			if err != nil {
+				errorf("Failed to load the similarity index - %s", err)
+				os.Exit(exitFatal)
+			}
+			count := *flagMax
+			if count == 0 {
+				count = defaultSimilarCount
+			}
+			if similarPaths, err = similarFromIndex(idx, expandPath(*flagRef, usr.HomeDir), count); err != nil {
+				errorf("Couldn't search the index for %s - %s", *flagRef, err)
+				os.Exit(exitFatal)
+			}
+			break
+		}
+		ref, err := fileFeatures(expandPath(*flagRef, usr.HomeDir))
+		if err != nil {
+			errorf("Couldn't analyze the reference sample %s - %s", *flagRef, err)
+			os.Exit(exitFatal)
+		}
+		similarRef = ref
+	case "kit":
+		slots, err := parseKitRecipe(*flagKitRecipe)
+		if err != nil {
+			errorf("Invalid kit recipe - %s", err)
+			os.Exit(exitFatal)
+		}
+		kitSlots = slots
+		// a group folder per kit, a kit being sent as a single unit
+		*flagGroupSize = 1
+	case "index":
+		if *flagIndex == "" {
+			errorf("You need to pass the path of the index to build: -index=<path of the index file>")
+			os.Exit(exitFatal)
+		}
+		if err := indexSamples(sourcePath, expandPath(*flagIndex, usr.HomeDir)); err != nil {
+			errorf("Failed to index the samples - %s", err)
+			os.Exit(exitFatal)
+		}
+		return
+	case "validate":
+		validateSamples(sourcePath)
+		return
+	case "verify":
+		if verifyChecksums(sourcePath) > 0 {
+			os.Exit(exitPartialFailure)
+		}
+		return
+	case "decode":
+		destDir := ""
+		if *flagDestination != "" {
+			destDir = expandPath(*flagDestination, usr.HomeDir)
+		}
+		if decodeFlacFolder(sourcePath, destDir) > 0 {
+			os.Exit(exitPartialFailure)
+		}
+		return
+	case "bench":
+		destDir := os.TempDir()
+		if *flagDestination != "" {
+			destDir = expandPath(*flagDestination, usr.HomeDir)
+			if err := os.MkdirAll(destDir, 0777); err != nil {
+				errorf("Failed to create the destination folder - %s", err)
+				os.Exit(exitFatal)
+			}
+		}
+		stopProfiling := startProfiling()
+		benchSamples(sourcePath, destDir)
+		stopProfiling()
+		return
+	default:
+		errorf("Unknown command %s", command)
+		flag.Usage()
+		os.Exit(exitFatal)
+	}
+
+	if *flagClassify && *flagSubfolders == "" {
+		*flagSubfolders = "{category}"
+	}
+	if _, err := parseDurationBuckets(*flagDurationBuckets); err != nil {
+		errorf("Invalid duration buckets - %s", err)
+		os.Exit(exitFatal)
+	}
+	if _, err := parseDensityBuckets(*flagDensityBuckets); err != nil {
+		errorf("Invalid density buckets - %s", err)
+		os.Exit(exitFatal)
+	}
+	if _, err := parseBrightnessBuckets(*flagBrightnessBuckets); err != nil {
+		errorf("Invalid brightness buckets - %s", err)
+		os.Exit(exitFatal)
+	}
+	if *flagGroupBy != "" {
+		template, err := groupByTemplate(*flagGroupBy)
+		if err != nil {
+			errorf("Invalid grouping - %s", err)
+			os.Exit(exitFatal)
+		}
+		if *flagSubfolders != "" {
+			template = *flagSubfolders + "/" + template
+		}
+		*flagSubfolders = template
+	}
+	if *flagKeyword == "" && !*flagClassify && *flagMatchers == "" && *flagFromList == "" && command != "similar" && command != "kit" {
+		errorf("You need to pass a keyword to search for: -keyword=<path where to search>")
+		flag.Usage()
+		os.Exit(exitFatal)
+	}
+	if *flagDestination == "" {
+		*flagDestination = usr.HomeDir
+	}
+	destRoot := stdoutDest
+	if *flagDestination != stdoutDest {
+		destRoot = expandPath(*flagDestination, usr.HomeDir)
+	}
+	destPath := destRoot
+	if len(keywords) > 1 {
+		// each keyword gets its own folder with its own groups
+		*flagSubfolders = strings.TrimSuffix("{keyword}/"+*flagSubfolders, "/")
+	} else if *flagKeyword == "" && command == "similar" {
+		destPath = filepath.Join(destPath, destFolderName(similarFolder(*flagRef)))
+	} else if *flagKeyword == "" && command == "kit" {
+		destPath = filepath.Join(destPath, kitFolder)
+	} else {
+		destPath = filepath.Join(destPath, mapPath(*flagKeyword, destFolderName))
+	}
+	if err := checkTemplate(*flagSubfolders); err != nil {
+		errorf("Invalid subfolders template - %s", err)
+		os.Exit(exitFatal)
+	}
+	if *flagHitsPerBar != "" {
+		if _, _, err := parseRange(*flagHitsPerBar); err != nil {
+			errorf("Invalid hits per bar range - %s", err)
+			os.Exit(exitFatal)
+		}
+	}
+	if *flagBPM != "" {
+		if _, _, err := parseRange(*flagBPM); err != nil {
+			errorf("Invalid BPM range - %s", err)
+			os.Exit(exitFatal)
+		}
+	}
+	for _, key := range strings.Split(*flagKey, ",") {
+		if _, ok := parseKey(key); *flagKey != "" && !ok {
+			errorf("Invalid key: %s", key)
+			os.Exit(exitFatal)
+		}
+	}
+	if *flagDupeLinks != "" && *flagDupeLinks != "sym" && *flagDupeLinks != "hard" {
+		errorf("Invalid link type %s, use sym or hard", *flagDupeLinks)
+		os.Exit(exitFatal)
+	}
+	if *flagDupeLinks != "" && !*flagDedupe {
+		errorf("-dupeLinks links the duplicates found by -dedupe, add -dedupe")
+		os.Exit(exitFatal)
+	}
+	if isArchiveDest(destRoot) {
+		switch {
+		case *flagMirror != "":
+			errorf("-mirror can't be used with an archive destination")
+			os.Exit(exitFatal)
+		case *flagDupeLinks == "hard":
+			errorf("Archives can't store hard links, use -dupeLinks sym")
+			os.Exit(exitFatal)
+		case command == "diff":
+			errorf("Can't compare the sources with an archive destination")
+			os.Exit(exitFatal)
+		}
+	}
+	if *flagLicenses != "" && *flagLicenses != "group" && *flagLicenses != "folder" {
+		errorf("Invalid licenses mode %s, use group or folder", *flagLicenses)
+		os.Exit(exitFatal)
+	}
+	if *flagChecksums != "" {
+		if *flagChecksums != "sidecar" && *flagChecksums != "sums" {
+			errorf("Invalid checksums mode %s, use sidecar or sums", *flagChecksums)
+			os.Exit(exitFatal)
+		}
+		if isArchiveDest(destRoot) {
+			errorf("-checksums can't be used with an archive destination")
+			os.Exit(exitFatal)
+		}
+	}
+	if *flagNoteMap != "" {
+		if _, ok := noteMapFormats[*flagNoteMap]; !ok {
+			errorf("Invalid note map format %s, use json, csv or midnam", *flagNoteMap)
+			os.Exit(exitFatal)
+		}
+		if *flagNoteMapStart < 0 || *flagNoteMapStart > 127 {
+			errorf("Invalid note map start %d, use a MIDI note between 0 and 127", *flagNoteMapStart)
+			os.Exit(exitFatal)
+		}
+		if isArchiveDest(destRoot) {
+			errorf("-noteMap can't be used with an archive destination")
+			os.Exit(exitFatal)
+		}
+	}
+	if *flagSamplerPatch != "" {
+		if _, ok := samplerPatchFormats[*flagSamplerPatch]; !ok {
+			errorf("Invalid sampler patch format %s, use json, xml, sfz or dspreset", *flagSamplerPatch)
+			os.Exit(exitFatal)
+		}
+		if isArchiveDest(destRoot) {
+			errorf("-samplerPatch can't be used with an archive destination")
+			os.Exit(exitFatal)
+		}
+	}
+	if *flagBitwig && isArchiveDest(destRoot) {
+		errorf("-bitwig can't be used with an archive destination")
+		os.Exit(exitFatal)
+	}
+	if *flagRenoise && isArchiveDest(destRoot) {
+		errorf("-renoise can't be used with an archive destination")
+		os.Exit(exitFatal)
+	}
+	for _, format := range listFlag(*flagCrate) {
+		if !crateFormats[format] {
+			errorf("Invalid crate format %s, use serato or rekordbox", format)
+			os.Exit(exitFatal)
+		}
+		if isArchiveDest(destRoot) {
+			errorf("-crate can't be used with an archive destination")
+			os.Exit(exitFatal)
+		}
+	}
+	if *flagArchiveFormat != "" && *flagArchiveFormat != "flac" {
+		errorf("Invalid archive format %s, only flac is supported", *flagArchiveFormat)
+		os.Exit(exitFatal)
+	}
+	if *flagEncryptTo != "" {
+		if isArchiveDest(destRoot) {
+			errorf("-encryptTo can't be used with an archive destination, encrypt the archive instead")
+			os.Exit(exitFatal)
+		}
+		if *flagBitwig {
+			errorf("-bitwig can't be used with -encryptTo, the multisamples would hold the samples unencrypted")
+			os.Exit(exitFatal)
+		}
+		if *flagRenoise {
+			errorf("-renoise can't be used with -encryptTo, the instruments would hold the samples unencrypted")
+			os.Exit(exitFatal)
+		}
+		if *flagCrate != "" {
+			errorf("-crate can't be used with -encryptTo, the DJ software can't play the encrypted copies")
+			os.Exit(exitFatal)
+		}
+		if destEncryption, err = newEncryption(*flagEncryptTo); err != nil {
+			errorf("Invalid encryption - %s", err)
+			os.Exit(exitFatal)
+		}
+		currentRun.Encryption = destEncryption
+	}
+	if *flagFingerprint < 0 || *flagFingerprint > 1 {
+		errorf("Invalid fingerprint similarity %g, use a value between 0 and 1", *flagFingerprint)
+		os.Exit(exitFatal)
+	}
+	if *flagFingerprint > 0 {
+		if _, err := exec.LookPath(fpcalc); err != nil {
+			errorf("-fingerprint needs the Chromaprint %s tool in the PATH - %s", fpcalc, err)
+			os.Exit(exitFatal)
+		}
+	}
+	if *flagPreserveTree {
+		*flagLayout = layoutPreserve
+	}
+	if *flagLayout != layoutFlat && *flagLayout != layoutPreserve && *flagLayout != layoutHybrid {
+		errorf("Invalid layout %s, use flat, preserve or hybrid", *flagLayout)
+		os.Exit(exitFatal)
+	}
+	if *flagHashMode != "full" && *flagHashMode != "audio" {
+		errorf("Invalid hash mode %s, use full or audio", *flagHashMode)
+		os.Exit(exitFatal)
+	}
+	if _, ok := versionPolicies[*flagVersionPolicy]; *flagVersionPolicy != "" && !ok {
+		errorf("Invalid version policy %s, use first, newest, largest or quality", *flagVersionPolicy)
+		os.Exit(exitFatal)
+	}
+	if err := checkListColumns(*flagListColumns); err != nil {
+		errorf("Invalid list columns - %s", err)
+		os.Exit(exitFatal)
+	}
+	if *flagClipped != "" && *flagClipped != "skip" && *flagClipped != "quarantine" {
+		errorf("Invalid clipped mode %s, use skip or quarantine", *flagClipped)
+		os.Exit(exitFatal)
+	}
+	if *flagClipRun < 1 {
+		errorf("-clipRun needs to be at least 1")
+		os.Exit(exitFatal)
+	}
+	if *flagLooped != "" && *flagLooped != "yes" && *flagLooped != "no" {
+		errorf("Invalid looped filter %s, use yes or no", *flagLooped)
+		os.Exit(exitFatal)
+	}
+	if *flagListFormat != "text" && *flagListFormat != "json" {
+		errorf("Invalid list format %s, use text or json", *flagListFormat)
+		os.Exit(exitFatal)
+	}
+	var maxGroupSize int64
+	if *flagPerFolderSize != "" {
+		if maxGroupSize, err = parseSize(*flagPerFolderSize); err != nil {
+			errorf("Invalid per folder size - %s", err)
+			os.Exit(exitFatal)
+		}
+	}
+	var maxTotalSize int64
+	if *flagMaxTotalSize != "" {
+		if maxTotalSize, err = parseSize(*flagMaxTotalSize); err != nil {
+			errorf("Invalid max total size - %s", err)
+			os.Exit(exitFatal)
+		}
+	}
+	if *flagRepitchTo != "" {
+		if _, ok := parseNote(*flagRepitchTo); !ok {
+			errorf("Invalid note to repitch to: %s", *flagRepitchTo)
+			os.Exit(exitFatal)
+		}
+	}
+	// infoDir is where the manifest and mapping are written, the root of the
+	// bag with -bagit which has the files in its data folder
+	infoDir := destPath
+	if *flagBagIt {
+		if isArchiveDest(destRoot) {
+			errorf("-bagit can't be used with an archive destination")
+			os.Exit(exitFatal)
+		}
+		destPath = filepath.Join(destPath, bagPayloadFolder)
+	}
+	setupMirrors(*flagMirror, destRoot, destPath, usr.HomeDir)
+	if command == "diff" {
+		diffSamples(sourcePath, destPath)
+		return
+	}
+	destName := infoDir
+	if isArchiveDest(destRoot) && !*flagDryRun && !*flagList && !*flagEstimate {
+		if destArchive, err = createArchive(destRoot); err != nil {
+			errorf("Failed to create the archive %s - %s", destRoot, err)
+			os.Exit(exitFatal)
+		}
+		// the files are staged under the same paths as in the archive
+		rel, _ := filepath.Rel(destRoot, destPath)
+		destPath, destName = filepath.Join(destArchive.staging, rel), destRoot
+		infoDir = destPath
 	}
-	destPath := *flagDestination
-	if destPath[:2] == "~/" {
-		destPath = strings.Replace(destPath, "~", usr.HomeDir, 1)
+	currentRun.Source, currentRun.Destination, currentRun.Keyword = sourcePath, destName, *flagKeyword
+	if *flagMetricsAddr != "" {
+		serveMetrics(*flagMetricsAddr)
 	}
-	destPath = filepath.Join(destPath, *flagKeyword)
 
-	// recursively search for matching file names in the src folder
-	matchingPaths, err = findMatchingFiles(sourcePath, *flagKeyword)
-	if err != nil {
-		log.Println("Something went wrong looking for matching files", err)
-		os.Exit(1)
+	if err := runHook(*flagPreHook, nil); err != nil {
+		errorf("The pre hook failed, aborting - %s", err)
+		os.Exit(exitFatal)
 	}
 
-	fmt.Printf("Found %d matching files to copy\n", len(matchingPaths))
+	// recursively search for matching file names in the src folder and copy
+	// them as they are found
+	ctx, cancel := context.WithCancel(context.Background())
+	if *flagTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), *flagTimeout)
+	}
+	defer cancel()
+	handleInterrupts(cancel)
+	if *flagWalkCache != "" {
+		if sourceWalkCache, err = loadWalkCache(expandPath(*flagWalkCache, usr.HomeDir)); err != nil {
+			errorf("Failed to load the walk cache - %s", err)
+			os.Exit(exitFatal)
+		}
+	}
+	walkCtx, stopWalk := context.WithCancel(ctx)
+	defer stopWalk()
+	matches := make(chan string, 64)
+	walkDone := make(chan error, 1)
+	go func() {
+		if similarPaths != nil {
+			walkDone <- sendMatches(walkCtx, similarPaths, matches)
+		} else if *flagFromList != "" {
+			walkDone <- readMatchList(walkCtx, expandPath(*flagFromList, usr.HomeDir), matches)
+		} else {
+			walkDone <- findMatchingFiles(walkCtx, sourcePath, matches)
+		}
+		close(matches)
+	}()
 
 	// TODO: ask Dot if he wants to sort the matches
-	// TODO: dedupe the files
-
-	groupIdx := 1
-	fileIdx := 0
-	files := []string{}
-	// loop through all the matches and group them by 128 and copy them in their own folders.
-	for i, filePath := range matchingPaths {
-		if *flagMax > 0 && i >= *flagMax {
-			fmt.Println("We reached the max amount of samples to copy:", *flagMax)
-			break
+	// keep dual mono pairs together
+	units := pairStream(matches)
+	if *flagDedupe {
+		units = dedupeStream(units)
+	}
+	if *flagVersionPolicy != "" {
+		units = versionStream(units, *flagVersionPolicy)
+	}
+	// keep the multisample sets in the same group folder
+	units = multisampleStream(units)
+	if similarRef != nil {
+		count := *flagMax
+		if count == 0 {
+			count = defaultSimilarCount
 		}
-		// check if we filled up our group yet
-		if fileIdx >= 128 {
-			// reset our counter
-			fileIdx = 0
-			// copy the files to the group folder
-			if err := copyFilesToGroup(files, destPath, groupIdx); err != nil {
-				log.Printf("Something went wrong when copying the matching files into the group %d folder - %s\n", groupIdx, err)
+		units = similarStream(units, expandPath(*flagRef, usr.HomeDir), similarRef, count)
+	}
+	if *flagDarkFirst {
+		units = darkFirstStream(units)
+	}
+	if *flagLoudestFirst {
+		units = loudestFirstStream(units)
+	}
+	if kitSlots != nil {
+		units = kitStream(units)
+	}
+	units = capUnits(units, maxTotalSize, stopWalk)
+	if *flagList {
+		printMatchList(units)
+		if err := <-walkDone; err != nil {
+			errorf("Something went wrong looking for matching files - %s", err)
+			os.Exit(exitFatal)
+		}
+		if matchCount == 0 {
+			os.Exit(exitNoMatches)
+		}
+		return
+	}
+	srcRoot, err := filepath.Abs(sourcePath)
+	if err != nil {
+		srcRoot = sourcePath
+	}
+	licenses := newLicenseFinder(srcRoot)
+	groups := groupStream(units, srcRoot, destPath, *flagSubfolders, *flagLayout, maxGroupSize)
+	if *flagEstimate {
+		printEstimate(groups, destPath)
+		return
+	}
+	fileCount := 0
+	// outOfSpace is set when the run stopped because the destination is full
+	outOfSpace := false
+	if path, ok := spaceCheckPath(destPath); ok && *flagCheckSpaceFirst && !*flagDryRun {
+		debugf("Checking the destination has room for all the matches")
+		var err error
+		if groups, err = spaceCheckedStream(groups, path); err != nil {
+			errorf("Not enough space for the matches, nothing was copied - %s", err)
+			outOfSpace = true
+		}
+	}
+copyLoop:
+	for {
+		select {
+		case group, ok := <-groups:
+			if !ok {
+				break copyLoop
+			}
+			if ctx.Err() != nil {
+				continue
+			}
+			if path, ok := spaceCheckPath(group.folder); ok && !*flagDryRun {
+				if err := checkFreeSpace(path, group.size()); err != nil {
+					errorf("Not enough space to copy the next group, stopping the run - %s", err)
+					outOfSpace = true
+					stopWalk()
+					break copyLoop
+				}
+			}
+			copied, err := copyFilesToGroup(ctx, group.units, group.dir())
+			if err != nil {
+				errorf("Something went wrong when copying the matching files into %s - %s", group.dir(), err)
+			}
+			fileCount += copied
+			if *flagLicenses != "" && !*flagDryRun {
+				dir, inGroup := group.dir(), true
+				if *flagLicenses == "folder" {
+					dir, inGroup = filepath.Join(infoDir, licenseFolder), false
+				}
+				if err := copyLicenses(licenses, group.sources(), dir, inGroup); err != nil {
+					errorf("Failed to copy the licenses of %s - %s", group.dir(), err)
+					recordError(errCopy, group.dir(), err)
+				}
+			}
+			if *flagNoteMap != "" && !*flagDryRun {
+				if err := writeNoteMap(group.dir(), *flagNoteMap, *flagNoteMapStart); err != nil {
+					errorf("Failed to write the note map of %s - %s", group.dir(), err)
+					recordError(errCopy, group.dir(), err)
+				}
+			}
+			if *flagSamplerPatch != "" && !*flagDryRun {
+				if err := writeSamplerPatch(group.dir(), *flagSamplerPatch); err != nil {
+					errorf("Failed to write the sampler patch of %s - %s", group.dir(), err)
+					recordError(errCopy, group.dir(), err)
+				}
+			}
+			if *flagRenoise && !*flagDryRun {
+				if err := writeRenoiseInstrument(group.dir()); err != nil {
+					errorf("Failed to write the Renoise instrument of %s - %s", group.dir(), err)
+					recordError(errCopy, group.dir(), err)
+				}
+			}
+			if *flagChecksums != "" && !*flagDryRun {
+				if err := writeChecksums(group.dir(), *flagChecksums); err != nil {
+					errorf("Failed to write the checksums of %s - %s", group.dir(), err)
+					recordError(errCopy, group.dir(), err)
+				}
+			}
+			mirrorGroup(destPath, group.dir())
+			if destArchive != nil {
+				if err := destArchive.addFolder(group.dir()); err != nil {
+					errorf("Failed to add %s to the archive - %s", group.dir(), err)
+					recordError(errCopy, group.dir(), err)
+				}
+			}
+			// the hook runs once the group is complete, named in the
+			// archive once it's been moved there
+			hookDir := group.dir()
+			if destArchive != nil {
+				hookDir = filepath.Join(destArchive.path, destArchive.name(hookDir))
+			}
+			if err := runHook(*flagGroupHook, groupHookVars(hookDir, group.idx, copied)); err != nil {
+				errorf("The group hook failed for %s - %s", hookDir, err)
+				recordError(errHook, hookDir, err)
+			}
+		case <-ctx.Done():
+			// don't wait for a walk stuck on an unresponsive volume
+			break copyLoop
+		}
+	}
+	walkFinished := false
+	var walkErr error
+	select {
+	case walkErr = <-walkDone:
+		walkFinished = true
+		if walkErr != nil {
+			errorf("Something went wrong looking for matching files - %s", walkErr)
+		}
+	case <-time.After(walkStopTimeout):
+		warnf("The search for matching files didn't stop, giving up on it")
+	}
+	if walkFinished {
+		fileLog.Printf("Found %d matching files", matchCount)
+		if sourceWalkCache != nil {
+			debugf("%d folders listed from the walk cache, %d from the disk", sourceWalkCache.hits, sourceWalkCache.misses)
+			if err := sourceWalkCache.save(walkErr == nil && walkCtx.Err() == nil); err != nil {
+				warnf("Failed to save the walk cache - %s", err)
 			}
-			// increase the group id
-			groupIdx++
-			// reset the files slice so we can fill it up again
-			files = []string{}
 		}
-		// add the file to the slice
-		files = append(files, filePath)
-		// increment the file index
-		fileIdx++
 	}
-	// copy the left overs
-	if len(files) > 0 {
-		if err := copyFilesToGroup(files, destPath, groupIdx); err != nil {
-			log.Printf("Something went wrong when copying the matching files into the group %d folder - %s\n", groupIdx, err)
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		warnf("The run timed out after %s", *flagTimeout)
+	case context.Canceled:
+		warnf("The run was interrupted")
+	}
+	fileLog.Printf("%d files copied to %s", fileCount, destName)
+	// copies that timed out and are still going won't complete
+	removeInFlightFiles()
+	if *flagDOS83 && !*flagDryRun {
+		if err := writeDOSMapping(infoDir); err != nil {
+			errorf("Failed to write the 8.3 names mapping - %s", err)
+		}
+	}
+	if *flagManifest && !*flagDryRun {
+		if err := writeManifest(infoDir); err != nil {
+			errorf("Failed to write the manifest - %s", err)
+		}
+	}
+	if *flagCredits && !*flagDryRun {
+		if err := writeCredits(infoDir, licenses); err != nil {
+			errorf("Failed to write the credits - %s", err)
+		}
+	}
+	if *flagRightsReport && !*flagDryRun {
+		if err := writeRightsReport(infoDir, destPath); err != nil {
+			errorf("Failed to write the rights report - %s", err)
 		}
 	}
-	fmt.Printf("%d files copied to %s\n", len(matchingPaths), destPath)
+	if *flagCrate != "" && !*flagDryRun {
+		if err := writeCrates(infoDir, usr.HomeDir, filepath.Base(infoDir), listFlag(*flagCrate)); err != nil {
+			errorf("Failed to write the crates - %s", err)
+			recordError(errCopy, infoDir, err)
+		}
+	}
+	if *flagBagIt && !*flagDryRun {
+		if err := writeBag(infoDir); err != nil {
+			errorf("Failed to write the BagIt bag - %s", err)
+			recordError(errCopy, infoDir, err)
+		}
+	}
+	// the manifest and mapping at the root of the destination, the bag
+	// files are around the data folder
+	mirrorFolder(destPath, destPath)
+	if infoDir != destPath {
+		mirrorFolder(destPath, infoDir)
+	}
+	if destArchive != nil {
+		if err := destArchive.close(); err != nil {
+			errorf("Failed to write the archive %s - %s", destArchive.path, err)
+			recordError(errCopy, destArchive.path, err)
+		}
+	}
+	postVars := map[string]string{}
+	if walkFinished {
+		postVars["MATCHES"] = strconv.Itoa(matchCount)
+	}
+	if *flagManifest && destArchive == nil {
+		postVars["MANIFEST"] = filepath.Join(infoDir, manifestFilename)
+	}
+	if err := runHook(*flagPostHook, postVars); err != nil {
+		errorf("The post hook failed - %s", err)
+		recordError(errHook, "post hook", err)
+	}
+	exitCode := exitOK
+	switch {
+	case walkErr != nil:
+		exitCode = exitFatal
+	case ctx.Err() == context.Canceled:
+		exitCode = exitInterrupted
+	case ctx.Err() != nil || outOfSpace || !walkFinished || errorCount(errCopy, errHook, errMirror) > 0:
+		exitCode = exitPartialFailure
+	case matchCount == 0:
+		exitCode = exitNoMatches
+	}
+	if *flagMetricsFile != "" {
+		if err := writeMetricsFile(expandPath(*flagMetricsFile, usr.HomeDir)); err != nil {
+			errorf("Failed to write the metrics - %s", err)
+		}
+	}
+	summary := &runSummary{
+		Source:      sourcePath,
+		Destination: destName,
+		Keyword:     *flagKeyword,
+		Matches:     matchCount,
+		Copied:      fileCount,
+		Errors:      errorCount(errUnreadable, errCorrupt, errCopy, errMerge, errHook, errMirror),
+		ExitCode:    exitCode,
+		Duration:    time.Since(currentRun.Started).Seconds(),
+		Manifest:    postVars["MANIFEST"],
+		Mirrors:     mirrorSummaries(),
+	}
+	if !walkFinished {
+		summary.Matches = fileCount
+	}
+	printRunSummary(summary)
+	printErrorSummary()
+	if *flagNotify {
+		if err := notifyDesktop(summary); err != nil {
+			errorf("Failed to show the desktop notification - %s", err)
+		}
+	}
+	if *flagWebhook != "" {
+		if err := postJSON(*flagWebhook, summary); err != nil {
+			errorf("Failed to call the webhook - %s", err)
+		}
+	}
+	if *flagChatWebhook != "" {
+		if err := postJSON(*flagChatWebhook, chatMessage(*flagChatWebhook, summary)); err != nil {
+			errorf("Failed to post the summary to the chat webhook - %s", err)
+		}
+	}
+	fileLog.Printf("Run finished with exit code %d", exitCode)
+	os.Exit(exitCode)
 }
 
-func findMatchingFiles(src, keyword string) (matchPaths []string, err error) {
+// walkStopTimeout is how long to wait for the walk to stop once the run is
+// cancelled, a walk stuck on an unresponsive volume can't be stopped.
+const walkStopTimeout = 5 * time.Second
+
+// findMatchingFiles walks src and sends the matching files to matches until
+// the walk is over or ctx is cancelled.
+func findMatchingFiles(ctx context.Context, src string, matches chan<- string) error {
 	if src == "" {
-		return nil, fmt.Errorf("missing source folder location")
+		return fmt.Errorf("missing source folder location")
 	}
 
 	fullPath, err := filepath.Abs(src)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't get the absolute path of the source - %s", err)
+		return fmt.Errorf("couldn't get the absolute path of the source - %s", err)
+	}
+	if _, err := os.Stat(fullPath); err != nil {
+		return fmt.Errorf("couldn't access the source folder - %s", err)
 	}
 
-	err = filepath.Walk(fullPath, visit)
-	return matchingPaths, err
+	walk := filepath.Walk
+	if sourceWalkCache != nil {
+		walk = sourceWalkCache.walk
+	}
+	return walk(fullPath, func(path string, fi os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+		if err == nil && fi.IsDir() && path != fullPath && !*flagProjectAudio && isProjectFolder(path) {
+			debugf("Skipping the DAW project folder %s", path)
+			return filepath.SkipDir
+		}
+		if !visit(path, fi, err) {
+			return nil
+		}
+		matchCount++
+		runMetrics.matched.Add(1)
+		select {
+		case matches <- path:
+			return nil
+		case <-ctx.Done():
+			return filepath.SkipAll
+		}
+	})
 }
 
-// find matching files
-func visit(path string, fi os.FileInfo, err error) (e error) {
+// visit reports if path is a match.
+func visit(path string, fi os.FileInfo, err error) bool {
+	if err != nil {
+		warnf("Skipping %s - %s", path, err)
+		recordError(errUnreadable, path, err)
+		return false
+	}
 	if fi.IsDir() {
-		return nil
+		return false
+	}
+	runMetrics.scanned.Add(1)
+	if *flagFatSafe && fi.Size() > maxFATFileSize {
+		warnf("Skipping %s, FAT file systems can't store files over 4GB", path)
+		return false
 	}
 
-	// test match, if we match, let's add to the matchingPaths
+	// test match
 	filename := strings.ToLower(filepath.Base(path))
 	ext := filepath.Ext(filename)
 	if ext != ".wav" && ext != ".aiff" && ext != ".aif" {
-		return nil
+		return false
 	}
-	if strings.Contains(filename, *flagKeyword) {
-		if *flagDebug {
-			fmt.Println("match found:", path)
+	if _, ok := matchedKeyword(filename); ok {
+		if !matchesFilters(path, fi) {
+			return false
 		}
-		matchingPaths = append(matchingPaths, path)
+		if ignored(path) {
+			debugf("Ignoring %s", path)
+			return false
+		}
+		debugf("match found: %s", highlight(path))
+		if *flagSkipCorrupt {
+			if err := checkAudioFile(path); err != nil {
+				recordError(errCorrupt, path, err)
+				return false
+			}
+		}
+		return true
 	}
 
-	return nil
+	return false
+}
+
+// matchedKeyword returns the first keyword found in the lowercase filename.
+func matchedKeyword(filename string) (string, bool) {
+	if len(keywords) == 0 {
+		return "", true
+	}
+	for _, keyword := range keywords {
+		if strings.Contains(filename, keyword) {
+			return keyword, true
+		}
+	}
+	return "", false
 }
 
-// copyFilesToGroup copies the srcPaths to destPath inside a subfolder named after the idx
-func copyFilesToGroup(srcPaths []string, destPath string, idx int) error {
-	subFolderPath := filepath.Join(destPath, fmt.Sprintf("group_%d", idx))
+// copyFilesToGroup copies the files of the units to subFolderPath, the folder of a group,
+// and returns the number of files copied, which is short of the group size when copies fail or the run is interrupted.
+func copyFilesToGroup(ctx context.Context, units [][]string, subFolderPath string) (int, error) {
 	os.MkdirAll(subFolderPath, 0777)
-	fmt.Printf("Copying %d files to %s\n", len(srcPaths), subFolderPath)
-	// TODO: make sure we don't have 2 files with the same filename
-	for _, src := range srcPaths {
-		filename := filepath.Base(src)
-		dest := filepath.Join(subFolderPath, filename)
-		if *flagDebug {
-			fmt.Printf("Copying %s to %s\n", src, dest)
+	fileCount := 0
+	for _, unit := range units {
+		fileCount += len(unit)
+	}
+	shownPath := subFolderPath
+	if destArchive != nil {
+		shownPath = filepath.Join(destArchive.path, destArchive.name(subFolderPath))
+	}
+	infof("Copying %d files to %s", fileCount, highlight(shownPath))
+	usedNames := existingNames(subFolderPath)
+	// layerNames are the names used in the instrument folders of -nestLayers
+	layerNames := map[string]map[string]bool{}
+	copied := 0
+	for _, unit := range units {
+		if ctx.Err() != nil {
+			break
+		}
+		if *flagMergePairs && len(unit) == 2 {
+			if merged, ok := mergedPairName(unit[0]); ok {
+				dest := filepath.Join(subFolderPath, uniqueDestName(subFolderPath, destFilename(merged), usedNames))
+				err := withFileTimeout(func() error { return mergePair(unit[0], unit[1], dest) })
+				if err == nil {
+					fileLog.Printf("Merged %s and %s to %s", unit[0], unit[1], dest)
+					copied += len(unit)
+					runMetrics.copied.Add(int64(len(unit)))
+					continue
+				}
+				errorf("Failed to merge %s and %s, copying them separately - %s", unit[0], unit[1], err)
+				recordError(errMerge, unit[0], err)
+			}
+		}
+		dir, dirNames := subFolderPath, usedNames
+		if instrument, ok := layerInstrument(unit); ok && *flagNestLayers {
+			dir = filepath.Join(subFolderPath, destFolderName(instrument))
+			os.MkdirAll(dir, 0777)
+			if layerNames[dir] == nil {
+				layerNames[dir] = existingNames(dir)
+			}
+			dirNames = layerNames[dir]
+		}
+		// written are the copies of the files of the unit, for -bitwig
+		written := make([]string, len(unit))
+		for i, src := range unit {
+			name := destFilename(src)
+			if flacEncodable(src) {
+				name = flacName(name)
+			}
+			filename := uniqueDestName(dir, name, dirNames)
+			dest := filepath.Join(dir, filename)
+			if original, ok := duplicateOf(src); ok {
+				// link to the copy of the original when it's already in the destination
+				if target, ok := copiedFiles[original]; ok {
+					err := linkDuplicate(target, dest)
+					if err == nil {
+						recordFile(manifestEntry{Source: src, Destination: dest, Link: target})
+						fileLog.Printf("Linked %s to %s", dest, target)
+						written[i] = dest
+						copied++
+						continue
+					}
+					warnf("Failed to link %s to %s, copying it - %s", dest, target, err)
+				}
+			}
+			debugf("Copying %s to %s", src, dest)
+			err := withRetries(ctx, func() error {
+				return withFileTimeout(func() error { return copyFileContents(src, dest, dirNames) })
+			})
+			if err != nil {
+				errorf("Failed to copy %s to %s, continuing anyway - %s", src, dest, err)
+				recordFailure(src, dest, err)
+				recordError(errCopy, src, err)
+				continue
+			}
+			fileLog.Printf("Copied %s to %s", src, dest)
+			copiedFiles[src] = dest
+			written[i] = dest
+			if *flagCompanions {
+				if err := copyCompanions(src, dest); err != nil {
+					errorf("Failed to copy the companion files of %s - %s", src, err)
+					recordError(errCopy, src, err)
+				}
+			}
+			copied++
+			runMetrics.copied.Add(1)
+			if err := runHook(*flagFileHook, map[string]string{"FILE_SRC": src, "FILE_DEST": dest}); err != nil {
+				errorf("The file hook failed for %s - %s", src, err)
+				recordError(errHook, src, err)
+			}
 		}
-		if err := copyFileContents(src, dest); err != nil {
-			log.Printf("Failed to copy %s to %s, continuing anyway - %s", src, dest, err)
-			continue
+		if *flagBitwig && !*flagDryRun {
+			if err := writeBitwigMultisample(dir, unit, written, dirNames); err != nil {
+				errorf("Failed to write the Bitwig multisample of %s - %s", unit[0], err)
+				recordError(errCopy, unit[0], err)
+			}
 		}
 	}
-	return nil
+	return copied, nil
 }
 
-func copyFileContents(src, dst string) (err error) {
+// copyFileContents copies src to dst, processing it on the way. The outputs of
+// the audio processors named after dst are reserved in the used names of its
+// folder.
+func copyFileContents(src, dst string, used map[string]bool) (err error) {
 	if *flagDryRun {
-		log.Printf("Copying %s to %s\n", src, dst)
+		infof("Copying %s to %s", src, dst)
 		return nil
 	}
+	transforms := enabledTransforms()
+	if *flagRepair || len(transforms) > 0 {
+		if info, err := readAudioInfo(src); err == nil && info.supportedCodec() {
+			switch {
+			case transformsApply(transforms, src, info):
+				return transformAudioFile(src, dst, used, transforms)
+			case *flagRepair && info.needsRepair():
+				infof("Repairing %s", src)
+				if err := repairAudioFile(info, src, dst); err != nil {
+					return err
+				}
+				recordFile(manifestEntry{Source: src, Destination: dst})
+				return nil
+			}
+		}
+	}
+	if flacEncodable(src) {
+		if err = encodeFlacFile(src, dst); err == nil {
+			recordFile(manifestEntry{Source: src, Destination: dst})
+		}
+		return
+	}
+	if destArchive != nil {
+		if err = destArchive.copyFile(src, dst); err == nil {
+			recordFile(manifestEntry{Source: src, Destination: dst})
+		}
+		return
+	}
+	if destEncryption != nil {
+		if err = encryptFile(src, dst); err == nil {
+			recordFile(manifestEntry{Source: src, Destination: dst})
+		}
+		return
+	}
 	in, err := os.Open(src)
 	if err != nil {
 		return
 	}
 	defer in.Close()
-	out, err := os.Create(dst)
+	out, err := createDestFile(dst)
 	if err != nil {
 		return
 	}
 	defer func() {
-		cerr := out.Close()
+		cerr := closeDestFile(out)
 		if err == nil {
 			err = cerr
 		}
@@ -191,6 +1203,8 @@ func copyFileContents(src, dst string) (err error) {
 	if _, err = io.Copy(out, in); err != nil {
 		return
 	}
-	err = out.Sync()
+	if err = out.Sync(); err == nil {
+		recordFile(manifestEntry{Source: src, Destination: dst})
+	}
 	return
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// manifestEntry describes a file written to the destination.
+type manifestEntry struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// RootNote is the root note of the source when it was repitched
+	RootNote string `json:"rootNote,omitempty"`
+	// PitchShift is the shift in semitones applied to the source
+	PitchShift float64 `json:"pitchShift,omitempty"`
+	// Link is the file the destination links to when the source is a duplicate
+	Link string `json:"link,omitempty"`
+	// Levels are the levels of the source, when measured
+	Levels *audioLevels `json:"levels,omitempty"`
+	// Features are the means then standard deviations of the 13 MFCCs of the
+	// source, with -features
+	Features []float64 `json:"features,omitempty"`
+}
+
+// runManifest describes what a run did, it is written to the destination when
+// the manifest is enabled.
+type runManifest struct {
+	Source      string          `json:"source"`
+	Destination string          `json:"destination"`
+	Keyword     string          `json:"keyword"`
+	Started     time.Time       `json:"started"`
+	Finished    time.Time       `json:"finished"`
+	Files       []manifestEntry `json:"files"`
+	// Failed lists the files that couldn't be copied
+	Failed []failedEntry `json:"failed,omitempty"`
+	// Encryption is the key the files were encrypted to
+	Encryption *encryption `json:"encryption,omitempty"`
+}
+
+// failedEntry describes a file that couldn't be copied to the destination.
+type failedEntry struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Error       string `json:"error"`
+}
+
+// manifestFilename is the name of the manifest written at the root of the destination.
+const manifestFilename = "manifest.json"
+
+var currentRun = &runManifest{Started: time.Now()}
+
+// currentRunMu guards the files of the current run, which can be recorded by
+// copies that timed out and kept running in the background.
+var currentRunMu sync.Mutex
+
+// recordFile adds a file written to the destination to the run manifest.
+func recordFile(entry manifestEntry) {
+	entry.Destination, entry.Link = encryptedPath(entry.Destination), encryptedPath(entry.Link)
+	if levelsEnabled() && entry.Levels == nil {
+		if levels, err := measureLevels(entry.Source); err == nil {
+			entry.Levels = &levels
+		}
+	}
+	if *flagFeatures && *flagManifest && entry.Features == nil {
+		features, err := mfccStats(entry.Source)
+		if err != nil {
+			debugf("Couldn't compute the features of %s - %s", entry.Source, err)
+		}
+		entry.Features = features
+	}
+	currentRunMu.Lock()
+	defer currentRunMu.Unlock()
+	currentRun.Files = append(currentRun.Files, entry)
+}
+
+// recordFailure adds a file that couldn't be copied to the run manifest.
+func recordFailure(src, dst string, err error) {
+	currentRunMu.Lock()
+	defer currentRunMu.Unlock()
+	currentRun.Failed = append(currentRun.Failed, failedEntry{Source: src, Destination: dst, Error: err.Error()})
+}
+
+// recordedFiles returns the files recorded so far.
+func recordedFiles() []manifestEntry {
+	currentRunMu.Lock()
+	defer currentRunMu.Unlock()
+	return append([]manifestEntry(nil), currentRun.Files...)
+}
+
+// recordedFailures returns the failed files recorded so far.
+func recordedFailures() []failedEntry {
+	currentRunMu.Lock()
+	defer currentRunMu.Unlock()
+	return append([]failedEntry(nil), currentRun.Failed...)
+}
+
+// writeManifest writes the run manifest to the destination folder.
+func writeManifest(destPath string) error {
+	currentRunMu.Lock()
+	defer currentRunMu.Unlock()
+	currentRun.Finished = time.Now()
+	run := *currentRun
+	if destArchive != nil {
+		run = destArchive.manifest(run)
+	}
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(destPath, 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(destPath, manifestFilename), data, 0666)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// runMetrics are the counters of the run exposed in the Prometheus text format.
+var runMetrics struct {
+	scanned atomic.Int64
+	matched atomic.Int64
+	copied  atomic.Int64
+	written atomic.Int64
+}
+
+// errorLabels are the values of the category label of the error counter.
+var errorLabels = map[errorCategory]string{
+	errUnreadable: "unreadable",
+	errCorrupt:    "corrupt",
+	errCopy:       "copy",
+	errMerge:      "merge",
+	errHook:       "hook",
+	errMirror:     "mirror",
+	errDuplicate:  "duplicate",
+	errVersion:    "version",
+	errClipped:    "clipped",
+	errSilent:     "silent",
+}
+
+// writeMetrics writes the run metrics in the Prometheus text exposition format.
+func writeMetrics(w io.Writer) {
+	counters := []struct {
+		name, help string
+		value      int64
+	}{
+		{"samplesorter_files_scanned_total", "Files seen while searching the source.", runMetrics.scanned.Load()},
+		{"samplesorter_files_matched_total", "Files matching the keyword and filters.", runMetrics.matched.Load()},
+		{"samplesorter_files_copied_total", "Files copied to the destination.", runMetrics.copied.Load()},
+		{"samplesorter_bytes_written_total", "Bytes written to the destination.", runMetrics.written.Load()},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.value)
+	}
+	fmt.Fprint(w, "# HELP samplesorter_errors_total Per file errors by category.\n# TYPE samplesorter_errors_total counter\n")
+	for _, category := range errorCategories {
+		fmt.Fprintf(w, "samplesorter_errors_total{category=%q} %d\n", errorLabels[category], errorCount(category))
+	}
+	fmt.Fprintf(w, "# HELP samplesorter_run_start_timestamp_seconds Start time of the run.\n# TYPE samplesorter_run_start_timestamp_seconds gauge\nsamplesorter_run_start_timestamp_seconds %d\n",
+		currentRun.Started.Unix())
+	fmt.Fprintf(w, "# HELP samplesorter_run_duration_seconds Duration of the run so far.\n# TYPE samplesorter_run_duration_seconds gauge\nsamplesorter_run_duration_seconds %.3f\n",
+		time.Since(currentRun.Started).Seconds())
+}
+
+// serveMetrics serves the run metrics at /metrics on addr while the run goes on.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			errorf("Failed to serve the metrics - %s", err)
+		}
+	}()
+}
+
+// writeMetricsFile writes the run metrics to path, for the textfile collector
+// of the node exporter. The file is replaced atomically so it's never scraped
+// half written.
+func writeMetricsFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".samplesorter-metrics")
+	if err != nil {
+		return err
+	}
+	writeMetrics(tmp)
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// the node exporter needs to be able to read the file
+	os.Chmod(tmp.Name(), 0644)
+	return os.Rename(tmp.Name(), path)
+}
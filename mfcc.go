@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+const (
+	// melFilters is the number of triangular filters of the mel filterbank
+	melFilters = 26
+	// mfccCoefficients is the number of cepstral coefficients kept per window
+	mfccCoefficients = 13
+	// mfccAnalysis is the length in seconds of audio analyzed for the features,
+	// long recordings are only described by their beginning
+	mfccAnalysis = 30.0
+)
+
+// hzToMel and melToHz convert between frequencies and the mel scale.
+func hzToMel(hz float64) float64  { return 2595 * math.Log10(1+hz/700) }
+func melToHz(mel float64) float64 { return 700 * (math.Pow(10, mel/2595) - 1) }
+
+// melFilterbank returns the weights of the FFT bins of a window of size bins
+// for each of the filters, spread evenly on the mel scale up to 16kHz.
+func melFilterbank(sampleRate, bins int) [][]float64 {
+	low, high := hzToMel(20), hzToMel(math.Min(16000, float64(sampleRate)/2))
+	centers := make([]float64, melFilters+2)
+	for i := range centers {
+		centers[i] = melToHz(low+(high-low)*float64(i)/float64(melFilters+1)) * float64(bins) / float64(sampleRate)
+	}
+	filters := make([][]float64, melFilters)
+	for f := range filters {
+		filters[f] = make([]float64, bins/2)
+		for k := range filters[f] {
+			bin := float64(k)
+			switch {
+			case bin > centers[f] && bin <= centers[f+1]:
+				filters[f][k] = (bin - centers[f]) / (centers[f+1] - centers[f])
+			case bin > centers[f+1] && bin < centers[f+2]:
+				filters[f][k] = (centers[f+2] - bin) / (centers[f+2] - centers[f+1])
+			}
+		}
+	}
+	return filters
+}
+
+// mfccStats returns the mean and the standard deviation of each of the first
+// 13 mel frequency cepstral coefficients over the windows of the WAV or AIFF
+// file at path, a 26 values vector describing its timbre for external tools.
+func mfccStats(path string) ([]float64, error) {
+	buf, _, err := decodeAudioFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if buf.frames() == 0 || buf.sampleRate == 0 {
+		return nil, fmt.Errorf("no audio")
+	}
+	samples := buf.mixdown()
+	if limit := int(mfccAnalysis * float64(buf.sampleRate)); len(samples) > limit {
+		samples = samples[:limit]
+	}
+	hann := hannWindow(spectralWindow)
+	filters := melFilterbank(buf.sampleRate, spectralWindow)
+	bins := make([]complex128, spectralWindow)
+	energies := make([]float64, melFilters)
+	sum := make([]float64, mfccCoefficients)
+	sumSquares := make([]float64, mfccCoefficients)
+	windows := 0
+	for start := 0; start == 0 || start+spectralWindow <= len(samples); start += spectralWindow / 2 {
+		for i := range bins {
+			v := 0.0
+			if start+i < len(samples) {
+				v = samples[start+i] * hann[i]
+			}
+			bins[i] = complex(v, 0)
+		}
+		fft(bins)
+		for f, weights := range filters {
+			var energy float64
+			for k, w := range weights {
+				if w > 0 {
+					m := cmplx.Abs(bins[k])
+					energy += w * m * m
+				}
+			}
+			energies[f] = math.Log(energy + 1e-10)
+		}
+		// DCT-II of the log energies
+		for c := 0; c < mfccCoefficients; c++ {
+			var v float64
+			for f, e := range energies {
+				v += e * math.Cos(math.Pi*float64(c)*(float64(f)+0.5)/melFilters)
+			}
+			sum[c] += v
+			sumSquares[c] += v * v
+		}
+		windows++
+	}
+	stats := make([]float64, 0, mfccCoefficients*2)
+	for c := range sum {
+		stats = append(stats, roundFeature(sum[c]/float64(windows)))
+	}
+	for c := range sum {
+		mean := sum[c] / float64(windows)
+		stats = append(stats, roundFeature(math.Sqrt(math.Max(sumSquares[c]/float64(windows)-mean*mean, 0))))
+	}
+	return stats, nil
+}
+
+// roundFeature rounds a feature value to 4 decimals to keep the manifest small.
+func roundFeature(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// mirror is an extra destination receiving a copy of everything written to
+// the main destination.
+type mirror struct {
+	// root is the folder of the mirror matching the main destination
+	root   string
+	copied int
+	failed int
+}
+
+// mirrorSummary describes what was written to a mirror for the notifications.
+type mirrorSummary struct {
+	Destination string `json:"destination"`
+	Copied      int    `json:"copied"`
+	Failed      int    `json:"failed"`
+}
+
+// mirrors are the -mirror destinations.
+var mirrors []*mirror
+
+// setupMirrors sets the mirrors up, destRoot is the -dest folder and destPath
+// the folder the files are copied to in it.
+func setupMirrors(list, destRoot, destPath, home string) {
+	rel, err := filepath.Rel(destRoot, destPath)
+	if err != nil {
+		rel = "."
+	}
+	for _, path := range listFlag(list) {
+		mirrors = append(mirrors, &mirror{root: filepath.Join(expandPath(path, home), rel)})
+	}
+}
+
+// mirrorFolder replicates the files of dir, a folder of the main destination
+// rooted at destPath, to the mirrors. The files already in a mirror with the
+// same size are skipped so the group folders topped up by a run are only
+// completed. Each copy is verified by comparing its digest with the original.
+func mirrorFolder(destPath, dir string) {
+	if len(mirrors) == 0 || *flagDryRun {
+		return
+	}
+	rel, err := filepath.Rel(destPath, dir)
+	if err != nil {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		errorf("Failed to mirror %s - %s", dir, err)
+		return
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files = append(files, entry.Name())
+		}
+	}
+	for _, m := range mirrors {
+		mirrorDir := filepath.Join(m.root, rel)
+		if err := os.MkdirAll(mirrorDir, 0777); err != nil {
+			errorf("Failed to create the mirror folder %s - %s", mirrorDir, err)
+			m.failed += len(files)
+			recordError(errMirror, mirrorDir, err)
+			continue
+		}
+		for _, name := range files {
+			src, dst := filepath.Join(dir, name), filepath.Join(mirrorDir, name)
+			if done, err := sameSize(src, dst); err == nil && done {
+				continue
+			}
+			if err := mirrorFile(src, dst); err != nil {
+				errorf("Failed to mirror %s to %s - %s", src, dst, err)
+				recordError(errMirror, dst, err)
+				m.failed++
+				continue
+			}
+			m.copied++
+		}
+	}
+}
+
+// mirrorGroup replicates the group folder dir to the mirrors like mirrorFolder,
+// with its subfolders like the instrument folders of -nestLayers.
+func mirrorGroup(destPath, dir string) {
+	if len(mirrors) == 0 || *flagDryRun {
+		return
+	}
+	filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			errorf("Failed to mirror %s - %s", path, err)
+			recordError(errMirror, path, err)
+			return nil
+		}
+		if entry.IsDir() {
+			mirrorFolder(destPath, path)
+		}
+		return nil
+	})
+}
+
+// sameSize reports if the file at dst exists and has the size of src.
+func sameSize(src, dst string) (bool, error) {
+	si, err := os.Stat(src)
+	if err != nil {
+		return false, err
+	}
+	di, err := os.Lstat(dst)
+	if err != nil {
+		return false, err
+	}
+	return si.Size() == di.Size() && si.Mode().Type() == di.Mode().Type(), nil
+}
+
+// mirrorFile copies src to dst and checks the copy, the links to duplicates
+// are recreated as links.
+func mirrorFile(src, dst string) error {
+	if fi, err := os.Lstat(src); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		os.Remove(dst)
+		return os.Symlink(target, dst)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	want, _, err := hashFileMode(src, "full")
+	if err != nil {
+		return err
+	}
+	got, _, err := hashFileMode(dst, "full")
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("the copy doesn't match the original")
+	}
+	return nil
+}
+
+// mirrorSummaries returns the summaries of the mirrors.
+func mirrorSummaries() []mirrorSummary {
+	var summaries []mirrorSummary
+	for _, m := range mirrors {
+		summaries = append(summaries, mirrorSummary{Destination: m.root, Copied: m.copied, Failed: m.failed})
+	}
+	return summaries
+}
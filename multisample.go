@@ -0,0 +1,181 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// velocityPattern matches the velocity layer names like v1, vel064, soft,
+// med or hard surrounded by separators.
+var velocityPattern = regexp.MustCompile(`(?i)(?:^|[\s_\-.(\[])(v[0-9]{1,3}|vel[0-9]{1,3}|soft|med|medium|hard)(?:$|[\s_\-.)\]])`)
+
+// velocityLevels are the velocities of the named layers, to sort them.
+var velocityLevels = map[string]int{"soft": 32, "med": 80, "medium": 80, "hard": 112}
+
+// roundRobinPattern matches the round robin variation names at the end of a
+// filename stem, like rr2 or _b. The variation letters are lowercase to not
+// take a note name like Bass_A for a variation, and follow a separator to not
+// take the s of 808s for one.
+var roundRobinPattern = regexp.MustCompile(`(?:(?i:[\s_\-.]?rr[\s_\-]?([0-9]{1,2}))|[\s_\-]([a-z]))$`)
+
+// roundRobinName splits the filename stem of a round robin variation into the
+// name shared by its variations and its variation number, from 1: for
+// Snare_01_b, Snare_01 and 2.
+func roundRobinName(stem string) (name string, variation int, ok bool) {
+	m := roundRobinPattern.FindStringSubmatchIndex(stem)
+	if m == nil {
+		return stem, 0, false
+	}
+	switch {
+	case m[2] >= 0:
+		variation, _ = strconv.Atoi(stem[m[2]:m[3]])
+		return stem[:m[0]], variation, true
+	}
+	return stem[:m[4]], int(stem[m[4]]-'a') + 1, true
+}
+
+// sampleSetName splits the filename stem of a multisample set, velocity
+// layer or round robin variation file into the name shared by the set, its
+// note, its velocity and its variation: for Piano_C3_v2, Piano__, 60, 2 and 0.
+// layer is set when the stem has a velocity, ok when it has a note, a
+// velocity or a variation.
+func sampleSetName(stem string) (name string, note, velocity, variation int, layer, ok bool) {
+	name, variation, ok = roundRobinName(stem)
+	if m := velocityPattern.FindStringSubmatchIndex(name); m != nil {
+		token := strings.ToLower(name[m[2]:m[3]])
+		if level, ok := velocityLevels[token]; ok {
+			velocity = level
+		} else {
+			velocity, _ = strconv.Atoi(strings.TrimLeft(token, "vel"))
+		}
+		name = name[:m[2]] + name[m[3]:]
+		layer, ok = true, true
+	}
+	if m := filenameNotePattern.FindStringSubmatchIndex(name); m != nil {
+		if n, found := parseNote(name[m[2]:m[3]]); found {
+			note = n
+			name = name[:m[2]] + name[m[3]:]
+			ok = true
+		}
+	}
+	return name, note, velocity, variation, layer, ok
+}
+
+// multisampleKey returns the key shared by the files of the multisample set,
+// velocity layers or round robin variations path would be part of: its folder
+// and its filename without the note, velocity and variation names, e.g.
+// Piano_.wav for Piano_C3.wav and Piano_E3.wav, Snare_.wav for Snare_soft.wav
+// and Snare_hard.wav or snare_01.wav for snare_01_a.wav and snare_01_b.wav.
+func multisampleKey(path string) (key string, note, velocity, variation int, ok bool) {
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	stem, note, velocity, variation, _, ok := sampleSetName(strings.TrimSuffix(name, ext))
+	if !ok {
+		return "", 0, 0, 0, false
+	}
+	return filepath.Join(filepath.Dir(path), strings.ToLower(stem+ext)), note, velocity, variation, true
+}
+
+// layerInstrument returns the name of the instrument the velocity layers of
+// the unit are recorded from, the filename of its first file without the
+// note and velocity names. ok is false when the unit isn't a set of velocity
+// layers.
+func layerInstrument(unit []string) (string, bool) {
+	if len(unit) < 2 {
+		return "", false
+	}
+	stem := strings.TrimSuffix(filepath.Base(unit[0]), filepath.Ext(unit[0]))
+	name, _, _, _, layer, _ := sampleSetName(stem)
+	name = cleanSetName(name)
+	if !layer || name == "" {
+		return "", false
+	}
+	return name, true
+}
+
+// separatorRuns matches the separators left next to each other once the note
+// and velocity names are removed.
+var separatorRuns = regexp.MustCompile(`[\s_\-.]{2,}`)
+
+// cleanSetName tidies the name shared by the files of a set returned by
+// sampleSetName, Piano for Piano__.
+func cleanSetName(name string) string {
+	return strings.Trim(separatorRuns.ReplaceAllStringFunc(name, func(s string) string { return s[:1] }), " _-.")
+}
+
+// multisampleStream keeps the multisample sets, velocity layers and round
+// robin variations found among the single file units together: the files of a
+// folder with the same name but for a note, velocity or variation name, like
+// Piano_C3_v1.wav, Piano_C3_v2.wav and Piano_E3_v1.wav, are sent as a single
+// unit sorted by note, velocity and variation so groupStream doesn't split
+// them across group folders and the kits get all the variations of a hit.
+// The files with a note, velocity or variation name are held until the walk leaves their
+// folder, the ones without a set are then sent on their own. The dual mono
+// pairs are left alone so they can still be merged.
+func multisampleStream(in <-chan []string) <-chan []string {
+	out := make(chan []string)
+	go func() {
+		defer close(out)
+		type member struct {
+			path                      string
+			note, velocity, variation int
+		}
+		sets := map[string][]member{}
+		order := []string{}
+		flush := func(current string) {
+			kept := order[:0]
+			for _, key := range order {
+				dir := filepath.Dir(key)
+				if current != "" && (filepath.Dir(current) == dir || strings.HasPrefix(current, dir+string(filepath.Separator))) {
+					kept = append(kept, key)
+					continue
+				}
+				members := sets[key]
+				delete(sets, key)
+				sort.SliceStable(members, func(i, j int) bool {
+					if members[i].note != members[j].note {
+						return members[i].note < members[j].note
+					}
+					if members[i].velocity != members[j].velocity {
+						return members[i].velocity < members[j].velocity
+					}
+					return members[i].variation < members[j].variation
+				})
+				unit := make([]string, len(members))
+				for i, m := range members {
+					unit[i] = m.path
+				}
+				if len(unit) > 1 {
+					debugf("Keeping the set of %d files %s together", len(unit), unit[0])
+				}
+				out <- unit
+			}
+			order = kept
+		}
+		for unit := range in {
+			flush(unit[0])
+			if len(unit) != 1 {
+				out <- unit
+				continue
+			}
+			if _, _, pair := pairPartner(unit[0]); pair {
+				out <- unit
+				continue
+			}
+			key, note, velocity, variation, ok := multisampleKey(unit[0])
+			if !ok {
+				out <- unit
+				continue
+			}
+			if _, ok := sets[key]; !ok {
+				order = append(order, key)
+			}
+			sets[key] = append(sets[key], member{path: unit[0], note: note, velocity: velocity, variation: variation})
+		}
+		flush("")
+	}()
+	return out
+}
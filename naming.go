@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+// maxFATFileSize is the biggest file a FAT32 file system can store.
+const maxFATFileSize = 1<<32 - 1
+
+// fatReservedNames are device names that can't be used as file or folder names
+// on FAT file systems, whatever the extension.
+var fatReservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// parentSeparator separates the parent folder name from the filename with -parentPrefix.
+const parentSeparator = "__"
+
+// destFilename returns the name the src file should have once copied to the destination.
+func destFilename(src string) string {
+	filename := filepath.Base(src)
+	ext := filepath.Ext(filename)
+	stem := strings.TrimSuffix(filename, ext)
+	name := *flagPrefix + stem + *flagSuffix
+	if *flagSlug {
+		name, ext = slugify(name), strings.ToLower(ext)
+	}
+	if *flagParentPrefix {
+		// keep the pack the file comes from once the groups are flattened,
+		// the parts are slugified separately to keep the separator
+		if parent := filepath.Base(filepath.Dir(src)); parent != "." && parent != string(filepath.Separator) {
+			head, tail := *flagPrefix+parent, stem+*flagSuffix
+			if *flagSlug {
+				head, tail = slugify(head), slugify(tail)
+			}
+			name = head + parentSeparator + tail
+		}
+	}
+	if *flagFatSafe {
+		if safe := fatSafeName(name + ext); safe != name+ext {
+			infof("Renaming %s to %s to be FAT compatible", name+ext, safe)
+			return safe
+		}
+	}
+	return name + ext
+}
+
+// uniqueFilename returns filename, or a numbered variant of it if it was already used,
+// and marks the returned name as used. Names are compared case insensitively since
+// most destination file systems are.
+func uniqueFilename(filename string, used map[string]bool) string {
+	ext := filepath.Ext(filename)
+	name := strings.TrimSuffix(filename, ext)
+	for i := 2; used[strings.ToLower(filename)]; i++ {
+		filename = fmt.Sprintf("%s_%d%s", name, i, ext)
+	}
+	used[strings.ToLower(filename)] = true
+	return filename
+}
+
+// existingNames returns the names of the files and folders already in dir, in
+// the used names format of uniqueFilename, so a group folder topped up by a
+// later run doesn't overwrite them.
+func existingNames(dir string) map[string]bool {
+	used := map[string]bool{}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		used[strings.ToLower(entry.Name())] = true
+	}
+	return used
+}
+
+// usedNamesMu guards the used names of the destination folders.
+var usedNamesMu sync.Mutex
+
+// uniqueDestName returns a unique destination name for filename among the used
+// names, in 8.3 format if enabled. Since the outputs of the audio processors
+// get their own short names, 8.3 names also need to be free in dir.
+func uniqueDestName(dir, filename string, used map[string]bool) string {
+	// a copy that timed out can still be naming its outputs
+	usedNamesMu.Lock()
+	defer usedNamesMu.Unlock()
+	if *flagDOS83 {
+		return uniqueDOSName(dir, filename, used)
+	}
+	return uniqueFilename(filename, used)
+}
+
+// transliterations maps common accented characters to their closest ASCII form.
+var transliterations = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae",
+	'ç': "c", 'è': "e", 'é': "e", 'ê': "e", 'ë': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ñ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'œ': "oe",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ý': "y", 'ÿ': "y", 'ß': "ss",
+}
+
+// slugify converts name to lowercase ASCII where anything that isn't a letter or
+// a digit is replaced by a single underscore.
+func slugify(name string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(name) {
+		if t, ok := transliterations[r]; ok {
+			b.WriteString(t)
+			underscore = false
+			continue
+		}
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			underscore = false
+			continue
+		}
+		if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "_")
+	if slug == "" {
+		slug = "sample"
+	}
+	return slug
+}
+
+// fatSafeName adjusts a file or folder name so it can be stored on FAT32 and
+// exFAT file systems and is visible to hardware samplers: forbidden and control
+// characters are replaced, leading dots and trailing dots and spaces removed,
+// reserved device names prefixed and long names truncated to 255 UTF-16 units.
+func fatSafeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	ext := filepath.Ext(name)
+	stem := strings.TrimLeft(strings.TrimSuffix(name, ext), ". ")
+	stem = strings.TrimRight(stem, ". ")
+	if stem == "" {
+		stem = "_"
+	}
+	// CON.kick.wav is as reserved as CON.wav
+	if device, _, _ := strings.Cut(stem, "."); fatReservedNames[strings.ToLower(device)] {
+		stem = "_" + stem
+	}
+	runes := []rune(stem)
+	for len(runes) > 0 && len(utf16.Encode(runes))+len(utf16.Encode([]rune(ext))) > 255 {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimRight(string(runes), ". ") + ext
+}
+
+// mapPath applies fn to every element of a relative path.
+func mapPath(path string, fn func(string) string) string {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = fn(part)
+		}
+	}
+	return filepath.Join(parts...)
+}
+
+// destFolderName adjusts a destination folder name to the naming flags.
+func destFolderName(name string) string {
+	if *flagFatSafe {
+		name = fatSafeName(name)
+	}
+	if *flagDOS83 {
+		name = dos83FolderName(name)
+	}
+	return name
+}
+
+// dos83Folders are the 8.3 names given to the destination folders by their
+// lowercase long name, so the different long names of a run get different
+// short names instead of merging in the same folder.
+var dos83Folders = struct {
+	sync.Mutex
+	short map[string]string
+	used  map[string]bool
+}{short: map[string]string{}, used: map[string]bool{}}
+
+// dos83FolderName returns the 8.3 name of the destination folder name, the
+// same for every folder of the run with that name.
+func dos83FolderName(name string) string {
+	dos83Folders.Lock()
+	defer dos83Folders.Unlock()
+	key := strings.ToLower(name)
+	if short, ok := dos83Folders.short[key]; ok {
+		return short
+	}
+	short := dos83Name(name, func(short string) bool { return dos83Folders.used[short] })
+	dos83Folders.short[key], dos83Folders.used[short] = short, true
+	return short
+}
+
+// groupFolderName returns the name of the group folder with the given index.
+func groupFolderName(idx int) string {
+	if *flagDOS83 {
+		return fmt.Sprintf("GROUP%03d", idx)
+	}
+	return fmt.Sprintf("group_%d", idx)
+}
+
+// groupFolderPattern matches the group folder names, with or without -dos83.
+var groupFolderPattern = regexp.MustCompile(`^(?:group_|GROUP)(\d+)$`)
+
+// parseGroupFolderName returns the index of a group folder named by groupFolderName.
+func parseGroupFolderName(name string) (int, bool) {
+	m := groupFolderPattern.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	idx, err := strconv.Atoi(m[1])
+	return idx, err == nil && idx > 0 && name == groupFolderName(idx)
+}
+
+// dos83Chars are the characters allowed in 8.3 names besides letters and digits.
+const dos83Chars = "!#$%&'()-@^_`{}~"
+
+// dos83Name returns an uppercase 8.3 version of filename for which taken returns
+// false. Names that don't fit or are taken get a ~N tail like DOS does.
+func dos83Name(filename string, taken func(string) bool) string {
+	clean := func(s string) string {
+		var b strings.Builder
+		for _, r := range strings.ToLower(s) {
+			if t, ok := transliterations[r]; ok {
+				b.WriteString(strings.ToUpper(t))
+				continue
+			}
+			switch {
+			case r == ' ' || r == '.':
+			case (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || strings.ContainsRune(dos83Chars, r):
+				b.WriteString(strings.ToUpper(string(r)))
+			default:
+				b.WriteByte('_')
+			}
+		}
+		return b.String()
+	}
+	ext := clean(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if len(ext) > 3 {
+		ext = ext[:3]
+	}
+	if ext != "" {
+		ext = "." + ext
+	}
+	base := clean(strings.TrimSuffix(filename, filepath.Ext(filename)))
+	if base == "" {
+		base = "_"
+	}
+	if name := base + ext; len(base) <= 8 && !taken(name) {
+		return name
+	}
+	for i := 1; ; i++ {
+		tail := fmt.Sprintf("~%d", i)
+		name := base[:min(len(base), 8-len(tail))] + tail + ext
+		if !taken(name) {
+			return name
+		}
+	}
+}
+
+// uniqueDOSName returns an 8.3 version of filename that isn't used or present in
+// dir and marks it as used.
+func uniqueDOSName(dir, filename string, used map[string]bool) string {
+	name := dos83Name(filename, func(name string) bool {
+		if used[strings.ToLower(name)] {
+			return true
+		}
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	})
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// writeDOSMapping writes the NAMES.CSV file mapping the 8.3 names of the files
+// written to the destination to their original paths.
+func writeDOSMapping(destPath string) error {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write([]string{"short", "original"})
+	for _, entry := range recordedFiles() {
+		short, err := filepath.Rel(destPath, entry.Destination)
+		if err != nil {
+			short = entry.Destination
+		}
+		w.Write([]string{filepath.ToSlash(short), entry.Source})
+	}
+	w.Flush()
+	return os.WriteFile(filepath.Join(destPath, "NAMES.CSV"), []byte(b.String()), 0666)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"kick", "kick"},
+		{"Kick Drum 01", "kick_drum_01"},
+		{"Hat__Open", "hat_open"},
+		{"--Snare!!", "snare"},
+		{"Café Été", "cafe_ete"},
+		{"Straße", "strasse"},
+		{"808 (Long)", "808_long"},
+		{"", "sample"},
+		{"日本", "sample"},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.in); got != tt.want {
+			t.Errorf("slugify(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDOS83Name(t *testing.T) {
+	tests := []struct {
+		in    string
+		taken []string
+		want  string
+	}{
+		{"kick.wav", nil, "KICK.WAV"},
+		{"snare.aiff", nil, "SNARE.AIF"},
+		{"README", nil, "README"},
+		{"a+b.wav", nil, "A_B.WAV"},
+		{"Crash Rd.wav", nil, "CRASHRD.WAV"},
+		{"Kick Drum 01.wav", nil, "KICKDR~1.WAV"},
+		{"Kick Drum 01.wav", []string{"KICKDR~1.WAV"}, "KICKDR~2.WAV"},
+		{"kick.wav", []string{"KICK.WAV"}, "KICK~1.WAV"},
+		{"pad.v2.wav", nil, "PADV2.WAV"},
+		{"café.wav", nil, "CAFE.WAV"},
+		{".wav", nil, "_.WAV"},
+	}
+	for _, tt := range tests {
+		taken := map[string]bool{}
+		for _, name := range tt.taken {
+			taken[name] = true
+		}
+		if got := dos83Name(tt.in, func(name string) bool { return taken[name] }); got != tt.want {
+			t.Errorf("dos83Name(%q) with %v taken = %q; want %q", tt.in, tt.taken, got, tt.want)
+		}
+	}
+}
+
+func TestDestFilename(t *testing.T) {
+	src := filepath.Join("samples", "Pack", "Kick 01.WAV")
+	tests := []struct {
+		src            string
+		prefix, suffix string
+		slug, parent   bool
+		fatSafe        bool
+		want           string
+	}{
+		{src: src, want: "Kick 01.WAV"},
+		{src: src, prefix: "x_", suffix: "_y", want: "x_Kick 01_y.WAV"},
+		{src: "Kick.v2.wav", suffix: "_y", want: "Kick.v2_y.wav"},
+		{src: "README", prefix: "x_", want: "x_README"},
+		{src: src, slug: true, want: "kick_01.wav"},
+		{src: src, parent: true, want: "Pack__Kick 01.WAV"},
+		{src: src, prefix: "x_", parent: true, want: "x_Pack__Kick 01.WAV"},
+		{src: src, slug: true, parent: true, want: "pack__kick_01.wav"},
+		{src: "Kick 01.WAV", parent: true, want: "Kick 01.WAV"},
+		{src: filepath.Join("Pack", "Kick.v2.wav"), slug: true, want: "kick_v2.wav"},
+		{src: filepath.Join("Pack", "con.wav"), fatSafe: true, want: "_con.wav"},
+		{src: filepath.Join("Pack", "Hat?.wav"), fatSafe: true, want: "Hat_.wav"},
+	}
+	defer func(prefix, suffix string, slug, parent, fatSafe bool) {
+		*flagPrefix, *flagSuffix, *flagSlug, *flagParentPrefix, *flagFatSafe = prefix, suffix, slug, parent, fatSafe
+	}(*flagPrefix, *flagSuffix, *flagSlug, *flagParentPrefix, *flagFatSafe)
+	for _, tt := range tests {
+		*flagPrefix, *flagSuffix, *flagSlug, *flagParentPrefix, *flagFatSafe = tt.prefix, tt.suffix, tt.slug, tt.parent, tt.fatSafe
+		if got := destFilename(tt.src); got != tt.want {
+			t.Errorf("destFilename(%q) with %+v = %q; want %q", tt.src, tt, got, tt.want)
+		}
+	}
+}
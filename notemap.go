@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// noteMapName is the name of the note mapping files without their extension.
+const noteMapName = "notemap"
+
+// noteMapFormats are the extensions of the note mapping files by -noteMap format.
+var noteMapFormats = map[string]string{
+	"json":   ".json",
+	"csv":    ".csv",
+	"midnam": ".midnam",
+}
+
+// isNoteMap reports if the file of a group folder is its note mapping file.
+func isNoteMap(name string) bool {
+	for _, ext := range noteMapFormats {
+		if name == noteMapName+ext {
+			return true
+		}
+	}
+	return false
+}
+
+// noteMapping assigns a sample of a group folder to a MIDI note.
+type noteMapping struct {
+	Note int    `json:"note"`
+	Name string `json:"name"`
+	// File is the path of the sample relative to the group folder
+	File string `json:"file"`
+	// RoundRobin is the number of the variation of the file, from 1, when the
+	// note alternates between several round robin variations
+	RoundRobin int `json:"roundRobin,omitempty"`
+}
+
+// groupNoteMappings assigns the samples of the group folder dir, in the order
+// of their paths, to the consecutive MIDI notes from start, the round robin
+// variations of a hit sharing a note. The samples which don't fit under note
+// 127 are left out.
+func groupNoteMappings(dir string, start int) ([]noteMapping, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || isGroupExtra(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	// the variations of a hit are next to each other once sorted
+	keys := make([]string, len(files))
+	variations := make([]int, len(files))
+	for i, file := range files {
+		ext := filepath.Ext(file)
+		name, variation, ok := roundRobinName(strings.TrimSuffix(file, ext))
+		if ok {
+			keys[i], variations[i] = strings.ToLower(name+ext), variation
+		}
+	}
+	var mappings []noteMapping
+	note := start - 1
+	for i, file := range files {
+		alternates := keys[i] != "" && ((i > 0 && keys[i-1] == keys[i]) || (i+1 < len(files) && keys[i+1] == keys[i]))
+		if !alternates || i == 0 || keys[i-1] != keys[i] {
+			note++
+		}
+		if note > 127 {
+			warnf("Only %d of the %d samples of %s fit in the MIDI notes from %s", i, len(files), dir, noteName(start))
+			break
+		}
+		m := noteMapping{Note: note, Name: noteName(note), File: file}
+		if alternates {
+			m.RoundRobin = variations[i]
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
+
+// writeNoteMap writes the note mapping file of the group folder dir in the
+// format, assigning its samples to MIDI notes in order so the drum samplers
+// can load the folder as a bank: a JSON array, a CSV or a MIDI Name Document
+// naming the notes after the samples.
+func writeNoteMap(dir, format string, start int) error {
+	mappings, err := groupNoteMappings(dir, start)
+	if err != nil || len(mappings) == 0 {
+		return err
+	}
+	var data []byte
+	switch format {
+	case "json":
+		if data, err = json.MarshalIndent(mappings, "", "  "); err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	case "csv":
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		w.Write([]string{"note", "name", "file", "roundRobin"})
+		for _, m := range mappings {
+			roundRobin := ""
+			if m.RoundRobin > 0 {
+				roundRobin = fmt.Sprint(m.RoundRobin)
+			}
+			w.Write([]string{fmt.Sprint(m.Note), m.Name, m.File, roundRobin})
+		}
+		w.Flush()
+		data = []byte(b.String())
+	case "midnam":
+		data = midiNameDocument(filepath.Base(dir), mappings)
+	}
+	return os.WriteFile(filepath.Join(dir, noteMapName+noteMapFormats[format]), data, 0666)
+}
+
+// midiNameDocument returns a MIDI Name Document naming the notes of the
+// mappings after their samples, the drum map format read by the DAWs.
+func midiNameDocument(name string, mappings []noteMapping) []byte {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE MIDINameDocument PUBLIC "-//MIDI Manufacturers Association//DTD MIDINameDocument 1.0//EN" "http://www.midi.org/dtds/MIDINameDocument10.dtd">
+<MIDINameDocument>
+	<Author>sampleSorter</Author>
+	<MasterDeviceNames>
+		<Manufacturer>sampleSorter</Manufacturer>
+`)
+	fmt.Fprintf(&b, "\t\t<Model>%s</Model>\n", xmlEscape(name))
+	b.WriteString(`		<CustomDeviceMode Name="Default">
+			<ChannelNameSetAssignments>
+`)
+	for channel := 1; channel <= 16; channel++ {
+		fmt.Fprintf(&b, "\t\t\t\t<ChannelNameSetAssign Channel=\"%d\" NameSet=\"Samples\"/>\n", channel)
+	}
+	b.WriteString(`			</ChannelNameSetAssignments>
+		</CustomDeviceMode>
+		<ChannelNameSet Name="Samples">
+			<AvailableForChannels>
+`)
+	for channel := 1; channel <= 16; channel++ {
+		fmt.Fprintf(&b, "\t\t\t\t<AvailableChannel Channel=\"%d\" Available=\"true\"/>\n", channel)
+	}
+	b.WriteString(`			</AvailableForChannels>
+			<UsesNoteNameList Name="Samples"/>
+		</ChannelNameSet>
+		<NoteNameList Name="Samples">
+`)
+	for i, m := range mappings {
+		if i > 0 && mappings[i-1].Note == m.Note {
+			// a note is named after its first round robin variation
+			continue
+		}
+		sample := strings.TrimSuffix(m.File, filepath.Ext(m.File))
+		fmt.Fprintf(&b, "\t\t\t<Note Number=\"%d\" Name=\"%s\"/>\n", m.Note, xmlEscape(strings.ReplaceAll(sample, `"`, "'")))
+	}
+	b.WriteString(`		</NoteNameList>
+	</MasterDeviceNames>
+</MIDINameDocument>
+`)
+	return []byte(b.String())
+}
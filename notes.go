@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	noteNames   = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+	notePattern = regexp.MustCompile(`^([A-Ga-g])([#b♯♭]?)(-?[0-9])?$`)
+	// filenameNotePattern matches note names like C3, F#1 or Bb-1 surrounded by
+	// separators. The octave is required to not take a letter like the A of
+	// Kick_A or a variation letter for a note.
+	filenameNotePattern = regexp.MustCompile(`(?:^|[\s_\-.(\[])([A-Ga-g][#b]?-?[0-9])(?:$|[\s_\-.)\]])`)
+)
+
+// parseNote parses a note name like C, F#2 or Bb-1 and returns its MIDI note
+// number, using the C3 = 60 convention. Notes without an octave are in octave 3.
+func parseNote(name string) (int, bool) {
+	m := notePattern.FindStringSubmatch(strings.TrimSpace(name))
+	if m == nil {
+		return 0, false
+	}
+	note := strings.Index("C D EF G A B", strings.ToUpper(m[1]))
+	switch m[2] {
+	case "#", "♯":
+		note++
+	case "b", "♭":
+		note--
+	}
+	octave := 3
+	if m[3] != "" {
+		octave, _ = strconv.Atoi(m[3])
+	}
+	return (octave+2)*12 + note, true
+}
+
+// noteName returns the name of a MIDI note number, using the C3 = 60 convention.
+func noteName(note int) string {
+	return fmt.Sprintf("%s%d", noteNames[((note%12)+12)%12], note/12-2)
+}
+
+// semitonesTo returns the smallest shift in semitones bringing the pitch class
+// of the root note to the one of the target note.
+func semitonesTo(root, target int) int {
+	shift := ((target-root)%12 + 12) % 12
+	if shift > 5 {
+		shift -= 12
+	}
+	return shift
+}
+
+// filenameNote returns the note found in the filename of path.
+func filenameNote(path string) (int, bool) {
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	m := filenameNotePattern.FindStringSubmatch(stem)
+	if m == nil {
+		return 0, false
+	}
+	return parseNote(m[1])
+}
+
+// sourceRootNote returns the root note of the audio file from its smpl or INST
+// chunk, or from its filename.
+func sourceRootNote(src string, info *audioInfo) (int, bool) {
+	if c := info.chunk("smpl"); c != nil && c.size >= 36 {
+		if data, err := readChunk(src, c); err == nil && len(data) >= 36 {
+			note := int(binary.LittleEndian.Uint32(data[12:]))
+			if note > 0 && note < 128 {
+				return note, true
+			}
+		}
+	}
+	if c := info.chunk("INST"); c != nil && c.size >= 1 {
+		if data, err := readChunk(src, c); err == nil && len(data) >= 1 {
+			if note := int(int8(data[0])); note > 0 {
+				return note, true
+			}
+		}
+	}
+	return filenameNote(src)
+}
+
+// sourceHasLoops reports if the audio file has loop points: a loop in its
+// smpl chunk, or a sustain or release loop in its INST chunk between two
+// markers of its MARK chunk.
+func sourceHasLoops(src string, info *audioInfo) bool {
+	if c := info.chunk("smpl"); c != nil && c.size >= 36 {
+		if data, err := readChunk(src, c); err == nil && len(data) >= 36 {
+			loops := int(binary.LittleEndian.Uint32(data[28:]))
+			for l := 0; l < loops && 36+l*24+24 <= len(data); l++ {
+				loop := data[36+l*24:]
+				if binary.LittleEndian.Uint32(loop[12:]) > binary.LittleEndian.Uint32(loop[8:]) {
+					return true
+				}
+			}
+		}
+	}
+	if c := info.chunk("INST"); c != nil && c.size >= 20 && info.chunk("MARK") != nil {
+		if data, err := readChunk(src, c); err == nil && len(data) >= 20 {
+			// the sustain and release loops: play mode, begin and end markers
+			for _, loop := range [][]byte{data[8:14], data[14:20]} {
+				mode := binary.BigEndian.Uint16(loop)
+				if mode != 0 && binary.BigEndian.Uint16(loop[2:]) != binary.BigEndian.Uint16(loop[4:]) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// hasLoopPoints reports if the WAV or AIFF file at path has loop points, ok is
+// false when the file can't be read.
+func hasLoopPoints(path string) (looped, ok bool) {
+	info, err := readAudioInfo(path)
+	if err != nil {
+		return false, false
+	}
+	return sourceHasLoops(path, info), true
+}
+
+// setRootNote updates the root note of the smpl chunk carried by buf, if any,
+// scaling its loop points to follow a resampling of the audio.
+func (buf *audioBuffer) setRootNote(note int, loopScale float64) {
+	for i, c := range buf.chunks {
+		if c.id != "smpl" || len(c.data) < 36 {
+			continue
+		}
+		data := append([]byte(nil), c.data...)
+		binary.LittleEndian.PutUint32(data[12:], uint32(note))
+		loops := int(binary.LittleEndian.Uint32(data[28:]))
+		for l := 0; l < loops && 36+l*24+24 <= len(data); l++ {
+			loop := data[36+l*24:]
+			for _, offset := range []int{8, 12} {
+				pos := float64(binary.LittleEndian.Uint32(loop[offset:]))
+				binary.LittleEndian.PutUint32(loop[offset:], uint32(pos*loopScale))
+			}
+		}
+		buf.chunks[i].data = data
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestParseNote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"C3", 60, true},
+		{"C", 60, true},
+		{"c4", 72, true},
+		{"A3", 69, true},
+		{"F#2", 54, true},
+		{"Gb2", 54, true},
+		{"C♯3", 61, true},
+		{"E♭3", 63, true},
+		{"Bb-1", 22, true},
+		{"C-2", 0, true},
+		{" D3 ", 62, true},
+		{"", 0, false},
+		{"H3", 0, false},
+		{"C10", 0, false},
+		{"C#b3", 0, false},
+		{"kick", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseNote(tt.in)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseNote(%q) = %d, %v; want %d, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// runSummary describes the outcome of a run for the notifications.
+type runSummary struct {
+	Source      string  `json:"source"`
+	Destination string  `json:"destination"`
+	Keyword     string  `json:"keyword"`
+	Matches     int     `json:"matches"`
+	Copied      int     `json:"copied"`
+	Errors      int     `json:"errors"`
+	ExitCode    int     `json:"exitCode"`
+	Duration    float64 `json:"durationSeconds"`
+	// Manifest is the path of the manifest when one was written
+	Manifest string `json:"manifest,omitempty"`
+	// Mirrors describes the copies to the -mirror destinations
+	Mirrors []mirrorSummary `json:"mirrors,omitempty"`
+}
+
+// succeeded reports if the run copied all its matches.
+func (s *runSummary) succeeded() bool {
+	return s.ExitCode == exitOK || s.ExitCode == exitNoMatches
+}
+
+// title returns a one line description of the outcome of the run.
+func (s *runSummary) title() string {
+	switch s.ExitCode {
+	case exitOK, exitNoMatches:
+		return "Sample sorting done"
+	case exitInterrupted:
+		return "Sample sorting interrupted"
+	case exitPartialFailure:
+		return "Sample sorting finished with errors"
+	}
+	return "Sample sorting failed"
+}
+
+// message returns a human readable summary of the run.
+func (s *runSummary) message() string {
+	msg := fmt.Sprintf("%d of %d matches copied to %s in %s", s.Copied, s.Matches, s.Destination,
+		time.Duration(s.Duration*float64(time.Second)).Round(time.Second))
+	if s.Errors > 0 {
+		msg += fmt.Sprintf(", %d errors", s.Errors)
+	}
+	return msg
+}
+
+// notifyDesktop shows a native desktop notification with the run summary.
+func notifyDesktop(s *runSummary) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(s.message()), appleScriptString(s.title()))
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		// a balloon tip from a temporary tray icon works without any extra module
+		script := fmt.Sprintf(`Add-Type -AssemblyName System.Windows.Forms;
+$n = New-Object System.Windows.Forms.NotifyIcon;
+$n.Icon = [System.Drawing.SystemIcons]::Information;
+$n.Visible = $true;
+$n.ShowBalloonTip(10000, %s, %s, 'Info');
+Start-Sleep -Seconds 10;
+$n.Dispose()`, powerShellString(s.title()), powerShellString(s.message()))
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", script)
+		// don't keep the run waiting for the balloon to go away
+		return cmd.Start()
+	default:
+		urgency := "normal"
+		if !s.succeeded() {
+			urgency = "critical"
+		}
+		cmd = exec.Command("notify-send", "-u", urgency, "-a", "sampleSorter", s.title(), s.message())
+	}
+	return cmd.Run()
+}
+
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func powerShellString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// webhookTimeout bounds the requests made to the webhooks.
+const webhookTimeout = 10 * time.Second
+
+// postJSON posts payload encoded as JSON to url.
+func postJSON(url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("the webhook replied with %s", resp.Status)
+	}
+	return nil
+}
+
+// chatMessage returns the payload of a Slack or Discord incoming webhook
+// posting the run summary, Discord expects the text in a content field.
+func chatMessage(webhook string, s *runSummary) interface{} {
+	icon := "✅"
+	if !s.succeeded() {
+		icon = "⚠️"
+	}
+	text := fmt.Sprintf("%s *%s*\n%s", icon, s.title(), s.message())
+	if s.Keyword != "" {
+		text += fmt.Sprintf("\nKeyword: `%s`", s.Keyword)
+	}
+	if u, err := url.Parse(webhook); err == nil && (strings.HasSuffix(u.Hostname(), "discord.com") || strings.HasSuffix(u.Hostname(), "discordapp.com")) {
+		// Discord markdown uses ** for bold
+		return map[string]string{"content": strings.Replace(text, "*", "**", 2)}
+	}
+	return map[string]string{"text": text}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ANSI escape codes of the colors used in the terminal output.
+const (
+	colorReset  = "\033[0m"
+	colorBold   = "\033[1m"
+	colorDim    = "\033[2m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorCyan   = "\033[36m"
+)
+
+// useColor is set when the terminal output is colorized, see setupColor.
+var useColor bool
+
+// messageOutput is where the progress messages and the summary are printed,
+// stderr when stdout carries the tar stream of -dest -.
+var messageOutput io.Writer = os.Stdout
+
+// logToStderr is unset when the warnings and errors go to syslog instead.
+var logToStderr = true
+
+// setupColor enables the colors depending on the -color mode. In auto mode,
+// colors are used when the messages are printed to a terminal and NO_COLOR isn't set.
+func setupColor(mode string) error {
+	switch mode {
+	case "always":
+		useColor = true
+	case "never":
+		useColor = false
+	case "auto":
+		_, noColor := os.LookupEnv("NO_COLOR")
+		f, ok := messageOutput.(*os.File)
+		useColor = !noColor && os.Getenv("TERM") != "dumb" && ok && isTerminal(f)
+	default:
+		return fmt.Errorf("%q isn't one of auto, always or never", mode)
+	}
+	return nil
+}
+
+// isTerminal reports if f is a terminal rather than a file or a pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps s in the color if the colors are enabled.
+func colorize(color, s string) string {
+	if !useColor {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// highlight colorizes a path or value standing out in a message.
+func highlight(s string) string {
+	return colorize(colorCyan, s)
+}
+
+// debugf prints a message when -debug is set.
+func debugf(format string, args ...interface{}) {
+	if *flagDebug {
+		fmt.Fprintln(messageOutput, colorize(colorDim, fmt.Sprintf(format, args...)))
+	}
+}
+
+// infof prints a progress message.
+func infof(format string, args ...interface{}) {
+	fmt.Fprintf(messageOutput, format+"\n", args...)
+}
+
+// successf prints the message of a successful outcome.
+func successf(format string, args ...interface{}) {
+	fmt.Fprintln(messageOutput, colorize(colorGreen, fmt.Sprintf(format, args...)))
+}
+
+// warnf reports something that didn't go as planned but doesn't impact the
+// outcome of the run. Like errors, warnings are written to stderr and the logs.
+func warnf(format string, args ...interface{}) {
+	report(colorYellow, "warning", format, args...)
+}
+
+// errorf reports a failure.
+func errorf(format string, args ...interface{}) {
+	report(colorRed, "error", format, args...)
+}
+
+func report(color, level, format string, args ...interface{}) {
+	msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	if logToStderr {
+		fmt.Fprintln(os.Stderr, colorize(color, level+":")+" "+msg)
+	}
+	fileLog.Printf("%s: %s", level, msg)
+}
+
+// printTable prints rows of label/value pairs with aligned values.
+func printTable(w io.Writer, title string, rows [][2]string) {
+	width := 0
+	for _, row := range rows {
+		if len(row[0]) > width {
+			width = len(row[0])
+		}
+	}
+	fmt.Fprintln(w, colorize(colorBold, title))
+	for _, row := range rows {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, row[0], row[1])
+	}
+}
+
+// printRunSummary prints the outcome of the run as a table.
+func printRunSummary(s *runSummary) {
+	title := colorize(colorGreen, s.title())
+	if !s.succeeded() {
+		title = colorize(colorRed, s.title())
+	}
+	rows := [][2]string{
+		{"Matches", strconv.Itoa(s.Matches)},
+		{"Copied", strconv.Itoa(s.Copied)},
+		{"Errors", strconv.Itoa(s.Errors)},
+		{"Written", formatSize(runMetrics.written.Load())},
+		{"Duration", time.Duration(s.Duration * float64(time.Second)).Round(time.Millisecond).String()},
+		{"Destination", highlight(s.Destination)},
+	}
+	if folded := foldedStats.files.Load(); folded > 0 {
+		rows = append(rows, [2]string{"Folded", fmt.Sprintf("%d fake stereo files to mono, %s saved", folded, formatSize(foldedStats.saved.Load()))})
+	}
+	for _, m := range s.Mirrors {
+		rows = append(rows, [2]string{"Mirror", fmt.Sprintf("%s (%d copied, %d failed)", highlight(m.Destination), m.Copied, m.Failed)})
+	}
+	fmt.Fprintln(messageOutput)
+	printTable(messageOutput, title, rows)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// channelMarkers are the filename endings used to name the files of a dual mono pair.
+var channelMarkers = [][2]string{{"_l", "_r"}, {".l", ".r"}, {"-l", "-r"}, {" l", " r"}}
+
+// pairPartner returns the name of the other half of the dual mono pair path
+// would be part of, judging by its filename.
+func pairPartner(path string) (partner string, left bool, ok bool) {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	lower := strings.ToLower(stem)
+	for _, m := range channelMarkers {
+		for i, marker := range m {
+			if !strings.HasSuffix(lower, marker) {
+				continue
+			}
+			other := m[1-i]
+			// keep the case of the original marker
+			if stem[len(stem)-1] == 'L' || stem[len(stem)-1] == 'R' {
+				other = strings.ToUpper(other)
+			}
+			return stem[:len(stem)-len(marker)] + other + ext, i == 0, true
+		}
+	}
+	return "", false, false
+}
+
+// mergedPairName returns the name of the stereo file resulting from merging the
+// pair the left channel path is part of.
+func mergedPairName(path string) (string, bool) {
+	if _, left, ok := pairPartner(path); !ok || !left {
+		return "", false
+	}
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	return stem[:len(stem)-2] + ext, true
+}
+
+// pairStream splits the matches in units of files to keep in the same group folder:
+// dual mono pairs found among the matches, left channel first, and single files.
+// Files that could be half of a pair are held until their partner shows up or
+// the walk leaves their folder, since a pair is always in the same folder.
+func pairStream(in <-chan string) <-chan []string {
+	out := make(chan []string)
+	go func() {
+		defer close(out)
+		// waiting holds the files waiting for their partner, by partner path
+		waiting := map[string]string{}
+		order := []string{}
+		flush := func(current string) {
+			kept := order[:0]
+			for _, partner := range order {
+				path, ok := waiting[partner]
+				if !ok {
+					continue
+				}
+				dir := filepath.Dir(path)
+				if current != "" && strings.HasPrefix(current, dir+string(filepath.Separator)) {
+					kept = append(kept, partner)
+					continue
+				}
+				delete(waiting, partner)
+				out <- []string{path}
+			}
+			order = kept
+		}
+		for path := range in {
+			flush(path)
+			if other, ok := waiting[path]; ok {
+				delete(waiting, path)
+				if _, left, _ := pairPartner(path); left {
+					out <- []string{path, other}
+				} else {
+					out <- []string{other, path}
+				}
+				continue
+			}
+			if partner, _, ok := pairPartner(path); ok {
+				waiting[partner] = path
+				order = append(order, partner)
+				continue
+			}
+			out <- []string{path}
+		}
+		flush("")
+	}()
+	return out
+}
+
+// mergePair writes the left and right mono files as a single stereo file at dst.
+func mergePair(left, right, dst string) error {
+	if *flagDryRun {
+		infof("Merging %s and %s to %s", left, right, dst)
+		return nil
+	}
+	l, _, err := decodeAudioFile(left)
+	if err != nil {
+		return err
+	}
+	r, _, err := decodeAudioFile(right)
+	if err != nil {
+		return err
+	}
+	if l.channels != 1 || r.channels != 1 {
+		return fmt.Errorf("both files need to be mono")
+	}
+	if l.sampleRate != r.sampleRate {
+		return fmt.Errorf("sample rates don't match (%d vs %d)", l.sampleRate, r.sampleRate)
+	}
+	frames := l.frames()
+	if r.frames() > frames {
+		frames = r.frames()
+	}
+	stereo := &audioBuffer{
+		sampleRate: l.sampleRate,
+		channels:   2,
+		bitDepth:   l.bitDepth,
+		float:      l.float || r.float,
+		data:       interleave(l.data, r.data, frames),
+		chunks:     l.chunks,
+	}
+	if r.bitDepth > stereo.bitDepth {
+		stereo.bitDepth = r.bitDepth
+	}
+	debugf("Merging %s and %s to %s", left, right, dst)
+	if err := writeAudioFile(dst, stereo); err != nil {
+		return err
+	}
+	recordFile(manifestEntry{Source: left, Destination: dst})
+	recordFile(manifestEntry{Source: right, Destination: dst})
+	return nil
+}
+
+// interleave returns frames stereo frames made of the left and right samples,
+// the shortest channel is padded with silence.
+func interleave(left, right []float64, frames int) []float64 {
+	data := make([]float64, frames*2)
+	for i := 0; i < frames; i++ {
+		if i < len(left) {
+			data[i*2] = left[i]
+		}
+		if i < len(right) {
+			data[i*2+1] = right[i]
+		}
+	}
+	return data
+}
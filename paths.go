@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// expandPath expands a leading ~ to the home directory and the environment
+// variables of path, the %VAR% ones only on Windows, then makes it absolute
+// and safe to use with long paths. Unknown variables are left untouched since
+// $ and % are valid in filenames.
+func expandPath(path, home string) string {
+	switch {
+	case path == "~":
+		path = home
+	case strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`):
+		path = filepath.Join(home, path[2:])
+	}
+	path = envVarPattern.ReplaceAllStringFunc(path, func(v string) string {
+		name := strings.Trim(v, "${}%")
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+		return v
+	})
+	// a bare drive letter like D: is relative to the current folder of the
+	// drive, we want its root.
+	if vol := filepath.VolumeName(path); vol != "" && vol == path {
+		path += string(filepath.Separator)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return longPath(path)
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import "regexp"
+
+// envVarPattern matches environment variables written as $VAR or ${VAR}.
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// longPath returns path as is, only Windows limits the length of paths.
+func longPath(path string) string {
+	return path
+}
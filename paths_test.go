@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestExpandPath(t *testing.T) {
+	t.Setenv("SAMPLES", "samples")
+	home := filepath.Join(t.TempDir(), "home")
+	percent := filepath.Join(home, "%SAMPLES%")
+	if runtime.GOOS == "windows" {
+		percent = filepath.Join(home, "samples")
+	}
+	tests := []struct{ path, want string }{
+		{"~", home},
+		{filepath.Join("~", "kicks"), filepath.Join(home, "kicks")},
+		{filepath.Join(home, "$SAMPLES"), filepath.Join(home, "samples")},
+		{filepath.Join(home, "${SAMPLES}", "kicks"), filepath.Join(home, "samples", "kicks")},
+		{filepath.Join(home, "$UNKNOWN_SAMPLES"), filepath.Join(home, "$UNKNOWN_SAMPLES")},
+		// %VAR% is only expanded on Windows, it's a valid filename elsewhere
+		{filepath.Join(home, "%SAMPLES%"), percent},
+	}
+	for _, tt := range tests {
+		if got := expandPath(tt.path, home); got != longPath(tt.want) {
+			t.Errorf("expandPath(%q) = %q; want %q", tt.path, got, longPath(tt.want))
+		}
+	}
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// envVarPattern matches environment variables written as $VAR, ${VAR} or the
+// %VAR% of cmd.
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)|%([A-Za-z_][A-Za-z0-9_()]*)%`)
+
+// longPath prefixes an absolute path with \\?\ so the files under it can be
+// accessed even when their full path is longer than MAX_PATH, which is common
+// with deeply nested sample libraries.
+func longPath(path string) string {
+	if strings.HasPrefix(path, `\\?\`) || !filepath.IsAbs(path) {
+		return path
+	}
+	if strings.HasPrefix(path, `\\`) {
+		// UNC path: \\server\share becomes \\?\UNC\server\share
+		return `\\?\UNC\` + path[2:]
+	}
+	return `\\?\` + path
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rawChunk is a chunk carried over untouched when rewriting an audio file.
+type rawChunk struct {
+	id   string
+	data []byte
+}
+
+// audioBuffer holds decoded audio as interleaved samples in the [-1, 1] range,
+// along with the format to use when encoding it.
+type audioBuffer struct {
+	sampleRate int
+	channels   int
+	bitDepth   int
+	float      bool
+	data       []float64
+	// chunks are the WAV metadata chunks of the source file
+	chunks []rawChunk
+}
+
+// frames returns the number of sample frames in the buffer.
+func (buf *audioBuffer) frames() int {
+	if buf.channels == 0 {
+		return 0
+	}
+	return len(buf.data) / buf.channels
+}
+
+// decodeAudioFile reads and decodes the audio data of the WAV or AIFF file at path.
+// Truncated files are decoded up to the last complete frame.
+func decodeAudioFile(path string) (*audioBuffer, *audioInfo, error) {
+	info, err := readAudioInfo(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.supportedCodec() {
+		return nil, info, fmt.Errorf("unsupported codec %s (%d bit)", info.codecName(), info.bitDepth)
+	}
+	offset, size, err := info.dataRange()
+	if err != nil {
+		return nil, info, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, info, err
+	}
+	defer f.Close()
+
+	raw := make([]byte, size)
+	if _, err := f.ReadAt(raw, offset); err != nil {
+		return nil, info, err
+	}
+
+	buf := &audioBuffer{
+		sampleRate: info.sampleRate,
+		channels:   info.channels,
+		bitDepth:   info.bitDepth,
+		float:      info.float,
+		data:       decodeSamples(raw, info),
+	}
+	if info.container == "WAVE" {
+		for _, c := range info.chunks {
+			if c.id == "fmt " || c.id == "fact" || c.id == "data" || c.offset+8+c.size > info.fileSize {
+				continue
+			}
+			data := make([]byte, c.size)
+			if _, err := f.ReadAt(data, c.offset+8); err != nil {
+				return nil, info, err
+			}
+			buf.chunks = append(buf.chunks, rawChunk{id: c.id, data: data})
+		}
+	}
+	return buf, info, nil
+}
+
+// decodeSamples converts raw sample data to floats in the [-1, 1] range. The
+// samples are read in the containers of the block alignment, so 24 bit samples
+// stored left justified in 32 bits decode to their value.
+func decodeSamples(raw []byte, info *audioInfo) []float64 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if info.bigEndian {
+		order = binary.BigEndian
+	}
+	width := info.sampleWidth()
+	out := make([]float64, len(raw)/width)
+	scale := float64(int64(1) << uint(width*8-1))
+	for i := range out {
+		s := raw[i*width : (i+1)*width]
+		switch {
+		case info.float && width == 4:
+			out[i] = float64(math.Float32frombits(order.Uint32(s)))
+		case info.float && width == 8:
+			out[i] = math.Float64frombits(order.Uint64(s))
+		case width == 1 && info.container == "WAVE":
+			// 8 bit WAV data is unsigned
+			out[i] = float64(int(s[0])-128) / scale
+		default:
+			var v int64
+			if order == binary.BigEndian {
+				for _, b := range s {
+					v = v<<8 | int64(b)
+				}
+			} else {
+				for j := width - 1; j >= 0; j-- {
+					v = v<<8 | int64(s[j])
+				}
+			}
+			// sign extension
+			if v >= int64(scale) {
+				v -= int64(scale) * 2
+			}
+			out[i] = float64(v) / scale
+		}
+	}
+	return out
+}
+
+// quantize converts a sample in the [-1, 1] range to a signed integer sample
+// of width bytes, clipping it if needed. It's the reverse of decodeSamples.
+func quantize(v float64, width int) int64 {
+	scale := float64(int64(1) << uint(width*8-1))
+	return int64(math.Max(-scale, math.Min(scale-1, math.Round(v*scale))))
+}
+
+// writeAudioFile encodes buf at path as an AIFF file if the path has an AIFF
+// extension or as a WAV file otherwise.
+func writeAudioFile(path string, buf *audioBuffer) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".aif", ".aiff":
+		return writeAiffFile(path, buf)
+	}
+	return writeWavFile(path, buf)
+}
+
+// writeWavFile encodes buf as a WAV file at path, using the buffer bit depth and
+// float settings. The metadata chunks of the buffer are written after the audio data.
+func writeWavFile(path string, buf *audioBuffer) (err error) {
+	out, err := createDestFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		cerr := closeDestFile(out)
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	width := (buf.bitDepth + 7) / 8
+	formatTag := uint16(wavFormatPCM)
+	if buf.float {
+		formatTag = wavFormatFloat
+	}
+	dataSize := len(buf.data) * width
+
+	var chunks bytes.Buffer
+	for _, c := range buf.chunks {
+		chunks.WriteString(c.id)
+		binary.Write(&chunks, binary.LittleEndian, uint32(len(c.data)))
+		chunks.Write(c.data)
+		if len(c.data)%2 == 1 {
+			chunks.WriteByte(0)
+		}
+	}
+	var fact []byte
+	if buf.float {
+		fact = make([]byte, 12)
+		copy(fact, "fact")
+		binary.LittleEndian.PutUint32(fact[4:], 4)
+		binary.LittleEndian.PutUint32(fact[8:], uint32(buf.frames()))
+	}
+
+	w := bufio.NewWriter(out)
+	le := binary.LittleEndian
+	w.WriteString("RIFF")
+	binary.Write(w, le, uint32(4+24+len(fact)+8+dataSize+dataSize%2+chunks.Len()))
+	w.WriteString("WAVEfmt ")
+	binary.Write(w, le, []uint32{16})
+	binary.Write(w, le, []uint16{formatTag, uint16(buf.channels)})
+	binary.Write(w, le, []uint32{uint32(buf.sampleRate), uint32(buf.sampleRate * buf.channels * width)})
+	binary.Write(w, le, []uint16{uint16(buf.channels * width), uint16(buf.bitDepth)})
+	w.Write(fact)
+	w.WriteString("data")
+	binary.Write(w, le, uint32(dataSize))
+
+	sample := make([]byte, 8)
+	for _, v := range buf.data {
+		switch {
+		case buf.float && width == 4:
+			le.PutUint32(sample, math.Float32bits(float32(v)))
+		case buf.float && width == 8:
+			le.PutUint64(sample, math.Float64bits(v))
+		case width == 1:
+			sample[0] = byte(quantize(v, width) + 128)
+		default:
+			le.PutUint64(sample, uint64(quantize(v, width)))
+		}
+		w.Write(sample[:width])
+	}
+	if dataSize%2 == 1 {
+		w.WriteByte(0)
+	}
+	w.Write(chunks.Bytes())
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+// writeAiffFile encodes buf as a big endian PCM AIFF file at path.
+// Float buffers are written as 24 bit PCM since AIFF doesn't support float data.
+func writeAiffFile(path string, buf *audioBuffer) (err error) {
+	out, err := createDestFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		cerr := closeDestFile(out)
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	bitDepth := buf.bitDepth
+	if buf.float {
+		bitDepth = 24
+	}
+	width := (bitDepth + 7) / 8
+	dataSize := len(buf.data) * width
+
+	w := bufio.NewWriter(out)
+	be := binary.BigEndian
+	w.WriteString("FORM")
+	binary.Write(w, be, uint32(4+8+18+8+8+dataSize+dataSize%2))
+	w.WriteString("AIFFCOMM")
+	binary.Write(w, be, uint32(18))
+	binary.Write(w, be, uint16(buf.channels))
+	binary.Write(w, be, uint32(buf.frames()))
+	binary.Write(w, be, uint16(bitDepth))
+	w.Write(encodeExtended(float64(buf.sampleRate)))
+	w.WriteString("SSND")
+	binary.Write(w, be, []uint32{uint32(8 + dataSize), 0, 0})
+
+	sample := make([]byte, 8)
+	for _, v := range buf.data {
+		be.PutUint64(sample, uint64(quantize(v, width)))
+		w.Write(sample[8-width:])
+	}
+	if dataSize%2 == 1 {
+		w.WriteByte(0)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+// encodeExtended encodes a positive value as the 80 bit IEEE 754 extended float used by AIFF.
+func encodeExtended(v float64) []byte {
+	b := make([]byte, 10)
+	if v <= 0 {
+		return b
+	}
+	frac, exp := math.Frexp(v)
+	binary.BigEndian.PutUint16(b, uint16(exp-1+16383))
+	binary.BigEndian.PutUint64(b[2:], uint64(math.Ldexp(frac, 64)))
+	return b
+}
+
+// mono returns an empty single channel buffer with the same format and length as buf.
+func (buf *audioBuffer) mono() *audioBuffer {
+	return &audioBuffer{
+		sampleRate: buf.sampleRate,
+		channels:   1,
+		bitDepth:   buf.bitDepth,
+		float:      buf.float,
+		data:       make([]float64, buf.frames()),
+		chunks:     buf.chunks,
+	}
+}
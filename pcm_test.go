@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testWav writes a WAV file made of a fmt chunk with the given fields and a
+// data chunk holding data to a temporary folder and returns its path.
+func testWav(t *testing.T, formatTag, channels, sampleRate, blockAlign, bitDepth int, data []byte) string {
+	t.Helper()
+	le := binary.LittleEndian
+	b := []byte("RIFF\x00\x00\x00\x00WAVEfmt \x10\x00\x00\x00")
+	b = le.AppendUint16(b, uint16(formatTag))
+	b = le.AppendUint16(b, uint16(channels))
+	b = le.AppendUint32(b, uint32(sampleRate))
+	b = le.AppendUint32(b, uint32(sampleRate*blockAlign))
+	b = le.AppendUint16(b, uint16(blockAlign))
+	b = le.AppendUint16(b, uint16(bitDepth))
+	b = append(b, "data"...)
+	b = le.AppendUint32(b, uint32(len(data)))
+	b = append(b, data...)
+	le.PutUint32(b[4:], uint32(len(b)-8))
+	path := filepath.Join(t.TempDir(), "test.wav")
+	if err := os.WriteFile(path, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFloatToPCM(t *testing.T) {
+	values := []float64{0, 0.5, -0.5, 0.25, -1, 0.999}
+	var data []byte
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint32(data, math.Float32bits(float32(v)))
+	}
+	src := testWav(t, wavFormatFloat, 1, 44100, 4, 32, data)
+	defer func(v bool) { *flagFloatToPCM = v }(*flagFloatToPCM)
+	*flagFloatToPCM = true
+
+	dst := filepath.Join(t.TempDir(), "out.wav")
+	if err := transformAudioFile(src, dst, map[string]bool{}, enabledTransforms()); err != nil {
+		t.Fatal(err)
+	}
+	buf, info, err := decodeAudioFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.float || info.bitDepth != 24 || info.blockAlign != 3 || info.sampleRate != 44100 {
+		t.Fatalf("converted to float %v, %d bit, %d byte frames at %d Hz; want 24 bit PCM at 44100 Hz", info.float, info.bitDepth, info.blockAlign, info.sampleRate)
+	}
+	if problems := info.problems(); len(problems) > 0 {
+		t.Errorf("converted file has problems: %v", problems)
+	}
+	if len(buf.data) != len(values) {
+		t.Fatalf("converted file has %d samples; want %d", len(buf.data), len(values))
+	}
+	for i, v := range values {
+		if math.Abs(buf.data[i]-v) > 1.0/(1<<23) {
+			t.Errorf("sample %d = %v; want %v", i, buf.data[i], v)
+		}
+	}
+}
+
+func TestDecodeSamplesContainer(t *testing.T) {
+	// 24 bit samples in 32 bit containers, the low byte being padding
+	data := []byte{0, 0, 0, 0x40, 0, 0, 0, 0xC0}
+	path := testWav(t, wavFormatPCM, 1, 48000, 4, 24, data)
+	buf, _, err := decodeAudioFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{0.5, -0.5}; len(buf.data) != 2 || buf.data[0] != want[0] || buf.data[1] != want[1] {
+		t.Errorf("decoded %v; want %v", buf.data, want)
+	}
+}
+
+func TestDecodeInvalidBlockAlign(t *testing.T) {
+	path := testWav(t, wavFormatPCM, 1, 44100, 0, 16, make([]byte, 8))
+	if _, _, err := decodeAudioFile(path); err == nil {
+		t.Error("decoded a file with a 0 byte block alignment")
+	}
+	if err := checkAudioFile(path); err == nil {
+		t.Error("a file with a 0 byte block alignment passed the check")
+	}
+}
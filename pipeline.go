@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The matches stream from the folder walk to the copy through channels so the
+// first groups are copied while the source is still being searched and the
+// memory use doesn't grow with the size of the library:
+//
+//	findMatchingFiles -> pairStream -> capUnits -> groupStream -> copy
+//
+// Every stage closes its output channel once its input is drained. The walk
+// stops early when its context is cancelled: on -max, -maxTotalSize, -timeout
+// or when the run is interrupted.
+
+// fileTimeoutError is returned when a file took longer than -fileTimeout to copy.
+type fileTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e fileTimeoutError) Error() string {
+	return fmt.Sprintf("gave up after %s, the file is removed if it's still incomplete at the end of the run", e.timeout)
+}
+
+// withFileTimeout runs fn, giving up on it after -fileTimeout if it's set.
+// A blocked system call can't be cancelled, fn then keeps running in the
+// background and the files it's writing are tracked as in flight.
+func withFileTimeout(fn func() error) error {
+	if *flagFileTimeout <= 0 {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(*flagFileTimeout):
+		return fileTimeoutError{*flagFileTimeout}
+	}
+}
+
+// withRetries runs fn until it succeeds, retrying it up to -retries times with
+// an exponential backoff starting at -retryDelay. Timeouts aren't retried since
+// the timed out attempt might still be running, nor the failed writes to an
+// archive which already holds a part of the file.
+func withRetries(ctx context.Context, fn func() error) error {
+	delay := *flagRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= *flagRetries {
+			return err
+		}
+		if _, ok := err.(fileTimeoutError); ok {
+			return err
+		}
+		if _, ok := err.(archiveWriteError); ok {
+			return err
+		}
+		warnf("Attempt %d failed, retrying in %s - %s", attempt+1, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// capUnits passes the units through until -max units or maxSize bytes are
+// reached, then stops the walk and drops the remaining units.
+func capUnits(in <-chan []string, maxSize int64, stopWalk context.CancelFunc) <-chan []string {
+	out := make(chan []string)
+	go func() {
+		defer close(out)
+		count := 0
+		var total int64
+		for unit := range in {
+			if *flagMax > 0 && count >= *flagMax {
+				infof("We reached the max amount of samples to copy: %d", *flagMax)
+				break
+			}
+			if maxSize > 0 {
+				size := unitSize(unit)
+				if total+size > maxSize {
+					infof("We reached the max total size of samples to copy: %s", formatSize(maxSize))
+					break
+				}
+				total += size
+			}
+			count++
+			out <- unit
+		}
+		stopWalk()
+		// let the upstream stages finish
+		for range in {
+		}
+	}()
+	return out
+}
+
+// sortedStream sends the units sorted by increasing key, keeping the order of
+// the units with the same key. The units are only sent once the walk is over.
+func sortedStream(in <-chan []string, key func(unit []string) float64) <-chan []string {
+	out := make(chan []string)
+	go func() {
+		defer close(out)
+		var units [][]string
+		var keys []float64
+		for unit := range in {
+			units = append(units, unit)
+			keys = append(keys, key(unit))
+		}
+		order := make([]int, len(units))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+		for _, i := range order {
+			out <- units[i]
+		}
+	}()
+	return out
+}
+
+// Destination layouts, selected with -layout.
+const (
+	// layoutFlat copies the matches to numbered groups
+	layoutFlat = "flat"
+	// layoutPreserve recreates the source folders of the matches
+	layoutPreserve = "preserve"
+	// layoutHybrid copies the matches to numbered groups inside a folder named
+	// after their pack, the top folder of the source they're in
+	layoutHybrid = "hybrid"
+)
+
+// layoutFolder returns the folder, relative to the group folder, the file at
+// path is copied to in the layout.
+func layoutFolder(layout, srcRoot, path string) string {
+	rel, err := filepath.Rel(srcRoot, filepath.Dir(path))
+	if err != nil || rel == "." || layout == layoutFlat {
+		return ""
+	}
+	if layout == layoutHybrid {
+		rel = strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+	}
+	return mapPath(rel, destFolderName)
+}
+
+// unitGroup is a set of units to copy to the same group folder.
+type unitGroup struct {
+	// folder is the folder of the subfolder the group is part of
+	folder string
+	idx    int
+	// numbered is set when the files are copied to a numbered group folder in
+	// folder rather than to folder itself
+	numbered bool
+	// existing and existingSize are the number and size of the files already
+	// in the group folder, from a previous run
+	existing     int
+	existingSize int64
+	units        [][]string
+	unitsSize    int64
+}
+
+// add adds a unit of the given size to the group.
+func (g *unitGroup) add(unit []string, size int64) {
+	g.units = append(g.units, unit)
+	g.unitsSize += size
+}
+
+// full reports if adding a unit of the given size would put the group over
+// the -perFolder limit or maxSize. An empty group is never full so units
+// bigger than the limits still get copied.
+func (g *unitGroup) full(unit []string, size, maxSize int64) bool {
+	if len(g.units) == 0 && g.existing == 0 {
+		return false
+	}
+	if g.existing+g.files()+len(unit) > *flagGroupSize {
+		return true
+	}
+	return maxSize > 0 && g.existingSize+g.unitsSize+size > maxSize
+}
+
+// dir returns the folder the files of the group are copied to.
+func (g *unitGroup) dir() string {
+	if !g.numbered {
+		return g.folder
+	}
+	return filepath.Join(g.folder, groupFolderName(g.idx))
+}
+
+// files returns the number of files in the group, not counting the existing ones.
+func (g *unitGroup) files() int {
+	count := 0
+	for _, unit := range g.units {
+		count += len(unit)
+	}
+	return count
+}
+
+// sources returns the paths of the files of the units.
+func (g *unitGroup) sources() []string {
+	var paths []string
+	for _, unit := range g.units {
+		paths = append(paths, unit...)
+	}
+	return paths
+}
+
+// size returns the combined size of the files of the group, not counting the existing ones.
+func (g *unitGroup) size() int64 {
+	return g.unitsSize
+}
+
+// groupStream sorts the units into subfolders of destPath by rendering the
+// template for their first file and sends them in groups of at most perFolder
+// files, and maxSize bytes when set, as soon as a group is full. A unit is
+// never split across groups.
+// The groups that aren't full are sent once all the units are sorted.
+// The layout tells how the source folders relative to srcRoot are reflected
+// at the destination, see layoutFolder.
+func groupStream(in <-chan []string, srcRoot, destPath, template, layout string, maxSize int64) <-chan *unitGroup {
+	out := make(chan *unitGroup)
+	go func() {
+		defer close(out)
+		// the group being filled for each subfolder, in order of first appearance
+		pending := map[string]*unitGroup{}
+		folders := []string{}
+		manifested := previousManifestSources(destPath)
+		previous := map[string]map[string]bool{}
+		for unit := range in {
+			folder := filepath.Join(destPath, mapPath(renderTemplate(template, unit[0]), destFolderName))
+			folder = filepath.Join(folder, layoutFolder(layout, srcRoot, unit[0]))
+			if *flagClipped == "quarantine" && clipped(unit[0]) {
+				folder = filepath.Join(destPath, quarantineFolder)
+			}
+			group, ok := pending[folder]
+			if !ok {
+				group = &unitGroup{folder: folder, idx: 1, numbered: layout != layoutPreserve}
+				if group.numbered {
+					group.idx, group.existing, group.existingSize = lastGroupFolder(folder)
+				}
+				pending[folder] = group
+				folders = append(folders, folder)
+				previous[folder] = previousCopies(folder)
+			}
+			if copiedBefore(unit, manifested, previous[folder]) {
+				debugf("Skipping %s, copied to %s by a previous run", unit[0], folder)
+				continue
+			}
+			// check if we filled up our group yet
+			size := unitSize(unit)
+			if group.full(unit, size, maxSize) {
+				// a group folder filled up by a previous run has nothing to copy
+				if len(group.units) > 0 {
+					out <- group
+				}
+				group = &unitGroup{folder: folder, idx: group.idx + 1, numbered: group.numbered}
+				pending[folder] = group
+			}
+			group.add(unit, size)
+		}
+		// send the left overs
+		for _, folder := range folders {
+			out <- pending[folder]
+		}
+	}()
+	return out
+}
+
+// isGroupExtra reports if the file of a group folder isn't a sample but a
+// checksum, license, companion, note map, sampler patch or instrument file
+// written along them.
+func isGroupExtra(name string) bool {
+	return name == sumsFilename || strings.HasSuffix(name, sidecarExt) || isLicenseFile(name) || isCompanionFile(name) || isNoteMap(name) || isSamplerPatch(name) || strings.HasSuffix(name, bitwigExt) || strings.HasSuffix(name, renoiseExt)
+}
+
+// lastGroupFolder returns the index of the last group folder left in folder by
+// a previous run and its number of files, so the run tops it up and carries on
+// with the numbering. It returns 1 and no files when there's no group folder.
+func lastGroupFolder(folder string) (idx, files int, size int64) {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return 1, 0, 0
+	}
+	idx = 0
+	for _, entry := range entries {
+		if i, ok := parseGroupFolderName(entry.Name()); ok && entry.IsDir() && i > idx {
+			idx = i
+		}
+	}
+	if idx == 0 {
+		return 1, 0, 0
+	}
+	// the instrument folders of -nestLayers count as part of the group
+	filepath.WalkDir(filepath.Join(folder, groupFolderName(idx)), func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || isGroupExtra(entry.Name()) {
+			return nil
+		}
+		files++
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return idx, files, size
+}
+
+// previousManifestSources returns the sources of the copies listed in the
+// manifest left at destPath by a previous run that are still there.
+func previousManifestSources(destPath string) map[string]bool {
+	sources := map[string]bool{}
+	data, err := os.ReadFile(filepath.Join(destPath, manifestFilename))
+	if err != nil {
+		return sources
+	}
+	var previous runManifest
+	if err := json.Unmarshal(data, &previous); err != nil {
+		warnf("Ignoring the invalid manifest of the destination - %s", err)
+		return sources
+	}
+	for _, entry := range previous.Files {
+		if _, err := os.Lstat(entry.Destination); err == nil {
+			sources[entry.Source] = true
+		}
+	}
+	return sources
+}
+
+// previousCopies returns the copyKey of the files left in folder by a
+// previous run.
+func previousCopies(folder string) map[string]bool {
+	copies := map[string]bool{}
+	filepath.WalkDir(folder, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || isGroupExtra(entry.Name()) {
+			return nil
+		}
+		if fi, err := entry.Info(); err == nil {
+			copies[copyKey(entry.Name(), fi.Size())] = true
+		}
+		return nil
+	})
+	return copies
+}
+
+// copyKey identifies a copy by its lowercase name without the suffix making
+// it unique and its size.
+func copyKey(name string, size int64) string {
+	return fmt.Sprintf("%s/%d", uniqueSuffixPattern.ReplaceAllString(strings.ToLower(name), "$1"), size)
+}
+
+// copiedBefore reports if all the files of the unit were copied by a previous
+// run, listed in its manifest or left in the folder with the same name and
+// size.
+func copiedBefore(unit []string, manifested, copies map[string]bool) bool {
+	for _, src := range unit {
+		if manifested[src] {
+			continue
+		}
+		if len(copies) == 0 {
+			return false
+		}
+		fi, err := os.Stat(src)
+		if err != nil || !copies[copyKey(destFilename(src), fi.Size())] {
+			return false
+		}
+	}
+	return true
+}
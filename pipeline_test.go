@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testFiles writes the files of the given sizes to a temporary folder and
+// returns their paths.
+func testFiles(t *testing.T, sizes ...int) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for i, size := range sizes {
+		path := filepath.Join(dir, fmt.Sprintf("sample%02d.wav", i))
+		if err := os.WriteFile(path, make([]byte, size), 0666); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestGroupStream(t *testing.T) {
+	defer func(v int) { *flagGroupSize = v }(*flagGroupSize)
+	*flagGroupSize = 3
+	files := testFiles(t, 10, 10, 10, 10, 10, 10, 10)
+	units := [][]string{{files[0]}, {files[1], files[2]}, {files[3], files[4]}, {files[5]}, {files[6]}}
+	dest := t.TempDir()
+
+	in := make(chan []string)
+	groups := groupStream(in, filepath.Dir(files[0]), dest, "", layoutFlat, 0)
+	// the first group is sent as soon as it's full, before the walk is over
+	in <- units[0]
+	in <- units[1]
+	in <- units[2]
+	select {
+	case group := <-groups:
+		if group.dir() != filepath.Join(dest, groupFolderName(1)) || len(group.units) != 2 || group.size() != 30 {
+			t.Errorf("first group in %s with %d units of %d bytes; want %s with 2 units of 30 bytes", group.dir(), len(group.units), group.size(), groupFolderName(1))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first group wasn't sent before the end of the walk")
+	}
+	go func() {
+		in <- units[3]
+		in <- units[4]
+		close(in)
+	}()
+	var got [][][]string
+	for group := range groups {
+		got = append(got, group.units)
+	}
+	// a unit is never split across groups
+	want := [][][]string{{units[2], units[3]}, {units[4]}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("groups %v; want %v", got, want)
+	}
+}
+
+func TestGroupStreamMaxSize(t *testing.T) {
+	defer func(v int) { *flagGroupSize = v }(*flagGroupSize)
+	*flagGroupSize = 100
+	files := testFiles(t, 60, 30, 20, 200)
+	in := make(chan []string, len(files))
+	for _, path := range files {
+		in <- []string{path}
+	}
+	close(in)
+	var sizes []int64
+	for group := range groupStream(in, filepath.Dir(files[0]), t.TempDir(), "", layoutFlat, 100) {
+		sizes = append(sizes, group.size())
+	}
+	// a unit bigger than the limit gets a group of its own
+	if fmt.Sprint(sizes) != "[90 20 200]" {
+		t.Errorf("group sizes %v; want [90 20 200]", sizes)
+	}
+}
+
+func TestSpaceCheckedStream(t *testing.T) {
+	dest := t.TempDir()
+	in := make(chan *unitGroup, 2)
+	in <- &unitGroup{folder: dest, idx: 1, unitsSize: 10}
+	in <- &unitGroup{folder: dest, idx: 2, unitsSize: 10}
+	close(in)
+	out, err := spaceCheckedStream(in, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var idx []int
+	for group := range out {
+		idx = append(idx, group.idx)
+	}
+	if fmt.Sprint(idx) != "[1 2]" {
+		t.Errorf("replayed groups %v; want [1 2]", idx)
+	}
+
+	in = make(chan *unitGroup, 1)
+	in <- &unitGroup{folder: dest, idx: 1, unitsSize: 1 << 62}
+	close(in)
+	out, err = spaceCheckedStream(in, dest)
+	if err == nil {
+		t.Error("no error for groups bigger than the volume")
+	}
+	if _, ok := <-out; ok {
+		t.Error("groups replayed when they don't fit")
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	defer func(retries int, delay time.Duration) {
+		*flagRetries, *flagRetryDelay = retries, delay
+	}(*flagRetries, *flagRetryDelay)
+	*flagRetries, *flagRetryDelay = 2, time.Millisecond
+	tests := []struct {
+		name  string
+		err   error
+		calls int
+	}{
+		{"success", nil, 1},
+		{"failure", errors.New("failed"), 3},
+		{"timeout", fileTimeoutError{time.Second}, 1},
+		{"archive write", archiveWriteError{errors.New("failed")}, 1},
+	}
+	for _, tt := range tests {
+		calls := 0
+		err := withRetries(context.Background(), func() error {
+			calls++
+			return tt.err
+		})
+		if err != tt.err || calls != tt.calls {
+			t.Errorf("%s: %d calls returning %v; want %d calls returning %v", tt.name, calls, err, tt.calls, tt.err)
+		}
+	}
+}
+
+func TestGroupStreamSkipsPreviousCopies(t *testing.T) {
+	defer func(v int) { *flagGroupSize = v }(*flagGroupSize)
+	*flagGroupSize = 10
+	files := testFiles(t, 10, 20, 30)
+	dest := t.TempDir()
+	group := filepath.Join(dest, groupFolderName(1))
+	if err := os.Mkdir(group, 0777); err != nil {
+		t.Fatal(err)
+	}
+	// the first file was copied by a previous run, the second one listed in
+	// its manifest, and a file of the same name but another size left
+	if err := os.WriteFile(filepath.Join(group, filepath.Base(files[0])), make([]byte, 10), 0666); err != nil {
+		t.Fatal(err)
+	}
+	renamed := filepath.Join(group, "renamed.wav")
+	if err := os.WriteFile(renamed, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(group, filepath.Base(files[2])), make([]byte, 5), 0666); err != nil {
+		t.Fatal(err)
+	}
+	manifest := fmt.Sprintf(`{"files": [{"source": %q, "destination": %q}]}`, files[1], renamed)
+	if err := os.WriteFile(filepath.Join(dest, manifestFilename), []byte(manifest), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	in := make(chan []string, len(files))
+	for _, path := range files {
+		in <- []string{path}
+	}
+	close(in)
+	var got []string
+	for group := range groupStream(in, filepath.Dir(files[0]), dest, "", layoutFlat, 0) {
+		got = append(got, group.sources()...)
+	}
+	if len(got) != 1 || got[0] != files[2] {
+		t.Errorf("copying %v; want only %s", got, files[2])
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Custom matchers, classifiers and processors can be added without touching the
+// rest of the code by creating a new file in this package registering them from
+// an init function, and enabling them with the -matchers, -classifier and
+// -processors flags:
+//
+//	func init() {
+//		RegisterMatcher("oneshots", oneShotMatcher{maxSize: 2 << 20})
+//	}
+
+// Matcher decides if a file found in the source is a match. Enabled matchers
+// are applied on top of the keyword and filter flags.
+type Matcher interface {
+	Match(path string, fi os.FileInfo) bool
+}
+
+// Classifier returns the category of a file, such as Drums/Kick, used by
+// -classify and the {category} and {family} subfolders placeholders.
+type Classifier interface {
+	Classify(path string) (category string, ok bool)
+}
+
+// Processor transforms the decoded audio of a match before it gets written to
+// the destination. Processors can change the audio, split it in several
+// outputs or drop it.
+type Processor interface {
+	Name() string
+	// Applies reports if the processor has anything to do with the file based on
+	// its header so we only decode the files that need it.
+	Applies(src string, info *audioInfo) bool
+	Process(src string, outputs []audioOutput) ([]audioOutput, error)
+}
+
+var (
+	matchers    = map[string]Matcher{}
+	classifiers = map[string]Classifier{
+		"taxonomy": taxonomyClassifier{},
+	}
+	processors = map[string]Processor{}
+)
+
+// RegisterMatcher makes a matcher available under the given name.
+func RegisterMatcher(name string, m Matcher) {
+	matchers[name] = m
+}
+
+// RegisterClassifier makes a classifier available under the given name.
+func RegisterClassifier(name string, c Classifier) {
+	classifiers[name] = c
+}
+
+// RegisterProcessor makes a processor available under the given name.
+func RegisterProcessor(name string, p Processor) {
+	processors[name] = p
+}
+
+// checkPlugins returns an error if the flags enable plugins that aren't registered.
+func checkPlugins() error {
+	for _, name := range listFlag(*flagMatchers) {
+		if matchers[name] == nil {
+			return fmt.Errorf("unknown matcher %s, available: %s", name, registeredNames(matchers))
+		}
+	}
+	if classifiers[*flagClassifier] == nil {
+		return fmt.Errorf("unknown classifier %s, available: %s", *flagClassifier, registeredNames(classifiers))
+	}
+	for _, name := range listFlag(*flagProcessors) {
+		if processors[name] == nil {
+			return fmt.Errorf("unknown processor %s, available: %s", name, registeredNames(processors))
+		}
+	}
+	return nil
+}
+
+// registeredNames returns the sorted names of the registered plugins.
+func registeredNames[T any](registry map[string]T) string {
+	names := []string{}
+	for name := range registry {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// listFlag splits a comma separated flag value, ignoring empty values.
+func listFlag(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// matchesPlugins reports if all the enabled matchers accept the file.
+func matchesPlugins(path string, fi os.FileInfo) bool {
+	for _, name := range listFlag(*flagMatchers) {
+		if !matchers[name].Match(path, fi) {
+			return false
+		}
+	}
+	return true
+}
+
+// classify returns the category of the file at path using the selected classifier.
+func classify(path string) (string, bool) {
+	return classifiers[*flagClassifier].Classify(path)
+}
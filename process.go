@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// audioOutput is decoded audio to be written to the destination, suffix is
+// appended to the stem of the destination filename.
+type audioOutput struct {
+	suffix string
+	buf    *audioBuffer
+	// rootNote and pitchShift describe the repitching applied to the output
+	rootNote   string
+	pitchShift float64
+}
+
+// audioTransform modifies the decoded audio of a match before it gets written
+// to the destination.
+type audioTransform struct {
+	name string
+	// applies reports if the transformation has anything to do with a file
+	// based on its header so we only decode files that need it.
+	applies func(src string, info *audioInfo) bool
+	apply   func(src string, outputs []audioOutput) ([]audioOutput, error)
+}
+
+// Name returns the name of the transformation.
+func (t audioTransform) Name() string { return t.name }
+
+// Applies reports if the transformation has anything to do with the file.
+func (t audioTransform) Applies(src string, info *audioInfo) bool { return t.applies(src, info) }
+
+// Process applies the transformation to the outputs.
+func (t audioTransform) Process(src string, outputs []audioOutput) ([]audioOutput, error) {
+	return t.apply(src, outputs)
+}
+
+// enabledTransforms returns the audio transformations enabled by the flags in
+// the order they need to be applied, followed by the registered processors
+// enabled with -processors.
+func enabledTransforms() []Processor {
+	var transforms []Processor
+	if *flagFloatToPCM {
+		transforms = append(transforms, audioTransform{
+			name: "24 bit PCM conversion",
+			applies: func(src string, info *audioInfo) bool {
+				return info.container == "WAVE" && info.float
+			},
+			apply: eachOutput(func(out audioOutput) []audioOutput {
+				if out.buf.float {
+					out.buf.bitDepth, out.buf.float = 24, false
+				}
+				return []audioOutput{out}
+			}),
+		})
+	}
+	if *flagFoldFakeStereo {
+		transforms = append(transforms, audioTransform{
+			name: "fake stereo folding",
+			applies: func(src string, info *audioInfo) bool {
+				return info.channels == 2 && fakeStereo(src)
+			},
+			apply: eachOutput(foldToMono),
+		})
+	}
+	if *flagStretchTo > 0 {
+		transforms = append(transforms, audioTransform{
+			name: "time stretch",
+			applies: func(src string, info *audioInfo) bool {
+				_, ok := sourceTempo(src, int(info.dataSize)/max(info.blockAlign, 1), info.sampleRate)
+				return ok
+			},
+			apply: stretchToTempo,
+		})
+	}
+	if *flagRepitchTo != "" {
+		transforms = append(transforms, audioTransform{
+			name: "repitching",
+			applies: func(src string, info *audioInfo) bool {
+				root, ok := sourceRootNote(src, info)
+				target, _ := parseNote(*flagRepitchTo)
+				return ok && semitonesTo(root, target) != 0
+			},
+			apply: repitch,
+		})
+	}
+	if *flagSplitStereo {
+		transforms = append(transforms, audioTransform{
+			name: "stereo split",
+			applies: func(src string, info *audioInfo) bool {
+				return info.channels == 2
+			},
+			apply: eachOutput(splitStereo),
+		})
+	}
+	if *flagSplitSilence {
+		transforms = append(transforms, audioTransform{
+			name:    "silence split",
+			applies: func(src string, info *audioInfo) bool { return true },
+			apply:   eachOutput(splitAtSilences),
+		})
+	}
+	if *flagChop {
+		transforms = append(transforms, audioTransform{
+			name:    "transient chopping",
+			applies: func(src string, info *audioInfo) bool { return true },
+			apply:   eachOutput(chop),
+		})
+	}
+	for _, name := range listFlag(*flagProcessors) {
+		transforms = append(transforms, processors[name])
+	}
+	return transforms
+}
+
+// durationToFrames converts a duration to a number of frames at the sample rate.
+func durationToFrames(d time.Duration, sampleRate int) int {
+	return int(d.Seconds() * float64(sampleRate))
+}
+
+// eachOutput turns a function processing a single output into a transformation.
+func eachOutput(fn func(out audioOutput) []audioOutput) func(string, []audioOutput) ([]audioOutput, error) {
+	return func(src string, outputs []audioOutput) ([]audioOutput, error) {
+		var processed []audioOutput
+		for _, out := range outputs {
+			processed = append(processed, fn(out)...)
+		}
+		return processed, nil
+	}
+}
+
+// transformsApply reports if any of the transformations applies to the file.
+func transformsApply(transforms []Processor, src string, info *audioInfo) bool {
+	for _, t := range transforms {
+		if t.Applies(src, info) {
+			return true
+		}
+	}
+	return false
+}
+
+// transformAudioFile decodes src, applies the transformations and writes the
+// resulting file(s) next to dst, the outputs suffixed like the slices getting
+// a name unique among the used names of the folder.
+func transformAudioFile(src, dst string, used map[string]bool, transforms []Processor) error {
+	buf, _, err := decodeAudioFile(src)
+	if err != nil {
+		return err
+	}
+	outputs := []audioOutput{{buf: buf}}
+	for _, t := range transforms {
+		debugf("Applying %s to %s", t.Name(), src)
+		if outputs, err = t.Process(src, outputs); err != nil {
+			return fmt.Errorf("%s failed - %s", t.Name(), err)
+		}
+	}
+	dir, ext := filepath.Dir(dst), filepath.Ext(dst)
+	stem := strings.TrimSuffix(filepath.Base(dst), ext)
+	for _, out := range outputs {
+		path := dst
+		if out.suffix != "" {
+			path = filepath.Join(dir, uniqueDestName(dir, stem+out.suffix+ext, used))
+		}
+		if err := writeAudioFile(path, out.buf); err != nil {
+			return err
+		}
+		recordFile(manifestEntry{Source: src, Destination: path, RootNote: out.rootNote, PitchShift: out.pitchShift})
+	}
+	return nil
+}
+
+// splitStereo splits a stereo output into 2 mono _L and _R outputs.
+func splitStereo(out audioOutput) []audioOutput {
+	if out.buf.channels != 2 {
+		return []audioOutput{out}
+	}
+	left, right := out.buf.mono(), out.buf.mono()
+	for i := 0; i < out.buf.frames(); i++ {
+		left.data[i] = out.buf.data[i*2]
+		right.data[i] = out.buf.data[i*2+1]
+	}
+	return []audioOutput{
+		{suffix: out.suffix + "_L", buf: left},
+		{suffix: out.suffix + "_R", buf: right},
+	}
+}
+
+// foldedStats counts the fake stereo files folded to mono and the bytes of
+// audio it saved, for the run summary.
+var foldedStats struct {
+	files, saved atomic.Int64
+}
+
+// foldToMono folds a stereo output with the same audio on both channels into
+// a mono output.
+func foldToMono(out audioOutput) []audioOutput {
+	if out.buf.channels != 2 {
+		return []audioOutput{out}
+	}
+	mono := out.buf.mono()
+	for i := range mono.data {
+		mono.data[i] = (out.buf.data[i*2] + out.buf.data[i*2+1]) / 2
+	}
+	foldedStats.files.Add(1)
+	foldedStats.saved.Add(int64(mono.frames() * ((mono.bitDepth + 7) / 8)))
+	out.buf = mono
+	return []audioOutput{out}
+}
+
+// chop slices an output at its transients, each slice becoming its own output
+// suffixed by its number. Outputs with less than 2 transients are left untouched.
+func chop(out audioOutput) []audioOutput {
+	onsets := detectOnsets(out.buf, *flagChopThreshold)
+	if len(onsets) < 2 {
+		return []audioOutput{out}
+	}
+	slices := make([]audioOutput, 0, len(onsets))
+	for i, start := range onsets {
+		end := out.buf.frames()
+		if i+1 < len(onsets) {
+			end = onsets[i+1]
+		}
+		slice := out.buf.slice(start, end)
+		// 5ms fade out so the slices don't click
+		slice.fadeOut(slice.sampleRate / 200)
+		slices = append(slices, audioOutput{suffix: fmt.Sprintf("%s_%02d", out.suffix, i+1), buf: slice})
+	}
+	return slices
+}
+
+// splitAtSilences splits an output at the silent gaps it contains, each segment
+// becoming its own output suffixed by its number. Segments shorter than the
+// minimum segment length are dropped.
+func splitAtSilences(out audioOutput) []audioOutput {
+	segments := nonSilentSegments(out.buf, *flagSilenceThreshold,
+		durationToFrames(*flagMinSilence, out.buf.sampleRate))
+	minLength := durationToFrames(*flagMinSegment, out.buf.sampleRate)
+	outputs := []audioOutput{}
+	for _, seg := range segments {
+		if seg[1]-seg[0] < minLength {
+			continue
+		}
+		slice := out.buf.slice(seg[0], seg[1])
+		slice.fadeOut(slice.sampleRate / 200)
+		outputs = append(outputs, audioOutput{suffix: fmt.Sprintf("%s_%02d", out.suffix, len(outputs)+1), buf: slice})
+	}
+	if len(outputs) < 2 {
+		// nothing to split
+		return []audioOutput{out}
+	}
+	return outputs
+}
+
+// stretchToTempo time stretches the outputs of a loop to the target tempo,
+// leaving them untouched when it would more than halve or double their length.
+func stretchToTempo(src string, outputs []audioOutput) ([]audioOutput, error) {
+	for i, out := range outputs {
+		tempo, ok := sourceTempo(src, out.buf.frames(), out.buf.sampleRate)
+		if !ok || math.Abs(tempo-*flagStretchTo) < 0.01 {
+			continue
+		}
+		ratio := tempo / *flagStretchTo
+		if ratio < 0.5 || ratio > 2 {
+			warnf("Not stretching %s, %.1f BPM is too far from the %.1f BPM target", src, tempo, *flagStretchTo)
+			continue
+		}
+		debugf("Stretching %s from %.1f to %.1f BPM", src, tempo, *flagStretchTo)
+		outputs[i].buf = timeStretch(out.buf, ratio)
+	}
+	return outputs, nil
+}
+
+// repitch resamples the outputs so their root note becomes the target note.
+func repitch(src string, outputs []audioOutput) ([]audioOutput, error) {
+	info, err := readAudioInfo(src)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := sourceRootNote(src, info)
+	if !ok {
+		return outputs, nil
+	}
+	target, _ := parseNote(*flagRepitchTo)
+	shift := semitonesTo(root, target)
+	if shift == 0 {
+		return outputs, nil
+	}
+	debugf("Repitching %s by %d semitones from %s to %s", src, shift, noteName(root), *flagRepitchTo)
+	for i, out := range outputs {
+		outputs[i].buf = resample(out.buf, math.Pow(2, float64(shift)/12))
+		outputs[i].buf.setRootNote(root+shift, 1/math.Pow(2, float64(shift)/12))
+		outputs[i].rootNote = noteName(root)
+		outputs[i].pitchShift = float64(shift)
+	}
+	return outputs, nil
+}
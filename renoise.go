@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// renoiseExt is the extension of the Renoise instruments.
+const renoiseExt = ".xrni"
+
+// renoiseDocVersion is the version of the Instrument.xml layout written, the
+// one of Renoise 3 with the samples in a SampleGenerator. Renoise upgrades
+// the documents older than its own.
+const renoiseDocVersion = "13"
+
+// renoiseInstrument is the Instrument.xml document of a Renoise instrument.
+type renoiseInstrument struct {
+	XMLName         xml.Name       `xml:"RenoiseInstrument"`
+	DocVersion      string         `xml:"doc_version,attr"`
+	Name            string         `xml:"Name"`
+	SampleGenerator renoiseSamples `xml:"SampleGenerator"`
+}
+
+// renoiseSamples are the samples of a Renoise instrument.
+type renoiseSamples struct {
+	Samples []renoiseSample `xml:"Samples>Sample"`
+	// KeyzoneOverlappingMode is Cycle for the round robin variations
+	// sharing a zone to be played in turn instead of all together
+	KeyzoneOverlappingMode string `xml:"KeyzoneOverlappingMode"`
+}
+
+// renoiseSample is a sample of a Renoise instrument with its key zone.
+type renoiseSample struct {
+	Name      string `xml:"Name"`
+	Volume    string `xml:"Volume"`
+	Panning   string `xml:"Panning"`
+	Transpose int    `xml:"Transpose"`
+	Finetune  int    `xml:"Finetune"`
+	// LoopMode is Off or Forward, the loop bounds being frames from 1, the end included
+	LoopMode  string `xml:"LoopMode"`
+	LoopStart int64  `xml:"LoopStart"`
+	LoopEnd   int64  `xml:"LoopEnd"`
+	Mapping   struct {
+		Layer         string `xml:"Layer"`
+		BaseNote      int    `xml:"BaseNote"`
+		NoteStart     int    `xml:"NoteStart"`
+		NoteEnd       int    `xml:"NoteEnd"`
+		VelocityStart int    `xml:"VelocityStart"`
+		VelocityEnd   int    `xml:"VelocityEnd"`
+	} `xml:"Mapping"`
+}
+
+// renoiseNote converts a MIDI note to a Renoise note, C-4 (48) being the MIDI
+// middle C (60) and B-9 the highest note.
+func renoiseNote(note int) int {
+	return min(max(note-12, 0), 119)
+}
+
+// renoiseSampleOf returns the Renoise sample of a zone of a sampler patch.
+func renoiseSampleOf(z samplerZone) renoiseSample {
+	var r renoiseSample
+	name := path.Base(z.File)
+	r.Name = strings.TrimSuffix(name, path.Ext(name))
+	r.Volume, r.Panning, r.LoopMode = "1.0", "0.5", "Off"
+	if z.LoopEnd > 0 {
+		r.LoopMode, r.LoopStart, r.LoopEnd = "Forward", z.LoopStart+1, z.LoopEnd
+	}
+	r.Mapping.Layer = "Note-On"
+	r.Mapping.BaseNote = renoiseNote(z.Root)
+	r.Mapping.NoteStart, r.Mapping.NoteEnd = renoiseNote(z.KeyLow), renoiseNote(z.KeyHigh)
+	r.Mapping.VelocityStart, r.Mapping.VelocityEnd = z.VelocityLow, z.VelocityHigh
+	return r
+}
+
+// writeRenoiseInstrument writes a Renoise .xrni of the group folder dir named
+// after it, the Instrument.xml mapping its samples like writeSamplerPatch and
+// the samples in its SampleData folder zipped together.
+func writeRenoiseInstrument(dir string) error {
+	zones, err := groupZones(dir)
+	if err != nil || len(zones) == 0 {
+		return err
+	}
+	name := filepath.Base(dir)
+	doc := renoiseInstrument{DocVersion: renoiseDocVersion, Name: name}
+	doc.SampleGenerator.KeyzoneOverlappingMode = "Cycle"
+	// Renoise finds the samples by their index in the SampleData folder
+	srcs := make([]string, len(zones))
+	entries := make([]string, len(zones))
+	for i, z := range zones {
+		sample := renoiseSampleOf(z)
+		doc.SampleGenerator.Samples = append(doc.SampleGenerator.Samples, sample)
+		srcs[i] = filepath.Join(dir, filepath.FromSlash(z.File))
+		entries[i] = fmt.Sprintf("SampleData/Sample%02d (%s)%s", i, sample.Name, filepath.Ext(z.File))
+	}
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	dest := filepath.Join(dir, name+renoiseExt)
+	if err := writeInstrumentZip(dest, "Instrument.xml", data, srcs, entries); err != nil {
+		return err
+	}
+	fileLog.Printf("Wrote the Renoise instrument %s of %d files", dest, len(zones))
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteRenoiseInstrument(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "001")
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	wav, err := os.ReadFile(testWav(t, wavFormatPCM, 1, 44100, 2, 16, make([]byte, 8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"kick.wav", "snare_rr1.wav", "snare_rr2.wav"} {
+		if err := os.WriteFile(filepath.Join(dir, name), wav, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeRenoiseInstrument(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.OpenReader(filepath.Join(dir, "001"+renoiseExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var doc renoiseInstrument
+	var entries []string
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		if f.Name == "Instrument.xml" {
+			if err := xml.Unmarshal(content, &doc); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if string(content) != string(wav) {
+			t.Errorf("%s doesn't hold the sample of the group folder", f.Name)
+		}
+		entries = append(entries, f.Name)
+	}
+	want := []string{"SampleData/Sample00 (kick).wav", "SampleData/Sample01 (snare_rr1).wav", "SampleData/Sample02 (snare_rr2).wav"}
+	if len(entries) != len(want) {
+		t.Fatalf("entries %v; want %v", entries, want)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d is %s; want %s", i, entries[i], want[i])
+		}
+	}
+	if doc.Name != "001" || doc.SampleGenerator.KeyzoneOverlappingMode != "Cycle" {
+		t.Errorf("instrument %q with the overlap mode %q; want 001 cycling its zones", doc.Name, doc.SampleGenerator.KeyzoneOverlappingMode)
+	}
+	// the round robin variations share their key zone
+	if samples := doc.SampleGenerator.Samples; len(samples) != 3 || samples[1].Mapping.NoteStart != samples[2].Mapping.NoteStart || samples[0].Mapping.NoteStart == samples[1].Mapping.NoteStart {
+		t.Errorf("samples %+v; want the snare variations on the same key", samples)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// repairable reports if the problems of the file can be fixed by rewriting its
+// chunk sizes, meaning that we know the format and some audio data is present.
+func (info *audioInfo) repairable() bool {
+	return info.supportedCodec() && info.dataOffset > 0 && info.blockAlign > 0 &&
+		info.fileSize-info.dataOffset >= int64(info.blockAlign)
+}
+
+// repairAudioFile writes a copy of the src audio file to dst with the container
+// and audio chunk sizes matching the data actually present, and the fact chunk
+// required by non-PCM WAV files. Chunks cut off by a truncation are dropped.
+func repairAudioFile(info *audioInfo, src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var order binary.ByteOrder = binary.LittleEndian
+	magic := "RIFF"
+	if info.container != "WAVE" {
+		order = binary.BigEndian
+		magic = "FORM"
+	}
+
+	dataSize := info.dataSize
+	if available := info.fileSize - info.dataOffset; dataSize > available || dataSize <= 0 {
+		dataSize = available
+	}
+	dataSize -= dataSize % int64(info.blockAlign)
+	frames := dataSize / int64(info.blockAlign)
+
+	// rebuild the chunks in memory except for the audio data which gets streamed
+	var head bytes.Buffer
+	head.WriteString(info.container)
+	writeChunkHeader := func(buf *bytes.Buffer, id string, size int64) {
+		buf.WriteString(id)
+		binary.Write(buf, order, uint32(size))
+	}
+	var tail bytes.Buffer
+	buf := &head
+	for _, c := range info.chunks {
+		switch c.id {
+		case "data":
+			if info.formatTag == wavFormatFloat && info.chunk("fact") == nil {
+				writeChunkHeader(buf, "fact", 4)
+				binary.Write(buf, order, uint32(frames))
+			}
+			writeChunkHeader(buf, "data", dataSize)
+			buf = &tail
+			continue
+		case "SSND":
+			skip := info.dataOffset - c.offset - 16
+			writeChunkHeader(buf, "SSND", 8+skip+dataSize)
+			body := make([]byte, 8+skip)
+			if _, err := in.ReadAt(body, c.offset+8); err != nil {
+				return err
+			}
+			buf.Write(body)
+			buf = &tail
+			continue
+		}
+		if c.offset+8+c.size > info.fileSize {
+			// cut off by a truncation
+			continue
+		}
+		body := make([]byte, c.size)
+		if _, err := in.ReadAt(body, c.offset+8); err != nil {
+			return err
+		}
+		if c.id == "COMM" && len(body) >= 6 {
+			binary.BigEndian.PutUint32(body[2:], uint32(frames))
+		}
+		writeChunkHeader(buf, c.id, c.size)
+		buf.Write(body)
+		if c.size%2 == 1 {
+			buf.WriteByte(0)
+		}
+	}
+
+	out, err := createDestFile(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		cerr := closeDestFile(out)
+		if err == nil {
+			err = cerr
+		}
+	}()
+	pad := dataSize % 2
+	if _, err = out.WriteString(magic); err != nil {
+		return err
+	}
+	if err = binary.Write(out, order, uint32(int64(head.Len())+dataSize+pad+int64(tail.Len()))); err != nil {
+		return err
+	}
+	if _, err = head.WriteTo(out); err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, io.NewSectionReader(in, info.dataOffset, dataSize)); err != nil {
+		return err
+	}
+	if pad == 1 {
+		if _, err = out.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	if _, err = tail.WriteTo(out); err != nil {
+		return err
+	}
+	return out.Sync()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepairAudioFile(t *testing.T) {
+	samples := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	tests := []struct {
+		name string
+		file func(t *testing.T) string
+		// data is the audio data expected in the repaired file
+		data []byte
+	}{
+		{"truncated wav", func(t *testing.T) string {
+			path := testWav(t, wavFormatPCM, 2, 44100, 4, 16, samples)
+			// half a frame cut off
+			if err := os.Truncate(path, 44+10); err != nil {
+				t.Fatal(err)
+			}
+			return path
+		}, samples[:8]},
+		{"wrong riff size", func(t *testing.T) string {
+			path := testWav(t, wavFormatPCM, 2, 44100, 4, 16, samples)
+			f, err := os.OpenFile(path, os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			f.WriteAt([]byte{0xff, 0xff, 0xff, 0x7f}, 4)
+			return path
+		}, samples},
+		{"float wav without fact", func(t *testing.T) string {
+			return testWav(t, wavFormatFloat, 1, 44100, 4, 32, samples)
+		}, samples},
+		{"wav with a chunk cut off", func(t *testing.T) string {
+			path := testWav(t, wavFormatPCM, 2, 44100, 4, 16, samples)
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			f.Write([]byte("LIST\x40\x00\x00\x00INFO"))
+			return path
+		}, samples},
+		{"truncated aiff", func(t *testing.T) string {
+			path := testAiff(t, 1, 6, 16, 44100, 0, samples)
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(path, fi.Size()-5); err != nil {
+				t.Fatal(err)
+			}
+			return path
+		}, samples[:6]},
+	}
+	for _, tt := range tests {
+		src := tt.file(t)
+		info, err := readAudioInfo(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.needsRepair() {
+			t.Errorf("%s: not repaired", tt.name)
+			continue
+		}
+		dst := filepath.Join(t.TempDir(), "repaired"+filepath.Ext(src))
+		if err := repairAudioFile(info, src, dst); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		repaired, err := readAudioInfo(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if problems := append(repaired.problems(), repaired.quirks()...); len(problems) > 0 {
+			t.Errorf("%s: the repaired file has problems: %s", tt.name, strings.Join(problems, ", "))
+		}
+		data, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := data[repaired.dataOffset : repaired.dataOffset+repaired.dataSize]; !bytes.Equal(got, tt.data) {
+			t.Errorf("%s: audio data %v; want %v", tt.name, got, tt.data)
+		}
+		size := int64(binary.LittleEndian.Uint32(data[4:]))
+		if repaired.container != "WAVE" {
+			size = int64(binary.BigEndian.Uint32(data[4:]))
+		}
+		if size != int64(len(data)-8) {
+			t.Errorf("%s: container size %d; want %d", tt.name, size, len(data)-8)
+		}
+	}
+}
+
+func TestRepairable(t *testing.T) {
+	path := testWav(t, wavFormatPCM, 2, 44100, 4, 16, nil)
+	if err := os.Truncate(path, 36); err != nil {
+		t.Fatal(err)
+	}
+	info, err := readAudioInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.repairable() {
+		t.Error("a file without audio data is repairable")
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// rightsFilename is the report of the rights holders written with -rightsReport.
+const rightsFilename = "RIGHTS.CSV"
+
+// maxMetadataChunkSize bounds the size of the metadata chunks read for the
+// rights, larger ones are likely artwork or corrupt.
+const maxMetadataChunkSize = 1 << 20
+
+// rightsInfo are the artist and copyright found in the metadata of a file.
+type rightsInfo struct {
+	artist, copyright string
+}
+
+// readRights returns the artist and copyright of the WAV or AIFF file at
+// path, from the INFO, bext and ID3 chunks of the WAV files or the AUTH, (c)
+// and ID3 chunks of the AIFF files. The bext originator is only used when
+// there's no artist.
+func readRights(path string) (rightsInfo, error) {
+	var r rightsInfo
+	info, err := readAudioInfo(path)
+	if err != nil {
+		return r, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return r, err
+	}
+	defer f.Close()
+	var originator string
+	for _, c := range info.chunks {
+		switch c.id {
+		case "LIST", "bext", "id3 ", "ID3 ", "AUTH", "(c) ":
+		default:
+			continue
+		}
+		if c.size > maxMetadataChunkSize || c.offset+8+c.size > info.fileSize {
+			continue
+		}
+		body := make([]byte, c.size)
+		if _, err := f.ReadAt(body, c.offset+8); err != nil {
+			return r, err
+		}
+		switch c.id {
+		case "LIST":
+			parseInfoList(body, &r)
+		case "bext":
+			// the originator follows the 256 bytes of description
+			if len(body) >= 288 {
+				originator = cleanText(string(body[256:288]))
+			}
+		case "id3 ", "ID3 ":
+			parseID3(body, &r)
+		case "AUTH":
+			setOnce(&r.artist, cleanText(string(body)))
+		case "(c) ":
+			setOnce(&r.copyright, cleanText(string(body)))
+		}
+	}
+	setOnce(&r.artist, originator)
+	return r, nil
+}
+
+// setOnce sets field to value if it's still empty.
+func setOnce(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+// cleanText trims the padding and spaces around a metadata value.
+func cleanText(s string) string {
+	return strings.TrimSpace(strings.Trim(s, "\x00"))
+}
+
+// parseInfoList reads the artist (IART) and copyright (ICOP) of a LIST INFO chunk.
+func parseInfoList(b []byte, r *rightsInfo) {
+	if len(b) < 4 || string(b[:4]) != "INFO" {
+		return
+	}
+	for pos := 4; pos+8 <= len(b); {
+		id, size := string(b[pos:pos+4]), int(binary.LittleEndian.Uint32(b[pos+4:]))
+		if size < 0 || pos+8+size > len(b) {
+			return
+		}
+		value := cleanText(string(b[pos+8 : pos+8+size]))
+		switch id {
+		case "IART":
+			setOnce(&r.artist, value)
+		case "ICOP":
+			setOnce(&r.copyright, value)
+		}
+		pos += 8 + size + size%2
+	}
+}
+
+// parseID3 reads the artist (TPE1) and copyright (TCOP) frames of an ID3v2 tag.
+func parseID3(b []byte, r *rightsInfo) {
+	if len(b) < 10 || string(b[:3]) != "ID3" {
+		return
+	}
+	major, flags := b[3], b[5]
+	end := 10 + synchsafe(b[6:10])
+	if end > len(b) {
+		end = len(b)
+	}
+	pos := 10
+	if flags&0x40 != 0 && major >= 3 && pos+4 <= end {
+		// the extended header
+		if major == 3 {
+			pos += 4 + int(binary.BigEndian.Uint32(b[pos:]))
+		} else {
+			pos += synchsafe(b[pos : pos+4])
+		}
+	}
+	artistID, copyrightID, idSize, headerSize := "TPE1", "TCOP", 4, 10
+	if major == 2 {
+		artistID, copyrightID, idSize, headerSize = "TP1", "TCR", 3, 6
+	}
+	for pos+headerSize <= end && b[pos] != 0 {
+		id := string(b[pos : pos+idSize])
+		var size int
+		switch major {
+		case 2:
+			size = int(b[pos+3])<<16 | int(b[pos+4])<<8 | int(b[pos+5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(b[pos+4:]))
+		default:
+			size = synchsafe(b[pos+4 : pos+8])
+		}
+		start := pos + headerSize
+		if size < 0 || start+size > end {
+			return
+		}
+		switch id {
+		case artistID:
+			setOnce(&r.artist, id3Text(b[start:start+size]))
+		case copyrightID:
+			setOnce(&r.copyright, id3Text(b[start:start+size]))
+		}
+		pos = start + size
+	}
+}
+
+// synchsafe decodes an ID3v2 size made of 7 bit bytes.
+func synchsafe(b []byte) int {
+	size := 0
+	for _, v := range b {
+		size = size<<7 | int(v&0x7F)
+	}
+	return size
+}
+
+// id3Text decodes an ID3v2 text frame, the values of a multi valued frame are
+// joined with commas.
+func id3Text(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	var s string
+	switch enc, data := b[0], b[1:]; enc {
+	case 1, 2:
+		bigEndian := enc == 2
+		if len(data) >= 2 && (data[0] == 0xFE && data[1] == 0xFF || data[0] == 0xFF && data[1] == 0xFE) {
+			bigEndian = data[0] == 0xFE
+			data = data[2:]
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = binary.BigEndian.Uint16(data[i*2:])
+			} else {
+				units[i] = binary.LittleEndian.Uint16(data[i*2:])
+			}
+		}
+		s = string(utf16.Decode(units))
+	case 3:
+		s = string(data)
+	default:
+		// Latin-1
+		runes := make([]rune, len(data))
+		for i, c := range data {
+			runes[i] = rune(c)
+		}
+		s = string(runes)
+	}
+	var values []string
+	for _, v := range strings.Split(s, "\x00") {
+		if v = cleanText(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+// writeRightsReport writes the RIGHTS.CSV report to dir, listing for each group
+// folder of destPath the artists and copyrights found in the metadata of the
+// sources of its files, with their file counts. The files without any are
+// counted on rows with empty artist and copyright.
+func writeRightsReport(dir, destPath string) error {
+	type holder struct {
+		group string
+		rightsInfo
+	}
+	counts := map[holder]int{}
+	for _, entry := range recordedFiles() {
+		group, err := filepath.Rel(destPath, filepath.Dir(entry.Destination))
+		if err != nil {
+			group = filepath.Dir(entry.Destination)
+		}
+		r, err := readRights(entry.Source)
+		if err != nil {
+			debugf("Couldn't read the rights of %s - %s", entry.Source, err)
+		}
+		counts[holder{filepath.ToSlash(group), r}]++
+	}
+	holders := make([]holder, 0, len(counts))
+	for h := range counts {
+		holders = append(holders, h)
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		a, b := holders[i], holders[j]
+		if a.group != b.group {
+			return a.group < b.group
+		}
+		if a.artist != b.artist {
+			return a.artist < b.artist
+		}
+		return a.copyright < b.copyright
+	})
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write([]string{"group", "artist", "copyright", "files"})
+	for _, h := range holders {
+		w.Write([]string{h.group, h.artist, h.copyright, fmt.Sprint(counts[h])})
+	}
+	w.Flush()
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, rightsFilename), []byte(b.String()), 0666)
+}
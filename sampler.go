@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// samplerPatchName is the name of the sampler patch files without their extension.
+const samplerPatchName = "patch"
+
+// samplerPatchFormats are the extensions of the sampler patch files by
+// -samplerPatch format.
+var samplerPatchFormats = map[string]string{
+	"json":     ".json",
+	"xml":      ".xml",
+	"sfz":      ".sfz",
+	"dspreset": ".dspreset",
+}
+
+// isSamplerPatch reports if the file of a group folder is its sampler patch.
+func isSamplerPatch(name string) bool {
+	for _, ext := range samplerPatchFormats {
+		if name == samplerPatchName+ext {
+			return true
+		}
+	}
+	return false
+}
+
+// maxLayerIndex is the highest velocity name taken for the number of a layer
+// rather than a MIDI velocity, v3 being the third layer but vel064 velocity 64.
+const maxLayerIndex = 16
+
+// samplerPatch maps the samples of a group folder on the keyboard, in a
+// format simple enough for any sampler or script to load.
+type samplerPatch struct {
+	XMLName xml.Name      `json:"-" xml:"patch"`
+	Name    string        `json:"name" xml:"name,attr"`
+	Zones   []samplerZone `json:"zones" xml:"zone"`
+}
+
+// samplerZone maps a sample to a range of notes and velocities.
+type samplerZone struct {
+	// File is the path of the sample relative to the patch
+	File string `json:"file" xml:"file,attr"`
+	// Root is the note played at the original pitch of the sample
+	Root         int `json:"root" xml:"root,attr"`
+	KeyLow       int `json:"keyLow" xml:"keyLow,attr"`
+	KeyHigh      int `json:"keyHigh" xml:"keyHigh,attr"`
+	VelocityLow  int `json:"velocityLow" xml:"velocityLow,attr"`
+	VelocityHigh int `json:"velocityHigh" xml:"velocityHigh,attr"`
+	// RoundRobin is the number of the variation, from 1, of the zones
+	// played in turn on the same notes and velocities
+	RoundRobin int   `json:"roundRobin,omitempty" xml:"roundRobin,attr,omitempty"`
+	Frames     int64 `json:"frames" xml:"frames,attr"`
+	// LoopStart and LoopEnd are the sustain loop from the smpl chunk, the end
+	// being exclusive, no loop when LoopEnd is 0
+	LoopStart int64 `json:"loopStart,omitempty" xml:"loopStart,attr,omitempty"`
+	LoopEnd   int64 `json:"loopEnd,omitempty" xml:"loopEnd,attr,omitempty"`
+}
+
+// readZoneAudio fills in the length and loop of the zone from the header of
+// the WAV or AIFF file at path, and returns its root note when it has one.
+func readZoneAudio(z *samplerZone, path string) (note int, hasNote bool, err error) {
+	info, err := readAudioInfo(path)
+	if err != nil {
+		return 0, false, err
+	}
+	z.Frames = info.frames
+	if info.container == "WAVE" && info.blockAlign > 0 {
+		z.Frames = info.dataSize / int64(info.blockAlign)
+	}
+	if start, end, ok := smplLoop(path, info); ok {
+		z.LoopStart, z.LoopEnd = start, end
+	}
+	note, hasNote = sourceRootNote(path, info)
+	return note, hasNote, nil
+}
+
+// sampleSet returns the name shared by the files of the unit when they make
+// a multisample set, velocity layers or round robin variations.
+func sampleSet(unit []string) (string, bool) {
+	if len(unit) < 2 {
+		return "", false
+	}
+	key, _, _, _, ok := multisampleKey(unit[0])
+	if !ok {
+		return "", false
+	}
+	for _, path := range unit[1:] {
+		if k, _, _, _, ok := multisampleKey(path); !ok || k != key {
+			return "", false
+		}
+	}
+	stem := strings.TrimSuffix(filepath.Base(unit[0]), filepath.Ext(unit[0]))
+	name, _, _, _, _, _ := sampleSetName(stem)
+	if name = cleanSetName(name); name == "" {
+		name = stem
+	}
+	return name, true
+}
+
+// splitRange spreads the range low-high over the sorted values, each value
+// getting the part of the range up to the midpoint with the next one.
+func splitRange(values []int, low, high int) (lows, highs []int) {
+	for i, v := range values {
+		lows = append(lows, low)
+		if i+1 < len(values) {
+			low = (v+values[i+1])/2 + 1
+			highs = append(highs, low-1)
+		} else {
+			highs = append(highs, high)
+		}
+	}
+	return lows, highs
+}
+
+// sampleSetZones returns the zones of the files of a sample set: the notes
+// from their smpl or INST chunk or their filename spread over the keyboard,
+// their velocity layers over the velocities and their variations played in
+// turn. The files without a note span the whole keyboard from C3.
+func sampleSetZones(unit []string) ([]samplerZone, error) {
+	type member struct {
+		note, velocity, variation int
+		hasNote                   bool
+	}
+	members := make([]member, len(unit))
+	zones := make([]samplerZone, len(unit))
+	var notes []int
+	for i, src := range unit {
+		zones[i].File = filepath.Base(src)
+		note, hasNote, err := readZoneAudio(&zones[i], src)
+		if err != nil {
+			return nil, err
+		}
+		_, _, velocity, variation, _ := multisampleKey(src)
+		members[i] = member{note, velocity, variation, hasNote}
+		if hasNote {
+			notes = append(notes, note)
+		}
+	}
+	sort.Ints(notes)
+	notes = uniqueInts(notes)
+	lows, highs := splitRange(notes, 0, 127)
+	for i, m := range members {
+		z := &zones[i]
+		z.Root, z.KeyLow, z.KeyHigh = 60, 0, 127
+		if m.hasNote {
+			n := sort.SearchInts(notes, m.note)
+			z.Root, z.KeyLow, z.KeyHigh = m.note, lows[n], highs[n]
+		}
+		// the velocity layers of the note
+		var velocities []int
+		variations := 0
+		for _, other := range members {
+			if other.hasNote == m.hasNote && other.note == m.note {
+				velocities = append(velocities, other.velocity)
+				if other.velocity == m.velocity {
+					variations++
+				}
+			}
+		}
+		sort.Ints(velocities)
+		velocities = uniqueInts(velocities)
+		vlows, vhighs := splitRange(velocities, 1, 127)
+		if last := velocities[len(velocities)-1]; last <= maxLayerIndex {
+			// layers numbered like v1, v2 and v3 share the velocities evenly
+			for l := range velocities {
+				vlows[l], vhighs[l] = l*127/len(velocities)+1, (l+1)*127/len(velocities)
+			}
+		}
+		v := sort.SearchInts(velocities, m.velocity)
+		z.VelocityLow, z.VelocityHigh = vlows[v], vhighs[v]
+		if variations > 1 {
+			z.RoundRobin = max(m.variation, 1)
+		}
+	}
+	return zones, nil
+}
+
+// uniqueInts removes the repeated values of the sorted values.
+func uniqueInts(values []int) []int {
+	unique := values[:0]
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// smplLoop returns the first loop of the smpl chunk of the WAV file, its end
+// made exclusive.
+func smplLoop(src string, info *audioInfo) (start, end int64, ok bool) {
+	c := info.chunk("smpl")
+	if c == nil || c.size < 60 {
+		return 0, 0, false
+	}
+	data, err := readChunk(src, c)
+	if err != nil || len(data) < 60 || binary.LittleEndian.Uint32(data[28:]) == 0 {
+		return 0, 0, false
+	}
+	start, end = int64(binary.LittleEndian.Uint32(data[44:])), int64(binary.LittleEndian.Uint32(data[48:]))
+	return start, end + 1, end > start
+}
+
+// groupZones returns the zones of the samples of the group folder dir: spread
+// over the keyboard when they make a single sample set, otherwise a note per
+// sample like -noteMap so the group plays as a drum kit.
+func groupZones(dir string) ([]samplerZone, error) {
+	mappings, err := groupNoteMappings(dir, *flagNoteMapStart)
+	if err != nil || len(mappings) == 0 {
+		return nil, err
+	}
+	paths := make([]string, len(mappings))
+	for i, m := range mappings {
+		paths[i] = filepath.Join(dir, filepath.FromSlash(m.File))
+	}
+	if _, ok := sampleSet(paths); ok {
+		zones, err := sampleSetZones(paths)
+		for i := range zones {
+			zones[i].File = mappings[i].File
+		}
+		return zones, err
+	}
+	zones := make([]samplerZone, len(mappings))
+	for i, m := range mappings {
+		z := &zones[i]
+		z.File, z.Root, z.KeyLow, z.KeyHigh = m.File, m.Note, m.Note, m.Note
+		z.VelocityLow, z.VelocityHigh, z.RoundRobin = 1, 127, m.RoundRobin
+		if _, _, err := readZoneAudio(z, paths[i]); err != nil {
+			debugf("Couldn't read the length and loop of %s - %s", paths[i], err)
+		}
+	}
+	return zones, nil
+}
+
+// writeSamplerPatch writes the sampler patch of the group folder dir in the
+// format, mapping its samples on the keyboard.
+func writeSamplerPatch(dir, format string) error {
+	zones, err := groupZones(dir)
+	if err != nil || len(zones) == 0 {
+		return err
+	}
+	patch := samplerPatch{Name: filepath.Base(dir), Zones: zones}
+	var data []byte
+	switch format {
+	case "json":
+		data, err = json.MarshalIndent(patch, "", "  ")
+	case "xml":
+		if data, err = xml.MarshalIndent(patch, "", "  "); err == nil {
+			data = append([]byte(xml.Header), data...)
+		}
+	case "sfz":
+		data = sfzPatch(patch)
+	case "dspreset":
+		data, err = decentSamplerPatch(patch)
+	}
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	return os.WriteFile(filepath.Join(dir, samplerPatchName+samplerPatchFormats[format]), data, 0666)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testGroup writes a group folder of WAV files with the names and returns its path.
+func testGroup(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "001")
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	wav, err := os.ReadFile(testWav(t, wavFormatPCM, 1, 44100, 2, 16, make([]byte, 8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), wav, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSamplerPatchSFZ(t *testing.T) {
+	dir := testGroup(t, "kick.wav", "snare_rr1.wav", "snare_rr2.wav")
+	if err := writeSamplerPatch(dir, "sfz"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, samplerPatchName+".sfz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `// 001
+<group>
+<region> lokey=36 hikey=36 pitch_keycenter=36 lovel=1 hivel=127 sample=kick.wav
+<region> lokey=37 hikey=37 pitch_keycenter=37 lovel=1 hivel=127 seq_length=2 seq_position=1 sample=snare_rr1.wav
+<region> lokey=37 hikey=37 pitch_keycenter=37 lovel=1 hivel=127 seq_length=2 seq_position=2 sample=snare_rr2.wav
+`
+	if string(data) != want {
+		t.Errorf("SFZ patch\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestSamplerPatchDecentSampler(t *testing.T) {
+	dir := testGroup(t, "kick.wav", "snare_rr1.wav", "snare_rr2.wav")
+	if err := writeSamplerPatch(dir, "dspreset"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, samplerPatchName+".dspreset"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc decentSamplerPreset
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range doc.Groups.Group.Samples {
+		got = append(got, strings.Join([]string{s.Path, s.SeqMode}, " "))
+		if s.SeqMode != "" && (s.SeqLength != 2 || s.LoNote != 37) {
+			t.Errorf("%s in a sequence of %d on %d; want 2 on 37", s.Path, s.SeqLength, s.LoNote)
+		}
+	}
+	want := "kick.wav , snare_rr1.wav round_robin, snare_rr2.wav round_robin"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("samples %q; want %q", strings.Join(got, ", "), want)
+	}
+	if s := doc.Groups.Group.Samples; len(s) == 3 && (s[1].SeqPosition != 1 || s[2].SeqPosition != 2) {
+		t.Errorf("sequence positions %d and %d; want 1 and 2", s[1].SeqPosition, s[2].SeqPosition)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// roundRobinLengths returns the number of round robin variations sharing the
+// notes and velocities of each zone, 0 for the zones that aren't one.
+func roundRobinLengths(zones []samplerZone) []int {
+	type zoneKey struct{ keyLow, keyHigh, velocityLow, velocityHigh int }
+	counts := map[zoneKey]int{}
+	for _, z := range zones {
+		if z.RoundRobin > 0 {
+			counts[zoneKey{z.KeyLow, z.KeyHigh, z.VelocityLow, z.VelocityHigh}]++
+		}
+	}
+	lengths := make([]int, len(zones))
+	for i, z := range zones {
+		if z.RoundRobin > 0 {
+			lengths[i] = counts[zoneKey{z.KeyLow, z.KeyHigh, z.VelocityLow, z.VelocityHigh}]
+		}
+	}
+	return lengths
+}
+
+// sfzPatch returns the SFZ instrument of the patch, a region per zone with
+// the round robin variations alternating in sequence.
+func sfzPatch(patch samplerPatch) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// %s\n<group>", patch.Name)
+	lengths := roundRobinLengths(patch.Zones)
+	for i, z := range patch.Zones {
+		fmt.Fprintf(&b, "\n<region> lokey=%d hikey=%d pitch_keycenter=%d lovel=%d hivel=%d", z.KeyLow, z.KeyHigh, z.Root, z.VelocityLow, z.VelocityHigh)
+		if lengths[i] > 0 {
+			fmt.Fprintf(&b, " seq_length=%d seq_position=%d", lengths[i], z.RoundRobin)
+		}
+		if z.LoopEnd > 0 {
+			// the SFZ loop end is the last frame of the loop
+			fmt.Fprintf(&b, " loop_mode=loop_continuous loop_start=%d loop_end=%d", z.LoopStart, z.LoopEnd-1)
+		}
+		// the sample comes last, its name running to the end of the line
+		fmt.Fprintf(&b, " sample=%s", z.File)
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// similarBands is the number of log spaced frequency bands of the spectrum
+	// compared by the similar command
+	similarBands = 24
+	// similarSlices is the number of slices of the envelope compared by the
+	// similar command, over the first similarEnvelope of the samples
+	similarSlices   = 10
+	similarEnvelope = 1.0
+	// similarAnalysis is the length in seconds of audio analyzed, long files
+	// are only compared on their beginning
+	similarAnalysis = 4.0
+	// defaultSimilarCount is the number of samples copied by the similar
+	// command when -max isn't set
+	defaultSimilarCount = 20
+)
+
+// audioFeatures describe the sound of a sample to compare it with others: the
+// shape of its spectrum, its envelope and its length.
+type audioFeatures struct {
+	// bands are the levels in dB of the frequency bands relative to their average
+	bands []float64
+	// envelope are the RMS levels in dB of the slices relative to the peak
+	envelope []float64
+	// duration is the length of the sample in seconds
+	duration float64
+}
+
+// fileFeatures computes the features of the WAV or AIFF file at path.
+func fileFeatures(path string) (*audioFeatures, error) {
+	buf, _, err := decodeAudioFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if buf.frames() == 0 || buf.sampleRate == 0 {
+		return nil, fmt.Errorf("no audio")
+	}
+	samples := buf.mixdown()
+	f := &audioFeatures{duration: float64(len(samples)) / float64(buf.sampleRate)}
+	if limit := int(similarAnalysis * float64(buf.sampleRate)); len(samples) > limit {
+		samples = samples[:limit]
+	}
+
+	// average magnitude spectrum
+	hann := hannWindow(spectralWindow)
+	spectrum := make([]float64, spectralWindow/2)
+	bins := make([]complex128, spectralWindow)
+	for start := 0; start < len(samples); start += spectralWindow {
+		for i := range bins {
+			v := 0.0
+			if start+i < len(samples) {
+				v = samples[start+i] * hann[i]
+			}
+			bins[i] = complex(v, 0)
+		}
+		fft(bins)
+		for k := range spectrum {
+			spectrum[k] += cmplx.Abs(bins[k])
+		}
+	}
+	binWidth := float64(buf.sampleRate) / spectralWindow
+	low, high := 40.0, math.Min(16000, float64(buf.sampleRate)/2)
+	f.bands = make([]float64, similarBands)
+	var mean float64
+	for b := range f.bands {
+		from := low * math.Pow(high/low, float64(b)/similarBands)
+		to := low * math.Pow(high/low, float64(b+1)/similarBands)
+		var energy float64
+		for k := int(from / binWidth); k <= int(to/binWidth) && k < len(spectrum); k++ {
+			energy += spectrum[k] * spectrum[k]
+		}
+		f.bands[b] = toDB(math.Sqrt(energy))
+		mean += f.bands[b]
+	}
+	mean /= similarBands
+	for b := range f.bands {
+		f.bands[b] -= mean
+	}
+
+	// envelope relative to the peak
+	f.envelope = make([]float64, similarSlices)
+	slice := int(similarEnvelope * float64(buf.sampleRate) / similarSlices)
+	peak := silenceDB
+	for i := range f.envelope {
+		start, end := i*slice, (i+1)*slice
+		if start >= len(samples) {
+			f.envelope[i] = silenceDB
+			continue
+		}
+		if end > len(samples) {
+			end = len(samples)
+		}
+		var sum float64
+		for _, v := range samples[start:end] {
+			sum += v * v
+		}
+		f.envelope[i] = toDB(math.Sqrt(sum / float64(end-start)))
+		peak = math.Max(peak, f.envelope[i])
+	}
+	for i := range f.envelope {
+		f.envelope[i] -= peak
+	}
+	return f, nil
+}
+
+// similarity returns how close the sounds of the features are, between 0 for
+// unrelated sounds and 1 for identical ones. The spectrum weighs the most,
+// then the envelope and the length.
+func (f *audioFeatures) similarity(other *audioFeatures) float64 {
+	distance := func(a, b []float64) float64 {
+		var sum float64
+		for i := range a {
+			sum += (a[i] - b[i]) * (a[i] - b[i])
+		}
+		return math.Sqrt(sum / float64(len(a)))
+	}
+	d := distance(f.bands, other.bands)/6 +
+		distance(f.envelope, other.envelope)/12 +
+		math.Abs(math.Log2(math.Max(f.duration, 0.01)/math.Max(other.duration, 0.01)))/2
+	return 1 / (1 + d)
+}
+
+// similarStream sends the count units whose first file is the most similar
+// to the features of the reference sample at refPath by decreasing
+// similarity, printing their score. The files that can't be analyzed come
+// last, the reference sample itself is left out.
+func similarStream(in <-chan []string, refPath string, ref *audioFeatures, count int) <-chan []string {
+	refPath, _ = filepath.Abs(refPath)
+	scores := map[string]float64{}
+	sorted := sortedStream(in, func(unit []string) float64 {
+		f, err := fileFeatures(unit[0])
+		if err != nil {
+			debugf("Couldn't analyze %s - %s", unit[0], err)
+			return 0
+		}
+		score := ref.similarity(f)
+		scores[unit[0]] = score
+		return -score
+	})
+	out := make(chan []string)
+	go func() {
+		defer close(out)
+		sent := 0
+		for unit := range sorted {
+			if abs, _ := filepath.Abs(unit[0]); sent == count || abs == refPath {
+				continue
+			}
+			infof("%3.0f%% similar: %s", scores[unit[0]]*100, unit[0])
+			out <- unit
+			sent++
+		}
+	}()
+	return out
+}
+
+// similarFolder returns the name of the destination folder of the similar
+// command when there's no keyword, named after the reference sample.
+func similarFolder(ref string) string {
+	return "similar to " + strings.TrimSuffix(filepath.Base(ref), filepath.Ext(ref))
+}
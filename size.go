@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the suffixes accepted by parseSize. Like storage vendors, KB,
+// MB... are powers of 1000 while KiB, MiB... are powers of 1024.
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"kib", 1 << 10},
+	{"mib", 1 << 20},
+	{"gib", 1 << 30},
+	{"tib", 1 << 40},
+	{"kb", 1e3},
+	{"mb", 1e6},
+	{"gb", 1e9},
+	{"tb", 1e12},
+	{"k", 1e3},
+	{"m", 1e6},
+	{"g", 1e9},
+	{"t", 1e12},
+	{"b", 1},
+}
+
+// parseSize parses a size like 8GB, 512MiB or 1.5G into bytes.
+func parseSize(s string) (int64, error) {
+	str := strings.ToLower(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(str, u.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, u.suffix))
+			multiplier = u.bytes
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(str, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("%q isn't a valid size", s)
+	}
+	return int64(v * float64(multiplier)), nil
+}
+
+// formatSize returns a human readable version of a size in bytes.
+func formatSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	v := float64(size)
+	i := 0
+	for v >= 1000 && i < len(units)-1 {
+		v /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+// unitSize returns the combined size of the files of a unit.
+func unitSize(unit []string) int64 {
+	var size int64
+	for _, path := range unit {
+		if fi, err := os.Stat(path); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size
+}
+
+// freeSpaceMargin is the minimum space left free on the destination on top of
+// the size of the files to copy, for the file system overhead and the files
+// growing when they're processed.
+const freeSpaceMargin = 16 << 20
+
+// checkFreeSpace returns an error if the volume of destPath doesn't have enough
+// free space for size bytes of samples plus a margin of 5%. The check is skipped
+// when the free space can't be queried.
+func checkFreeSpace(destPath string, size int64) error {
+	// the destination folder is usually created by the run
+	dir := existingParent(destPath)
+	available, err := freeSpace(dir)
+	if err != nil {
+		warnf("Couldn't check the free space of %s - %s", dir, err)
+		return nil
+	}
+	margin := size / 20
+	if margin < freeSpaceMargin {
+		margin = freeSpaceMargin
+	}
+	if size+margin > available {
+		return fmt.Errorf("the samples need %s plus a %s margin but only %s are free on the destination volume", formatSize(size), formatSize(margin), formatSize(available))
+	}
+	return nil
+}
+
+// spaceCheckPath returns the path whose volume the files copied to dir are
+// written to, the archive file for a zip archive. There's none for a tar
+// stream to stdout, which takes no room locally.
+func spaceCheckPath(dir string) (string, bool) {
+	switch {
+	case destArchive == nil:
+		return dir, true
+	case destArchive.file == nil:
+		return "", false
+	}
+	return destArchive.path, true
+}
+
+// spaceCheckedStream waits for all the groups of the run to check up front that
+// the volume of destPath has room for their total size, so with
+// -checkSpaceFirst a run that can't fit fails before writing anything rather
+// than with partial groups. It returns a stream replaying the groups, closed
+// right away when they don't fit. It buffers the whole match list and the
+// first copy waits for the end of the walk, the streaming pipeline otherwise
+// checks the space of each group before copying it.
+func spaceCheckedStream(groups <-chan *unitGroup, destPath string) (<-chan *unitGroup, error) {
+	var pending []*unitGroup
+	var total int64
+	for group := range groups {
+		pending = append(pending, group)
+		total += group.size()
+	}
+	out := make(chan *unitGroup, len(pending))
+	defer close(out)
+	if err := checkFreeSpace(destPath, total); err != nil {
+		return out, err
+	}
+	for _, group := range pending {
+		out <- group
+	}
+	return out, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"512", 512, true},
+		{"100b", 100, true},
+		{"8GB", 8e9, true},
+		{"8 gb", 8e9, true},
+		{"1.5G", 1.5e9, true},
+		{"512MiB", 512 << 20, true},
+		{"2KiB", 2048, true},
+		{"1k", 1000, true},
+		{"2TB", 2e12, true},
+		{" 4 MB ", 4e6, true},
+		{"", 0, false},
+		{"GB", 0, false},
+		{"-1GB", 0, false},
+		{"eight", 0, false},
+		{"8XB", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"sync"
+)
+
+// spectralWindow is the size in frames of the FFT windows of the spectral analysis.
+const spectralWindow = 2048
+
+// centroidCache holds the spectral centroids computed so far by path.
+var centroidCache = struct {
+	sync.Mutex
+	centroids map[string]float64
+}{centroids: map[string]float64{}}
+
+// fft computes the discrete Fourier transform of x in place, its length must
+// be a power of 2.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
+
+// spectralCentroid returns the spectral centroid in Hz of the samples, the
+// magnitude weighted mean frequency of their spectrum, averaged over the
+// windows weighted by their energy. The higher it is, the brighter the sound.
+// It returns false for silent audio.
+func spectralCentroid(samples []float64, sampleRate int) (float64, bool) {
+	hann := hannWindow(spectralWindow)
+	binWidth := float64(sampleRate) / spectralWindow
+	bins := make([]complex128, spectralWindow)
+	var weighted, total float64
+	for start := 0; start < len(samples); start += spectralWindow {
+		for i := range bins {
+			v := 0.0
+			if start+i < len(samples) {
+				v = samples[start+i] * hann[i]
+			}
+			bins[i] = complex(v, 0)
+		}
+		fft(bins)
+		var sum, magnitudes float64
+		for k := 1; k < spectralWindow/2; k++ {
+			m := cmplx.Abs(bins[k])
+			sum += float64(k) * binWidth * m
+			magnitudes += m
+		}
+		if magnitudes == 0 {
+			continue
+		}
+		weighted += sum
+		total += magnitudes
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return weighted / total, true
+}
+
+// fileCentroid returns the spectral centroid of the WAV or AIFF file at path.
+func fileCentroid(path string) (float64, bool) {
+	centroidCache.Lock()
+	centroid, ok := centroidCache.centroids[path]
+	centroidCache.Unlock()
+	if ok {
+		return centroid, centroid > 0
+	}
+	buf, _, err := decodeAudioFile(path)
+	if err != nil {
+		debugf("Couldn't measure the brightness of %s - %s", path, err)
+		return 0, false
+	}
+	centroid, _ = spectralCentroid(buf.mixdown(), buf.sampleRate)
+	centroidCache.Lock()
+	centroidCache.centroids[path] = centroid
+	centroidCache.Unlock()
+	return centroid, centroid > 0
+}
+
+// parseBrightnessBuckets parses the comma separated increasing limits in Hz
+// of the brightness buckets.
+func parseBrightnessBuckets(list string) ([]float64, error) {
+	var limits []float64
+	for _, s := range listFlag(list) {
+		hz, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		if len(limits) > 0 && hz <= limits[len(limits)-1] {
+			return nil, fmt.Errorf("%s isn't higher than the previous limit", s)
+		}
+		limits = append(limits, hz)
+	}
+	if len(limits) == 0 {
+		return nil, fmt.Errorf("no limits")
+	}
+	return limits, nil
+}
+
+// brightnessBucket returns the spectral centroid range of the file at path
+// among the -brightnessBuckets, from dark to bright like 0-1500Hz,
+// 1500-4000Hz or 4000Hz+.
+func brightnessBucket(path string) string {
+	centroid, ok := fileCentroid(path)
+	if !ok {
+		return unknownField
+	}
+	limits, _ := parseBrightnessBuckets(*flagBrightnessBuckets)
+	low := "0"
+	for _, limit := range limits {
+		high := strconv.FormatFloat(limit, 'f', -1, 64)
+		if centroid < limit {
+			return low + "-" + high + "Hz"
+		}
+		low = high
+	}
+	return low + "Hz+"
+}
+
+// darkFirstStream sends the units by increasing brightness of their first
+// file, the ones which couldn't be measured last.
+func darkFirstStream(in <-chan []string) <-chan []string {
+	return sortedStream(in, func(unit []string) float64 {
+		if centroid, ok := fileCentroid(unit[0]); ok {
+			return centroid
+		}
+		return math.Inf(1)
+	})
+}
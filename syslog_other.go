@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// newSyslogWriter returns an error, syslog isn't available on this system.
+func newSyslogWriter() (io.Writer, error) {
+	return nil, errors.New("syslog isn't supported on this system")
+}
@@ -0,0 +1,14 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// newSyslogWriter connects to the local syslog daemon, which is journald on
+// most Linux systems.
+func newSyslogWriter() (io.Writer, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "sampleSorter")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// taxonomyEntry maps the filename tokens of a category, the category being a
+// path like Drums/Kick made of its family and its name.
+type taxonomyEntry struct {
+	Category string   `json:"category"`
+	Tokens   []string `json:"tokens"`
+}
+
+// defaultTaxonomy is the taxonomy used unless a custom one is passed. Categories
+// are tested in order so more specific ones need to come first.
+var defaultTaxonomy = []taxonomyEntry{
+	{"Drums/Kick", []string{"kick", "kik", "kck", "bd", "bassdrum"}},
+	{"Drums/Snare", []string{"snare", "snr", "sd"}},
+	{"Drums/Clap", []string{"clap", "clp"}},
+	{"Drums/Rim", []string{"rim", "rimshot", "sidestick"}},
+	{"Drums/Hat", []string{"hihat", "hat", "hats", "hh", "chh", "ohh", "ch", "oh"}},
+	{"Drums/Cymbal", []string{"cymbal", "cym", "crash", "ride", "splash", "china"}},
+	{"Drums/Tom", []string{"tom", "toms", "floortom"}},
+	{"Drums/Percussion", []string{"perc", "shaker", "tambourine", "tamb", "conga", "bongo", "cowbell", "clave", "guiro", "triangle"}},
+	{"Synth/Bass", []string{"bass", "808", "sub", "reese"}},
+	{"Synth/Lead", []string{"lead", "ld"}},
+	{"Synth/Pad", []string{"pad", "pads", "atmos", "atmosphere"}},
+	{"Synth/Pluck", []string{"pluck"}},
+	{"Synth/Arp", []string{"arp"}},
+	{"Synth/Chord", []string{"chord", "stab"}},
+	{"FX", []string{"fx", "sfx", "riser", "sweep", "impact", "uplifter", "downlifter", "whoosh", "noise"}},
+	{"Vocal", []string{"vocal", "vox", "acapella", "chant", "adlib"}},
+	{"Instruments/Keys", []string{"piano", "keys", "rhodes", "organ", "epiano"}},
+	{"Instruments/Guitar", []string{"guitar", "gtr"}},
+	{"Instruments/Strings", []string{"strings", "violin", "viola", "cello"}},
+}
+
+// taxonomy is the taxonomy in use.
+var taxonomy = defaultTaxonomy
+
+// loadTaxonomy replaces the taxonomy in use by the one in the JSON file at path.
+func loadTaxonomy(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var custom []taxonomyEntry
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return fmt.Errorf("couldn't parse the taxonomy - %s", err)
+	}
+	taxonomy = custom
+	return nil
+}
+
+// filenameTokens splits a filename in lowercase alphanumeric tokens.
+func filenameTokens(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9')
+	})
+}
+
+// filenameWords splits a filename in lowercase words: its tokens, further
+// split between letters and digits and at the capitals of camel case names,
+// so 808Kick and BigKick hold the word kick.
+func filenameWords(name string) []string {
+	var words []string
+	var word []rune
+	prev := rune(0)
+	for _, r := range name {
+		alnum := unicode.IsLetter(r) || unicode.IsDigit(r)
+		split := !alnum || unicode.IsDigit(r) != unicode.IsDigit(prev) ||
+			unicode.IsUpper(r) && unicode.IsLower(prev)
+		if split && len(word) > 0 {
+			words = append(words, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+		if alnum {
+			word = append(word, r)
+		}
+		prev = r
+	}
+	if len(word) > 0 {
+		words = append(words, strings.ToLower(string(word)))
+	}
+	return words
+}
+
+// taxonomyClassifier is the default classifier, using the taxonomy in use.
+type taxonomyClassifier struct{}
+
+// Classify returns the taxonomy category of the file at path based on its
+// filename or, failing that, the names of its parent folders.
+// The tokens need to be found as whole words or their plural, kick matching
+// 808Kick or Kicks but organ not matching Organic.
+func (taxonomyClassifier) Classify(path string) (string, bool) {
+	names := []string{strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+	for dir := filepath.Dir(path); filepath.Base(dir) != dir && len(names) < 4; dir = filepath.Dir(dir) {
+		names = append(names, filepath.Base(dir))
+	}
+	for _, name := range names {
+		words := map[string]bool{}
+		for _, t := range filenameTokens(name) {
+			words[t] = true
+		}
+		for _, w := range filenameWords(name) {
+			words[w] = true
+		}
+		for _, entry := range taxonomy {
+			for _, token := range entry.Tokens {
+				if words[token] || words[token+"s"] {
+					return entry.Category, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// categoryName returns the last element of a category, Kick for Drums/Kick.
+func categoryName(category string) string {
+	return category[strings.LastIndex(category, "/")+1:]
+}
+
+// categoryFamily returns the first element of a category, Drums for Drums/Kick.
+func categoryFamily(category string) string {
+	if i := strings.Index(category, "/"); i >= 0 {
+		return category[:i]
+	}
+	return category
+}
+
+// printTaxonomy prints the taxonomy in use as JSON so it can be used as a starting
+// point for a custom one.
+func printTaxonomy() {
+	data, _ := json.MarshalIndent(taxonomy, "", "  ")
+	fmt.Println(string(data))
+}
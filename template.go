@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unknownField is the template value used when a file doesn't have the field.
+const unknownField = "unknown"
+
+var placeholderPattern = regexp.MustCompile(`\{([a-zA-Z]+)\}`)
+
+// templateFields returns the values of the template placeholders for a file.
+var templateFields = map[string]func(path string) string{
+	"bpm": func(path string) string {
+		if bpm, ok := filenameBPM(path); ok {
+			return strconv.FormatFloat(bpm, 'f', -1, 64)
+		}
+		return unknownField
+	},
+	"category": func(path string) string {
+		if category, ok := classify(path); ok {
+			return categoryName(category)
+		}
+		return unknownField
+	},
+	"family": func(path string) string {
+		if category, ok := classify(path); ok {
+			return categoryFamily(category)
+		}
+		return unknownField
+	},
+	"alpha":      alphaBucket,
+	"duration":   durationBucket,
+	"brightness": brightnessBucket,
+	"density":    densityBucket,
+	"channels": func(path string) string {
+		if info, err := readAudioInfo(path); err == nil && info.channels > 0 {
+			return channelLayoutName(info.channels)
+		}
+		return unknownField
+	},
+	"year": func(path string) string {
+		return modTimeField(path, "2006")
+	},
+	"month": func(path string) string {
+		return modTimeField(path, "2006-01")
+	},
+	"samplerate": func(path string) string {
+		if info, err := readAudioInfo(path); err == nil && info.sampleRate > 0 {
+			return strconv.Itoa(info.sampleRate)
+		}
+		return unknownField
+	},
+	"loop": func(path string) string {
+		looped, ok := hasLoopPoints(path)
+		switch {
+		case !ok:
+			return unknownField
+		case looped:
+			return "looped"
+		}
+		return "oneshot"
+	},
+	"keyword": func(path string) string {
+		if keyword, ok := matchedKeyword(strings.ToLower(filepath.Base(path))); ok && keyword != "" {
+			return keyword
+		}
+		return unknownField
+	},
+	"key": func(path string) string {
+		if key, ok := filenameKey(path); ok {
+			return key.String()
+		}
+		return unknownField
+	},
+}
+
+// modTimeField formats the modification time of the file at path with the layout.
+func modTimeField(path, layout string) string {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return unknownField
+	}
+	return fi.ModTime().Format(layout)
+}
+
+// channelLayoutName returns the folder name of the files with that many channels.
+func channelLayoutName(channels int) string {
+	switch channels {
+	case 1:
+		return "mono"
+	case 2:
+		return "stereo"
+	}
+	return strconv.Itoa(channels) + "ch"
+}
+
+// alphaBuckets are the folders of the files starting with a letter with -groupBy alpha.
+var alphaBuckets = []string{"A-D", "E-H", "I-L", "M-P", "Q-T", "U-X", "Y-Z"}
+
+// alphaBucket returns the alphabetical bucket of the filename of path, the
+// names starting with a digit go to 0-9 and the others to #.
+func alphaBucket(path string) string {
+	name := strings.ToUpper(filepath.Base(path))
+	switch {
+	case name == "":
+		return "#"
+	case name[0] >= 'A' && name[0] <= 'Z':
+		return alphaBuckets[(name[0]-'A')/4]
+	case name[0] >= '0' && name[0] <= '9':
+		return "0-9"
+	}
+	return "#"
+}
+
+// parseDurationBuckets parses the comma separated increasing limits of the duration buckets.
+func parseDurationBuckets(list string) ([]time.Duration, error) {
+	var limits []time.Duration
+	for _, s := range listFlag(list) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+		if len(limits) > 0 && d <= limits[len(limits)-1] {
+			return nil, fmt.Errorf("%s isn't longer than the previous limit", s)
+		}
+		limits = append(limits, d)
+	}
+	if len(limits) == 0 {
+		return nil, fmt.Errorf("no limits")
+	}
+	return limits, nil
+}
+
+// durationBucket returns the duration range of the file at path among the
+// -durationBuckets, like 0-1s, 1-5s or 5s+.
+func durationBucket(path string) string {
+	info, err := readAudioInfo(path)
+	if err != nil {
+		return unknownField
+	}
+	d, ok := info.duration()
+	if !ok {
+		return unknownField
+	}
+	limits, _ := parseDurationBuckets(*flagDurationBuckets)
+	low := "0"
+	for _, limit := range limits {
+		high := strconv.FormatFloat(limit.Seconds(), 'f', -1, 64)
+		if d < limit {
+			return low + "-" + high + "s"
+		}
+		low = high
+	}
+	return low + "s+"
+}
+
+// groupByTemplate returns the subfolders template sorting the matches by the
+// comma separated fields, each in its own level of subfolders.
+func groupByTemplate(groupBy string) (string, error) {
+	var levels []string
+	for _, field := range strings.Split(groupBy, ",") {
+		field = strings.TrimSpace(field)
+		if _, ok := templateFields[field]; !ok {
+			return "", fmt.Errorf("can't group by %s, available: %s", field, registeredNames(templateFields))
+		}
+		levels = append(levels, "{"+field+"}")
+	}
+	return strings.Join(levels, "/"), nil
+}
+
+// checkTemplate returns an error if the template uses unknown placeholders.
+func checkTemplate(template string) error {
+	for _, m := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		if _, ok := templateFields[m[1]]; !ok {
+			return fmt.Errorf("unknown placeholder {%s}", m[1])
+		}
+	}
+	return nil
+}
+
+// renderTemplate replaces the placeholders of the template by the values of the
+// file at path. The rendered values can't contain path separators.
+func renderTemplate(template, path string) string {
+	return placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		field := templateFields[strings.Trim(placeholder, "{}")]
+		if field == nil {
+			return placeholder
+		}
+		return strings.ReplaceAll(field(path), string(filepath.Separator), "_")
+	})
+}
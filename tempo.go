@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// bpmPattern matches tempos written like 120bpm, 85 BPM or 128_bpm in filenames.
+	bpmPattern = regexp.MustCompile(`(?i)(?:^|[^0-9.])([0-9]{2,3}(?:\.[0-9]+)?)[\s_-]?bpm`)
+	// bareBPMPattern matches bare numbers surrounded by separators like _140_,
+	// which is how a lot of packs write tempos.
+	bareBPMPattern = regexp.MustCompile(`[\s_-]([0-9]{2,3})[\s_-]`)
+)
+
+// filenameBPM returns the tempo found in the filename of path.
+func filenameBPM(path string) (float64, bool) {
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if m := bpmPattern.FindStringSubmatch(stem); m != nil {
+		if bpm, err := strconv.ParseFloat(m[1], 64); err == nil && bpm >= 40 && bpm <= 300 {
+			return bpm, true
+		}
+	}
+	// bare numbers are more likely to be something else, stick to common tempos
+	for _, m := range bareBPMPattern.FindAllStringSubmatch(stem, -1) {
+		if bpm, err := strconv.ParseFloat(m[1], 64); err == nil && bpm >= 60 && bpm <= 200 {
+			return bpm, true
+		}
+	}
+	return 0, false
+}
+
+// loopTempo guesses the tempo of a loop from its length, assuming it's made of
+// a whole number of 4/4 bars and has a tempo between 70 and 180 BPM.
+func loopTempo(frames, sampleRate int) (float64, bool) {
+	if frames == 0 || sampleRate == 0 {
+		return 0, false
+	}
+	seconds := float64(frames) / float64(sampleRate)
+	for _, bars := range []float64{1, 2, 4, 8, 16} {
+		if bpm := bars * 4 * 60 / seconds; bpm >= 70 && bpm < 180 {
+			return bpm, true
+		}
+	}
+	return 0, false
+}
+
+// sourceTempo returns the tempo of the src loop from its filename or, for
+// files named as loops, guessed from its length.
+func sourceTempo(src string, frames, sampleRate int) (float64, bool) {
+	if bpm, ok := filenameBPM(src); ok {
+		return bpm, true
+	}
+	if strings.Contains(strings.ToLower(filepath.Base(src)), "loop") {
+		return loopTempo(frames, sampleRate)
+	}
+	return 0, false
+}
+
+// densityCache holds the onset densities measured so far by path, 0 for the
+// files which aren't loops.
+var densityCache = struct {
+	sync.Mutex
+	densities map[string]float64
+}{densities: map[string]float64{}}
+
+// onsetDensity returns the number of hits per 4/4 bar of the loop at path,
+// from the transients detected at -chopThreshold and its tempo. It returns
+// false for the files that aren't loops or can't be decoded.
+func onsetDensity(path string) (float64, bool) {
+	densityCache.Lock()
+	density, ok := densityCache.densities[path]
+	densityCache.Unlock()
+	if ok {
+		return density, density > 0
+	}
+	if buf, _, err := decodeAudioFile(path); err == nil && buf.sampleRate > 0 {
+		if bpm, ok := sourceTempo(path, buf.frames(), buf.sampleRate); ok {
+			bars := float64(buf.frames()) / float64(buf.sampleRate) / (4 * 60 / bpm)
+			if bars > 0 {
+				density = float64(len(detectOnsets(buf, *flagChopThreshold))) / bars
+			}
+		}
+	} else if err != nil {
+		debugf("Couldn't measure the onset density of %s - %s", path, err)
+	}
+	densityCache.Lock()
+	densityCache.densities[path] = density
+	densityCache.Unlock()
+	return density, density > 0
+}
+
+// densityBucket returns the onset density range of the loop at path among
+// the -densityBuckets, like 0-4, 4-8 or 8+ hits per bar.
+func densityBucket(path string) string {
+	density, ok := onsetDensity(path)
+	if !ok {
+		return unknownField
+	}
+	limits, _ := parseDensityBuckets(*flagDensityBuckets)
+	low := "0"
+	for _, limit := range limits {
+		high := strconv.FormatFloat(limit, 'f', -1, 64)
+		if density < limit {
+			return low + "-" + high + " hits per bar"
+		}
+		low = high
+	}
+	return low + "+ hits per bar"
+}
+
+// parseDensityBuckets parses the comma separated increasing limits in hits
+// per bar of the density buckets.
+func parseDensityBuckets(list string) ([]float64, error) {
+	var limits []float64
+	for _, s := range listFlag(list) {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		if len(limits) > 0 && v <= limits[len(limits)-1] {
+			return nil, fmt.Errorf("%s isn't higher than the previous limit", s)
+		}
+		limits = append(limits, v)
+	}
+	if len(limits) == 0 {
+		return nil, fmt.Errorf("no limits")
+	}
+	return limits, nil
+}
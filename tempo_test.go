@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFilenameBPM(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"Loop_120bpm.wav", 120, true},
+		{"Drums 85 BPM.wav", 85, true},
+		{"Bass-128_bpm.aif", 128, true},
+		{"Break_96.5bpm.wav", 96.5, true},
+		{"Groove_140_Am.wav", 140, true},
+		{filepath.Join("Loops 90", "Top_174bpm.wav"), 174, true},
+		{"Pad_30bpm.wav", 0, false},
+		{"Synth_250_C.wav", 0, false},
+		{"Master_2024_final.wav", 0, false},
+		{"Kick_01.wav", 0, false},
+		{"Snare.wav", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := filenameBPM(tt.in)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("filenameBPM(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
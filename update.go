@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is the version of the binary, set when building a release with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// latestReleaseURL is the GitHub API endpoint describing the latest release.
+const latestReleaseURL = "https://api.github.com/repos/mattetti/sampleSorter/releases/latest"
+
+// checksumsAsset is the release asset listing the SHA-256 digests of the
+// binaries, in the sha256sum format.
+const checksumsAsset = "checksums.txt"
+
+// signatureAsset is the release asset holding the base64 encoded Ed25519
+// signature of the checksums.
+const signatureAsset = checksumsAsset + ".sig"
+
+// releasePublicKey is the base64 encoded Ed25519 public key the checksums of
+// the releases are signed with, set when building a release with
+// -ldflags "-X main.releasePublicKey=...". Without it the checksums only catch
+// a corrupted download, not a compromised release, since they come from the
+// same release as the binary.
+var releasePublicKey = ""
+
+// updateTimeout bounds the requests made to check and download the updates.
+const updateTimeout = 5 * time.Minute
+
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// assetURL returns the download URL of the release asset with the given name.
+func (r *githubRelease) assetURL(name string) (string, bool) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset.URL, true
+		}
+	}
+	return "", false
+}
+
+// releaseBinaryName returns the name of the release asset of the binary for
+// the current platform, e.g. sampleSorter_darwin_arm64.
+func releaseBinaryName() string {
+	name := fmt.Sprintf("sampleSorter_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// selfUpdate replaces the running binary by the one of the latest GitHub
+// release when it's newer, after checking its SHA-256 digest against the
+// checksums of the release, themselves checked against the release key of the
+// build when it has one. With -dry, it only reports the available version.
+func selfUpdate() error {
+	client := &http.Client{Timeout: updateTimeout}
+	resp, err := client.Get(latestReleaseURL)
+	if err != nil {
+		return fmt.Errorf("couldn't check the latest release - %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("couldn't check the latest release, GitHub replied with %s", resp.Status)
+	}
+	var release githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return fmt.Errorf("couldn't parse the latest release - %s", err)
+	}
+	cmp, ok := compareVersions(release.TagName, version)
+	switch {
+	case release.TagName == version || ok && cmp == 0:
+		successf("Already up to date (%s)", version)
+		return nil
+	case ok && cmp < 0:
+		return fmt.Errorf("the latest release %s is older than the running %s, not downgrading", release.TagName, version)
+	case !ok && version != "dev":
+		return fmt.Errorf("couldn't compare the latest release %s with the running %s, not updating", release.TagName, version)
+	}
+	infof("Version %s is available, running %s", highlight(release.TagName), version)
+	if *flagDryRun {
+		return nil
+	}
+
+	name := releaseBinaryName()
+	binaryURL, ok := release.assetURL(name)
+	if !ok {
+		return fmt.Errorf("the release %s has no binary for %s/%s", release.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	checksumsURL, ok := release.assetURL(checksumsAsset)
+	if !ok {
+		return fmt.Errorf("the release %s has no %s to verify the binary", release.TagName, checksumsAsset)
+	}
+	checksums, err := fetch(client, checksumsURL)
+	if err != nil {
+		return fmt.Errorf("couldn't download the checksums - %s", err)
+	}
+	if releasePublicKey == "" {
+		warnf("This build has no release key, the download is only checked against the checksums of the same release")
+	} else {
+		signatureURL, ok := release.assetURL(signatureAsset)
+		if !ok {
+			return fmt.Errorf("the release %s has no %s to verify the checksums", release.TagName, signatureAsset)
+		}
+		signature, err := fetch(client, signatureURL)
+		if err != nil {
+			return fmt.Errorf("couldn't download the signature - %s", err)
+		}
+		if err := verifySignature(releasePublicKey, checksums, signature); err != nil {
+			return fmt.Errorf("the checksums of the release %s %s, not updating", release.TagName, err)
+		}
+	}
+	expected, err := releaseChecksum(checksums, name)
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("couldn't find the running binary - %s", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("couldn't find the running binary - %s", err)
+	}
+	// download next to the binary so it can be renamed in place
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".samplesorter-update")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	digest, err := download(client, binaryURL, tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't download %s - %s", name, err)
+	}
+	if digest != expected {
+		return fmt.Errorf("the checksum of the downloaded %s doesn't match the release, not updating", name)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	// a running binary can't be replaced on Windows but it can be renamed
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return fmt.Errorf("couldn't replace %s - %s", exe, err)
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		os.Rename(old, exe)
+		return fmt.Errorf("couldn't replace %s - %s", exe, err)
+	}
+	os.Remove(old)
+	successf("Updated to %s", release.TagName)
+	return nil
+}
+
+// releaseChecksum returns the SHA-256 digest of the asset listed in checksums.
+func releaseChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		// sha256sum prints the digest and the name, with a * before binary files
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s in the release", name)
+}
+
+// verifySignature checks the base64 encoded Ed25519 signature of data with
+// the base64 encoded public key.
+func verifySignature(publicKey string, data, signature []byte) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("can't be verified, the release key of this build is invalid")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), data, sig) {
+		return fmt.Errorf("aren't signed by the release key")
+	}
+	return nil
+}
+
+// fetch returns the content at url, the small assets of a release.
+func fetch(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub replied with %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// compareVersions compares the release versions a and b like v1.2.3, ignoring
+// their pre-release or build suffix, and returns -1, 0 or 1. ok is false when
+// one of them isn't a version, like dev.
+func compareVersions(a, b string) (cmp int, ok bool) {
+	parse := func(v string) ([3]int, bool) {
+		var parts [3]int
+		v = strings.TrimPrefix(v, "v")
+		if i := strings.IndexAny(v, "-+"); i >= 0 {
+			v = v[:i]
+		}
+		fields := strings.Split(v, ".")
+		if len(fields) == 0 || len(fields) > 3 {
+			return parts, false
+		}
+		for i, field := range fields {
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 0 {
+				return parts, false
+			}
+			parts[i] = n
+		}
+		return parts, true
+	}
+	va, okA := parse(a)
+	vb, okB := parse(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	for i := range va {
+		switch {
+		case va[i] < vb[i]:
+			return -1, true
+		case va[i] > vb[i]:
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+// download writes the content at url to w and returns its hex encoded SHA-256 digest.
+func download(client *http.Client, url string, w io.Writer) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("the server replied with %s", resp.Status)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), resp.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		cmp  int
+		ok   bool
+	}{
+		{"v1.2.3", "v1.2.3", 0, true},
+		{"v1.2.3", "v1.10.0", -1, true},
+		{"v2.0.0", "v1.9.9", 1, true},
+		{"1.2", "v1.2.0", 0, true},
+		{"v1.3.0-rc1", "v1.3.0", 0, true},
+		{"v1.3.0+build", "v1.2.9", 1, true},
+		{"dev", "v1.0.0", 0, false},
+		{"v1.0.0", "v1.x", 0, false},
+		{"v1.2.3.4", "v1.2.3", 0, false},
+	}
+	for _, tt := range tests {
+		if cmp, ok := compareVersions(tt.a, tt.b); cmp != tt.cmp || ok != tt.ok {
+			t.Errorf("compareVersions(%q, %q) = %d, %v; want %d, %v", tt.a, tt.b, cmp, ok, tt.cmp, tt.ok)
+		}
+	}
+}
+
+func TestReleaseChecksum(t *testing.T) {
+	checksums := []byte("ABCDEF  sampleSorter_linux_amd64\n123456 *sampleSorter_windows_amd64.exe\n")
+	tests := []struct {
+		name, want string
+		ok         bool
+	}{
+		{"sampleSorter_linux_amd64", "abcdef", true},
+		{"sampleSorter_windows_amd64.exe", "123456", true},
+		{"sampleSorter_darwin_arm64", "", false},
+		{"sampleSorter_linux", "", false},
+	}
+	for _, tt := range tests {
+		got, err := releaseChecksum(checksums, tt.name)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("releaseChecksum(%s) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString(public)
+	data := []byte("abcdef  sampleSorter_linux_amd64\n")
+	signature := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(private, data)) + "\n")
+	tests := []struct {
+		name      string
+		key       string
+		data      []byte
+		signature []byte
+		ok        bool
+	}{
+		{"signed", key, data, signature, true},
+		{"tampered checksums", key, append([]byte("0"), data...), signature, false},
+		{"other key", base64.StdEncoding.EncodeToString(otherPublic), data, signature, false},
+		{"invalid key", "not a key", data, signature, false},
+		{"invalid signature", key, data, []byte("not a signature"), false},
+	}
+	for _, tt := range tests {
+		if err := verifySignature(tt.key, tt.data, tt.signature); (err == nil) != tt.ok {
+			t.Errorf("%s: verifySignature returned %v", tt.name, err)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	content := []byte("binary")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sampleSorter" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	digest, err := download(server.Client(), server.URL+"/sampleSorter", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	if digest != hex.EncodeToString(sum[:]) || !bytes.Equal(out.Bytes(), content) {
+		t.Errorf("downloaded %q with the digest %s; want %q with %x", out.Bytes(), digest, content, sum)
+	}
+	if _, err := download(server.Client(), server.URL+"/missing", &out); err == nil {
+		t.Error("no error for a missing asset")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// validateSamples walks the source folder and reports the audio files with a
+// malformed header or layout along with the reasons.
+// If a keyword was passed, only the matching files are checked.
+func validateSamples(sourcePath string) {
+	paths := make(chan string, 64)
+	var walkErr error
+	go func() {
+		walkErr = findMatchingFiles(context.Background(), sourcePath, paths)
+		close(paths)
+	}()
+
+	malformed, checked := 0, 0
+	for path := range paths {
+		checked++
+		var problems []string
+		info, err := readAudioInfo(path)
+		if err != nil {
+			problems = []string{err.Error()}
+		} else {
+			problems = info.problems()
+		}
+		if len(problems) == 0 {
+			for _, quirk := range info.quirks() {
+				infof("%s can be fixed with -repair: %s", path, quirk)
+			}
+			debugf("%s is valid (%s %d bit %dHz)", path, info.codecName(), info.bitDepth, info.sampleRate)
+			continue
+		}
+		malformed++
+		fmt.Println(path)
+		for _, problem := range problems {
+			fmt.Println("\t-", problem)
+		}
+	}
+	if walkErr != nil {
+		errorf("Something went wrong looking for audio files - %s", walkErr)
+		os.Exit(exitFatal)
+	}
+	fmt.Printf("%d malformed files out of %d checked\n", malformed, checked)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// walkCache holds the listings of the source folders between runs, keyed by
+// folder path. A folder whose modification time didn't change since it was
+// listed has the same entries, so they're taken from the cache instead of
+// reading the folder and stating each file, which is slow on network drives
+// and spinning disks. The subfolders are still stated to check their time.
+// Since editing a file in place doesn't change the time of its folder, the
+// sizes and times of the cached files can be out of date.
+type walkCache struct {
+	path string
+	// folders are the listings loaded from the cache file, visited the ones
+	// listed by this run
+	folders, visited map[string]*cachedFolder
+	// hits and misses count the folders listed from the cache and from the disk
+	hits, misses int
+}
+
+type cachedFolder struct {
+	ModTime int64         `json:"modTime"`
+	Entries []cachedEntry `json:"entries"`
+}
+
+type cachedEntry struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime int64       `json:"modTime"`
+}
+
+// cachedFileInfo is the os.FileInfo of a cached entry.
+type cachedFileInfo struct{ e *cachedEntry }
+
+func (fi cachedFileInfo) Name() string       { return fi.e.Name }
+func (fi cachedFileInfo) Size() int64        { return fi.e.Size }
+func (fi cachedFileInfo) Mode() fs.FileMode  { return fi.e.Mode }
+func (fi cachedFileInfo) ModTime() time.Time { return time.Unix(0, fi.e.ModTime) }
+func (fi cachedFileInfo) IsDir() bool        { return fi.e.Mode.IsDir() }
+func (fi cachedFileInfo) Sys() interface{}   { return nil }
+
+// loadWalkCache loads the walk cache file at path, a missing file is an empty cache.
+func loadWalkCache(path string) (*walkCache, error) {
+	c := &walkCache{path: path, folders: map[string]*cachedFolder{}, visited: map[string]*cachedFolder{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.folders); err != nil {
+		// a broken cache is rebuilt
+		warnf("Ignoring the unreadable walk cache %s - %s", path, err)
+	}
+	return c, nil
+}
+
+// save writes the cache file. After a complete walk only the visited folders
+// are kept so the deleted folders are forgotten.
+func (c *walkCache) save(complete bool) error {
+	folders := c.visited
+	if !complete {
+		for path, folder := range c.folders {
+			if _, ok := folders[path]; !ok {
+				folders[path] = folder
+			}
+		}
+	}
+	data, err := json.Marshal(folders)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".samplesorter-walkcache")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// list returns the entries of the folder dir, sorted by name.
+func (c *walkCache) list(dir string, info os.FileInfo) ([]cachedEntry, error) {
+	if folder, ok := c.folders[dir]; ok && folder.ModTime == info.ModTime().UnixNano() {
+		c.hits++
+		c.visited[dir] = folder
+		return folder.Entries, nil
+	}
+	c.misses++
+	names, err := readDirNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	folder := &cachedFolder{ModTime: info.ModTime().UnixNano()}
+	for _, name := range names {
+		fi, err := os.Lstat(filepath.Join(dir, name))
+		if err != nil {
+			// don't cache a listing that couldn't be completed
+			folder = nil
+			break
+		}
+		folder.Entries = append(folder.Entries, cachedEntry{Name: name, Size: fi.Size(), Mode: fi.Mode(), ModTime: fi.ModTime().UnixNano()})
+	}
+	if folder == nil {
+		// let the walk report the files that can't be stated
+		entries := make([]cachedEntry, len(names))
+		for i, name := range names {
+			entries[i] = cachedEntry{Name: name, Mode: fs.ModeIrregular}
+		}
+		return entries, nil
+	}
+	c.visited[dir] = folder
+	return folder.Entries, nil
+}
+
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// walk walks the tree rooted at root like filepath.Walk, listing the folders
+// through the cache.
+func (c *walkCache) walk(root string, fn filepath.WalkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = c.walkFolder(root, info, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (c *walkCache) walkFolder(path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+	entries, err := c.list(path, info)
+	if err1 := fn(path, info, err); err != nil || err1 != nil {
+		// the folder couldn't be read or fn skips it
+		return err1
+	}
+	for i := range entries {
+		filename := filepath.Join(path, entries[i].Name)
+		var fi os.FileInfo = cachedFileInfo{&entries[i]}
+		// the subfolders are stated again since their time changes without the
+		// time of their parent, as are the entries that couldn't be stated
+		// when listing the folder
+		if entries[i].Mode.IsDir() || entries[i].Mode == fs.ModeIrregular {
+			if fi, err = os.Lstat(filename); err != nil {
+				if err := fn(filename, nil, err); err != nil && err != filepath.SkipDir {
+					return err
+				}
+				continue
+			}
+		}
+		if err := c.walkFolder(filename, fi, fn); err != nil {
+			if !fi.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}